@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	auditLogFile = "audit.log"
+
+	auditTypeCommand  = "command"
+	auditTypeConfig   = "config"
+	auditTypeSchedule = "schedule"
+
+	auditOriginREST     = "rest"
+	auditOriginStation  = "station"
+	auditOriginProxy    = "proxy"
+	auditOriginMQTT     = "mqtt"
+	auditOriginSchedule = "schedule"
+
+	defaultAuditSearchLimit = 100
+)
+
+type auditEntry struct {
+	Time     string `json:"time"`
+	Type     string `json:"type"`
+	Origin   string `json:"origin"`
+	User     string `json:"user,omitempty"`
+	Station  string `json:"station,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Effector string `json:"effector,omitempty"`
+	Action   string `json:"action,omitempty"`
+	Duration *int   `json:"duration,omitempty"`
+	Result   string `json:"result"`
+	Message  string `json:"msg,omitempty"`
+	Elapsed  int64  `json:"elapsed"`
+}
+
+type auditFilter struct {
+	Type     string
+	Origin   string
+	User     string
+	Station  string
+	Source   string
+	Effector string
+	From     time.Time
+	To       time.Time
+	Limit    int
+}
+
+type auditLog struct {
+	path  string
+	file  *os.File
+	mutex sync.Mutex
+}
+
+func openAuditLog(dataPath string) (*auditLog, error) {
+	if err := os.MkdirAll(dataPath, 0700); err != nil {
+		return nil, fmt.Errorf("Unable to create data path: %v", err)
+	}
+
+	path := filepath.Join(dataPath, auditLogFile)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open audit log: %v", err)
+	}
+	return &auditLog{path: path, file: file}, nil
+}
+
+func (audit *auditLog) Close() error {
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	return audit.file.Close()
+}
+
+func (audit *auditLog) Record(entry auditEntry) {
+	if entry.Time == "" {
+		entry.Time = time.Now().Format(time.RFC3339)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("[Audit] Unable to generate audit entry: %v", err)
+		return
+	}
+
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	if _, err = audit.file.Write(append(data, '\n')); err != nil {
+		log.Printf("[Audit] Unable to write audit entry: %v", err)
+	}
+}
+
+func (audit *auditLog) RecordChange(origin, userName, message string) {
+	log.Printf("[Audit] %s", message)
+	audit.Record(auditEntry{
+		Type:    auditTypeConfig,
+		Origin:  origin,
+		User:    userName,
+		Result:  "Ok",
+		Message: message,
+	})
+}
+
+func (audit *auditLog) SendCommand(mon *monitor, cmd *command, origin, userName string) error {
+	start := time.Now()
+	err := mon.SendCommand(cmd)
+	entry := auditEntry{
+		Time:     start.Format(time.RFC3339),
+		Type:     auditTypeCommand,
+		Origin:   origin,
+		User:     userName,
+		Source:   mon.Name(),
+		Effector: cmd.Name,
+		Action:   cmd.Action,
+		Duration: cmd.Duration,
+		Result:   "Ok",
+		Elapsed:  time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		entry.Result = "Failure"
+		entry.Message = err.Error()
+	}
+	audit.Record(entry)
+	return err
+}
+
+func (audit *auditLog) Search(filter auditFilter) ([]auditEntry, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditSearchLimit
+	}
+
+	file, err := os.Open(audit.path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	out := []auditEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if filter.matches(&entry) {
+			out = append(out, entry)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read audit log: %v", err)
+	}
+
+	// Newest entries first
+	for left, right := 0, len(out)-1; left < right; left, right = left+1, right-1 {
+		out[left], out[right] = out[right], out[left]
+	}
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (filter *auditFilter) matches(entry *auditEntry) bool {
+	if (filter.Type != "" && entry.Type != filter.Type) ||
+		(filter.Origin != "" && entry.Origin != filter.Origin) ||
+		(filter.User != "" && entry.User != filter.User) ||
+		(filter.Station != "" && entry.Station != filter.Station) ||
+		(filter.Source != "" && entry.Source != filter.Source) ||
+		(filter.Effector != "" && entry.Effector != filter.Effector) {
+		return false
+	}
+
+	if !filter.From.IsZero() || !filter.To.IsZero() {
+		timeStamp, err := time.Parse(time.RFC3339, entry.Time)
+		if err != nil {
+			return false
+		}
+		if (!filter.From.IsZero() && timeStamp.Before(filter.From)) || (!filter.To.IsZero() && timeStamp.After(filter.To)) {
+			return false
+		}
+	}
+	return true
+}
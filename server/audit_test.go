@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAuditLogRecordsAndSearches(t *testing.T) {
+	dataPath := t.TempDir()
+	audit, err := openAuditLog(dataPath)
+	if err != nil {
+		t.Fatalf("unable to open audit log: %v", err)
+	}
+
+	// The recorded changes take the current time, so the commands go in a day earlier
+	base := time.Now().UTC().Add(-24 * time.Hour).Truncate(time.Second)
+	audit.Record(auditEntry{Time: base.Format(time.RFC3339), Type: auditTypeCommand, Origin: auditOriginREST, User: "alex", Source: "Pump", Effector: "valve", Action: "on", Result: "Ok"})
+	audit.Record(auditEntry{Time: base.Add(time.Hour).Format(time.RFC3339), Type: auditTypeCommand, Origin: auditOriginProxy, User: "sam", Station: "Greenhouse", Source: "Vent", Effector: "motor", Action: "off", Result: "Ok"})
+	audit.RecordChange(auditOriginREST, "admin", "User sam saved with roles [kids] (disabled: false)")
+
+	// A command for an effector the source does not have is still recorded, as a failure
+	if err = audit.SendCommand(&monitor{name: "Pump"}, &command{Name: "sprinkler", Action: "on"}, auditOriginSchedule, ""); err == nil {
+		t.Fatal("expected the unknown effector to fail")
+	}
+	audit.Close()
+
+	// Lines that cannot be read are skipped rather than failing the search
+	file, _ := os.OpenFile(audit.path, os.O_APPEND|os.O_WRONLY, 0600)
+	file.WriteString("not json\n")
+	file.Close()
+
+	audit, err = openAuditLog(dataPath)
+	if err != nil {
+		t.Fatalf("unable to reopen audit log: %v", err)
+	}
+	defer audit.Close()
+
+	all, err := audit.Search(auditFilter{})
+	if err != nil || len(all) != 4 {
+		t.Fatalf("expected four entries kept across a reopen, got %d: %v", len(all), err)
+	}
+	if failed := all[0]; failed.Origin != auditOriginSchedule || failed.Result != "Failure" || failed.Effector != "sprinkler" || failed.Message == "" {
+		t.Fatalf("expected the failed schedule command first, got %+v", failed)
+	}
+
+	commands, _ := audit.Search(auditFilter{Type: auditTypeCommand, Source: "Pump"})
+	if len(commands) != 2 {
+		t.Fatalf("expected two pump commands, got %+v", commands)
+	}
+	if byUser, _ := audit.Search(auditFilter{User: "sam"}); len(byUser) != 1 || byUser[0].Station != "Greenhouse" {
+		t.Fatalf("expected the greenhouse command by sam, got %+v", byUser)
+	}
+	window, _ := audit.Search(auditFilter{From: base.Add(30 * time.Minute), To: base.Add(90 * time.Minute)})
+	if len(window) != 1 || window[0].Effector != "motor" {
+		t.Fatalf("expected only the command inside the window, got %+v", window)
+	}
+	if limited, _ := audit.Search(auditFilter{Limit: 1}); len(limited) != 1 || limited[0].Effector != "sprinkler" {
+		t.Fatalf("expected the newest entry only, got %+v", limited)
+	}
+}
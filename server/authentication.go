@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	sessionCookie = "jarvis-session"
+
+	// Names expected by the Angular client's XSRF support
+	csrfCookie = "XSRF-TOKEN"
+	csrfHeader = "X-XSRF-TOKEN"
+)
+
+type userContextKey struct{}
+
+var publicAPIPaths = map[string]bool{
+	"/api/info":  true,
+	"/api/login": true,
+}
+
+func userFromRequest(req *http.Request) *user {
+	item, _ := req.Context().Value(userContextKey{}).(*user)
+	return item
+}
+
+func userNameFromRequest(req *http.Request) string {
+	if item := userFromRequest(req); item != nil {
+		return item.Name
+	}
+	return ""
+}
+
+func (api *webAPI) authenticateUsersMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if api.users == nil || publicAPIPaths[req.URL.Path] || stationFromRequest(req) != "" {
+			handler.ServeHTTP(resp, req)
+			return
+		}
+
+		item, fromSession := api.findRequestUser(req)
+		if item == nil {
+			log.Printf("[Security] Rejecting unauthenticated request from %s", req.RemoteAddr)
+			api.writeStatusJSON(resp, http.StatusUnauthorized, "Unauthorized", "Authentication required")
+			return
+		}
+		if fromSession && !isSafeMethod(req.Method) && !hasValidCSRFToken(req) {
+			log.Printf("[Security] Rejecting request without a valid CSRF token from %s", req.RemoteAddr)
+			api.writeStatusJSON(resp, http.StatusForbidden, "Forbidden", "Invalid CSRF token")
+			return
+		}
+		handler.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), userContextKey{}, item)))
+	})
+}
+
+func (api *webAPI) findRequestUser(req *http.Request) (*user, bool) {
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return api.users.FindToken(strings.TrimPrefix(auth, "Bearer ")), false
+	}
+
+	// Clients that can only be given a URL (such as the robot downloading speech) pass the token as a parameter
+	if token := req.URL.Query().Get("token"); token != "" {
+		return api.users.FindToken(token), false
+	}
+
+	if cookie, err := req.Cookie(sessionCookie); err == nil {
+		return api.users.FindSession(cookie.Value), true
+	}
+	return nil, false
+}
+
+func isSafeMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS"
+}
+
+func hasValidCSRFToken(req *http.Request) bool {
+	cookie, err := req.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(req.Header.Get(csrfHeader))) == 1
+}
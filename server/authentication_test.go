@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoginSessionAndCSRF(t *testing.T) {
+	dataPath := t.TempDir()
+	logged := captureLog(t)
+	users, err := loadUserStore(dataPath, &authenticationConfiguration{})
+	if err != nil {
+		t.Fatalf("unable to create user store: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dataPath, adminPasswordFile))
+	if err != nil {
+		t.Fatalf("expected the admin password in a file: %v", err)
+	}
+	password := strings.TrimSpace(string(data))
+	if strings.Contains(logged.String(), password) {
+		t.Fatal("expected the admin password to be kept out of the log")
+	}
+
+	api := &webAPI{config: &appConfiguration{}, users: users}
+	handler := api.authenticateUsersMiddleware(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/login" {
+			api.login(resp, req)
+			return
+		}
+		resp.Write([]byte(userNameFromRequest(req)))
+	}))
+	send := func(req *http.Request) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := send(httptest.NewRequest("GET", "/api/sources", nil)); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected an anonymous request to be rejected, got %d", resp.Code)
+	}
+	if resp := send(httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"name":"admin","password":"wrong"}`))); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong password to be rejected, got %d", resp.Code)
+	}
+
+	resp := send(httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"name":"admin","password":"`+password+`"}`)))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected the login to succeed, got %d: %s", resp.Code, resp.Body.String())
+	}
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range resp.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	session, csrf := cookies[sessionCookie], cookies[csrfCookie]
+	if session == nil || !session.HttpOnly || csrf == nil || csrf.HttpOnly {
+		t.Fatalf("expected an HTTP only session cookie and a readable CSRF cookie, got %v", resp.Result().Cookies())
+	}
+
+	withSession := func(method, header string) *http.Request {
+		req := httptest.NewRequest(method, "/api/sources/Pump/effectors", nil)
+		req.AddCookie(session)
+		req.AddCookie(csrf)
+		if header != "" {
+			req.Header.Set(csrfHeader, header)
+		}
+		return req
+	}
+	if resp = send(withSession("GET", "")); resp.Code != http.StatusOK || resp.Body.String() != "admin" {
+		t.Fatalf("expected the session to identify the admin, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp = send(withSession("POST", "")); resp.Code != http.StatusForbidden {
+		t.Fatalf("expected a command without the CSRF header to be rejected, got %d", resp.Code)
+	}
+	if resp = send(withSession("POST", "forged")); resp.Code != http.StatusForbidden {
+		t.Fatalf("expected a command with the wrong CSRF header to be rejected, got %d", resp.Code)
+	}
+	if resp = send(withSession("POST", csrf.Value)); resp.Code != http.StatusOK {
+		t.Fatalf("expected a command with the CSRF header to pass, got %d", resp.Code)
+	}
+
+	// Tokens are not sent automatically by the browser, so they need no CSRF header
+	secret, _, err := users.CreateToken("admin", "robot")
+	if err != nil {
+		t.Fatalf("unable to create token: %v", err)
+	}
+	req := httptest.NewRequest("POST", "/api/sources/Pump/effectors", nil)
+	req.Header.Set("Authorization", "Bearer "+secret)
+	if resp = send(req); resp.Code != http.StatusOK {
+		t.Fatalf("expected the bearer token to be accepted, got %d", resp.Code)
+	}
+	if resp = send(httptest.NewRequest("GET", "/api/speech?token="+secret, nil)); resp.Code != http.StatusOK {
+		t.Fatalf("expected the token parameter to be accepted, got %d", resp.Code)
+	}
+	if resp = send(httptest.NewRequest("GET", "/api/speech?token=guess", nil)); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected an unknown token to be rejected, got %d", resp.Code)
+	}
+
+	users.EndSession(session.Value)
+	if resp = send(withSession("GET", "")); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the ended session to be rejected, got %d", resp.Code)
+	}
+}
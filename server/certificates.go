@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	selfSignedCertificateFile = "server.crt"
+	selfSignedKeyFile         = "server.key"
+	selfSignedValidity        = 2 * 365 * 24 * time.Hour
+	certificateCheckPeriod    = time.Minute
+	defaultTLSPort            = "443"
+)
+
+type certificateManager struct {
+	certFile    string
+	keyFile     string
+	certificate *tls.Certificate
+	modified    time.Time
+	mutex       sync.Mutex
+	stopRequest chan int
+}
+
+func newCertificateManager(config *tlsConfiguration, dataPath, name string) (*certificateManager, error) {
+	mgr := &certificateManager{
+		certFile: config.CertificateFile,
+		keyFile:  config.KeyFile,
+	}
+
+	if mgr.certFile == "" || mgr.keyFile == "" {
+		mgr.certFile = filepath.Join(dataPath, selfSignedCertificateFile)
+		mgr.keyFile = filepath.Join(dataPath, selfSignedKeyFile)
+		if _, err := os.Stat(mgr.certFile); os.IsNotExist(err) {
+			log.Printf("[Certificates] Generating self-signed certificate in %s", mgr.certFile)
+			if err = generateSelfSignedCertificate(mgr.certFile, mgr.keyFile, name, config.Hosts); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := mgr.reload(); err != nil {
+		return nil, err
+	}
+	return mgr, nil
+}
+
+func (mgr *certificateManager) TLSConfig(clientCA string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: mgr.GetCertificate,
+	}
+
+	// Stations may identify themselves with a certificate, browsers are still allowed in without one
+	if clientCA != "" {
+		data, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read client certificate authority: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificates found in %s", clientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+func (mgr *certificateManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	return mgr.certificate, nil
+}
+
+func (mgr *certificateManager) Start() {
+	mgr.stopRequest = make(chan int)
+	go mgr.watch()
+}
+
+func (mgr *certificateManager) Stop() {
+	if mgr.stopRequest != nil {
+		close(mgr.stopRequest)
+		mgr.stopRequest = nil
+	}
+}
+
+func (mgr *certificateManager) watch() {
+	ticker := time.NewTicker(certificateCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mgr.stopRequest:
+			return
+
+		case <-ticker.C:
+			if err := mgr.reload(); err != nil {
+				log.Printf("[Certificates] Unable to reload certificate: %v", err)
+			}
+		}
+	}
+}
+
+func (mgr *certificateManager) reload() error {
+	info, err := os.Stat(mgr.certFile)
+	if err != nil {
+		return fmt.Errorf("Unable to find certificate: %v", err)
+	}
+	if keyInfo, err := os.Stat(mgr.keyFile); err == nil && keyInfo.ModTime().After(info.ModTime()) {
+		info = keyInfo
+	}
+
+	mgr.mutex.Lock()
+	unchanged := mgr.certificate != nil && !info.ModTime().After(mgr.modified)
+	mgr.mutex.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(mgr.certFile, mgr.keyFile)
+	if err != nil {
+		return fmt.Errorf("Unable to load certificate: %v", err)
+	}
+
+	log.Printf("[Certificates] Loaded certificate from %s", mgr.certFile)
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	mgr.certificate = &certificate
+	mgr.modified = info.ModTime()
+	return nil
+}
+
+func generateSelfSignedCertificate(certFile, keyFile, name string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("Unable to generate key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("Unable to generate serial number: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"Jarvis"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range append(certificateHosts(), hosts...) {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("Unable to create certificate: %v", err)
+	}
+	keyData, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("Unable to encode key: %v", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return fmt.Errorf("Unable to create data path: %v", err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyData}), 0600); err != nil {
+		return fmt.Errorf("Unable to write key: %v", err)
+	}
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("Unable to write certificate: %v", err)
+	}
+	return nil
+}
+
+func certificateHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1"}
+	if hostName, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostName)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				hosts = append(hosts, ipNet.IP.String())
+			}
+		}
+	}
+	return hosts
+}
+
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.Host)
+		if err != nil {
+			host = req.Host
+		}
+		if port != defaultTLSPort {
+			host = net.JoinHostPort(host, port)
+		}
+		// A 301 lets clients turn a POST into a GET, so anything else keeps its method and body
+		code := http.StatusMovedPermanently
+		if req.Method != "GET" && req.Method != "HEAD" {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(resp, req, "https://"+host+req.URL.RequestURI(), code)
+	})
+}
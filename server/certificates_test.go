@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	dataPath := t.TempDir()
+	mgr, err := newCertificateManager(&tlsConfiguration{Hosts: []string{"jarvis.local", "10.0.0.5"}}, dataPath, "Hub")
+	if err != nil {
+		t.Fatalf("unable to create certificate manager: %v", err)
+	}
+
+	certificate, _ := mgr.GetCertificate(nil)
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatalf("unable to parse certificate: %v", err)
+	}
+	if parsed.Subject.CommonName != "Hub" || parsed.VerifyHostname("jarvis.local") != nil || parsed.VerifyHostname("10.0.0.5") != nil {
+		t.Fatalf("expected a certificate for Hub covering the configured hosts, got %v %v", parsed.DNSNames, parsed.IPAddresses)
+	}
+	if info, err := os.Stat(filepath.Join(dataPath, selfSignedKeyFile)); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected a private key file, got %v", err)
+	}
+
+	// A second start keeps the existing certificate rather than generating another
+	again, err := newCertificateManager(&tlsConfiguration{}, dataPath, "Hub")
+	if err != nil {
+		t.Fatalf("unable to reuse certificate: %v", err)
+	}
+	if reused, _ := again.GetCertificate(nil); string(reused.Certificate[0]) != string(certificate.Certificate[0]) {
+		t.Fatal("expected the existing certificate to be reused")
+	}
+
+	// A replaced certificate is picked up by the next reload
+	certFile, keyFile := filepath.Join(dataPath, selfSignedCertificateFile), filepath.Join(dataPath, selfSignedKeyFile)
+	if err = generateSelfSignedCertificate(certFile, keyFile, "Renewed", nil); err != nil {
+		t.Fatalf("unable to replace certificate: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if err = mgr.reload(); err != nil {
+		t.Fatalf("unable to reload: %v", err)
+	}
+	renewed, _ := mgr.GetCertificate(nil)
+	if parsed, _ = x509.ParseCertificate(renewed.Certificate[0]); parsed.Subject.CommonName != "Renewed" {
+		t.Fatalf("expected the renewed certificate, got %s", parsed.Subject.CommonName)
+	}
+}
+
+func TestCertificateManagerErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := newCertificateManager(&tlsConfiguration{CertificateFile: filepath.Join(dir, "missing.crt"), KeyFile: filepath.Join(dir, "missing.key")}, dir, "Hub"); err == nil {
+		t.Error("expected an error for a missing certificate")
+	}
+
+	mgr := &certificateManager{}
+	if _, err := mgr.TLSConfig(filepath.Join(dir, "missing-ca.pem")); err == nil {
+		t.Error("expected an error for a missing client certificate authority")
+	}
+	empty := filepath.Join(dir, "empty-ca.pem")
+	ioutil.WriteFile(empty, []byte("not a certificate"), 0600)
+	if _, err := mgr.TLSConfig(empty); err == nil {
+		t.Error("expected an error for a client certificate authority without certificates")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	for _, redirect := range []struct {
+		method, host, port, path string
+		code                     int
+		location                 string
+	}{
+		{"GET", "jarvis.local:8080", "443", "/index.html", http.StatusMovedPermanently, "https://jarvis.local/index.html"},
+		{"GET", "jarvis.local", "8443", "/api/sources?type=sensor", http.StatusMovedPermanently, "https://jarvis.local:8443/api/sources?type=sensor"},
+		{"POST", "jarvis.local:8080", "443", "/api/sources/Pump/effectors", http.StatusPermanentRedirect, "https://jarvis.local/api/sources/Pump/effectors"},
+		{"DELETE", "10.0.0.5", "8443", "/api/users/ann", http.StatusPermanentRedirect, "https://10.0.0.5:8443/api/users/ann"},
+	} {
+		req := httptest.NewRequest(redirect.method, "http://"+redirect.host+redirect.path, nil)
+		resp := httptest.NewRecorder()
+		redirectToHTTPS(redirect.port).ServeHTTP(resp, req)
+		if resp.Code != redirect.code || resp.Header().Get("Location") != redirect.location {
+			t.Errorf("%s %s: expected %d to %s, got %d to %s", redirect.method, redirect.path, redirect.code, redirect.location, resp.Code, resp.Header().Get("Location"))
+		}
+	}
+}
@@ -2,49 +2,270 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"sync"
 )
 
+const discoveredStationsFile = "stations.json"
+
 type monitorConfiguration struct {
-	Name       string `json:"name"`
-	Port       string `json:"port"`
-	IsDisabled bool   `json:"disabled"`
+	Name       string                `json:"name"`
+	Port       string                `json:"port"`
+	Sensors    []sensorConfiguration `json:"sensors"`
+	IsDisabled bool                  `json:"disabled"`
+}
+
+type sensorConfiguration struct {
+	Name        string `json:"name"`
+	Title       string `json:"title"`
+	DeviceClass string `json:"deviceClass"`
+	Unit        string `json:"unit"`
 }
 
 type roomConfiguration struct {
-	Name       string   `json:"name"`
-	Sources    []string `json:"sources"`
-	Stations   []string `json:"stations"`
-	IsDisabled bool     `json:"disabled"`
+	Name            string   `json:"name"`
+	Sources         []string `json:"sources"`
+	Stations        []string `json:"stations"`
+	WeatherPhrasing string   `json:"weatherPhrasing"`
+	IsDisabled      bool     `json:"disabled"`
+}
+
+type roleConfiguration struct {
+	Name        string                    `json:"name"`
+	Permissions []permissionConfiguration `json:"permissions"`
+}
+
+type permissionConfiguration struct {
+	Level   string   `json:"level"`
+	Rooms   []string `json:"rooms"`
+	Sources []string `json:"sources"`
 }
 
 type stationConfiguration struct {
+	Name            string   `json:"name"`
+	Address         string   `json:"address"`
+	Secret          string   `json:"secret"`
+	Roles           []string `json:"roles"`
+	IsSecure        bool     `json:"secure"`
+	CAFile          string   `json:"ca"`
+	CertificateFile string   `json:"certificate"`
+	KeyFile         string   `json:"key"`
+	IsDisabled      bool     `json:"disabled"`
+}
+
+type peerSecurityConfiguration struct {
+	AllowUnsignedCommands bool  `json:"allowUnsignedCommands"`
+	ClockSkew             int64 `json:"clockSkew"`
+}
+
+type stationClientConfiguration struct {
+	Timeout     int64 `json:"timeout"`
+	CacheTime   int64 `json:"cache"`
+	StaleTime   int64 `json:"stale"`
+	MaxRequests int   `json:"maxRequests"`
+}
+
+type replicationConfiguration struct {
+	Period int64 `json:"period"`
+	Count  int   `json:"count"`
+}
+
+type discoveryConfiguration struct {
 	Name       string `json:"name"`
-	Address    string `json:"address"`
+	Port       int    `json:"port"`
+	Period     int64  `json:"period"`
+	IsDisabled bool   `json:"disabled"`
+}
+
+type authenticationConfiguration struct {
+	SessionTimeout int64 `json:"sessionTimeout"`
+	IsDisabled     bool  `json:"disabled"`
+}
+
+type corsConfiguration struct {
+	AllowedOrigins   []string `json:"origins"`
+	AllowedMethods   []string `json:"methods"`
+	AllowedHeaders   []string `json:"headers"`
+	AllowCredentials bool     `json:"credentials"`
+	MaxAge           int      `json:"maxAge"`
+}
+
+type tlsConfiguration struct {
+	Port            string   `json:"port"`
+	CertificateFile string   `json:"certificate"`
+	KeyFile         string   `json:"key"`
+	ClientCA        string   `json:"clientCA"`
+	Hosts           []string `json:"hosts"`
+	NoRedirect      bool     `json:"noRedirect"`
+	IsDisabled      bool     `json:"disabled"`
+}
+
+type rateLimitConfiguration struct {
+	Name    string   `json:"name"`
+	Paths   []string `json:"paths"`
+	Methods []string `json:"methods"`
+	Rate    float64  `json:"perMinute"`
+	Burst   int      `json:"burst"`
+}
+
+type metricsConfiguration struct {
+	Token      string `json:"token"`
+	IsDisabled bool   `json:"disabled"`
+}
+
+type influxConfiguration struct {
+	URL          string `json:"url"`
+	Database     string `json:"database"`
+	Organisation string `json:"org"`
+	Bucket       string `json:"bucket"`
+	Token        string `json:"token"`
+	UserName     string `json:"user"`
+	Password     string `json:"password"`
+	Measurement  string `json:"measurement"`
+	BatchSize    int    `json:"batchSize"`
+	FlushPeriod  int64  `json:"flush"`
+	Timeout      int64  `json:"timeout"`
+	MaxBuffer    int64  `json:"maxBuffer"`
+	IsDisabled   bool   `json:"disabled"`
+}
+
+type mqttConfiguration struct {
+	Broker     string `json:"broker"`
+	ClientID   string `json:"clientId"`
+	UserName   string `json:"user"`
+	Password   string `json:"password"`
+	Prefix     string `json:"prefix"`
+	QoS        byte   `json:"qos"`
+	Retain     bool   `json:"retain"`
+	Discovery  string `json:"discovery"`
 	IsDisabled bool   `json:"disabled"`
 }
 
 type weatherConfiguration struct {
-	LocationCode     string `json:"location"`
-	BaseURL          string `json:"url"`
-	APIKey           string `json:"key"`
-	RefreshPeriod    int64  `json:"refresh"`
-	SunriseSunsetURL string `json:"sun"`
+	Provider         string                      `json:"provider"`
+	LocationCode     string                      `json:"location"`
+	Latitude         float64                     `json:"lat"`
+	Longitude        float64                     `json:"lon"`
+	BaseURL          string                      `json:"url"`
+	APIKey           string                      `json:"key"`
+	Path             string                      `json:"path"`
+	RefreshPeriod    int64                       `json:"refresh"`
+	Timeout          int64                       `json:"timeout"`
+	SunriseSunsetURL string                      `json:"sun"`
+	Source           string                      `json:"source"`
+	History          int                         `json:"history"`
+	OutdoorSensors   *outdoorSensorConfiguration `json:"sensors"`
+	Alerts           []weatherAlertConfiguration `json:"alerts"`
+}
+
+type weatherAlertConfiguration struct {
+	weatherConditionConfiguration
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+	Message  string `json:"msg"`
+}
+
+type outdoorSensorConfiguration struct {
+	Station     string `json:"station"`
+	Source      string `json:"source"`
+	Temperature string `json:"temperature"`
+	Humidity    string `json:"humidity"`
+	Pressure    string `json:"pressure"`
+}
+
+type speechConfiguration struct {
+	Engine    string            `json:"engine"`
+	Language  string            `json:"language"`
+	Voices    map[string]string `json:"voices"`
+	Rate      float64           `json:"rate"`
+	Pitch     float64           `json:"pitch"`
+	Command   string            `json:"command"`
+	Arguments []string          `json:"args"`
+	Timeout   int64             `json:"timeout"`
+}
+
+type weatherPhrasingConfiguration struct {
+	Name     string              `json:"name"`
+	Language string              `json:"language"`
+	Units    string              `json:"units"`
+	Include  []string            `json:"include"`
+	Phrases  map[string][]string `json:"phrases"`
+	Vary     bool                `json:"vary"`
+}
+
+type scheduleConfiguration struct {
+	Name       string                     `json:"name"`
+	Source     string                     `json:"source"`
+	Effector   string                     `json:"effector"`
+	Action     string                     `json:"action"`
+	Duration   *int                       `json:"duration"`
+	Time       string                     `json:"time"`
+	Days       []string                   `json:"days"`
+	Weather    []weatherRuleConfiguration `json:"weather"`
+	IsDisabled bool                       `json:"disabled"`
+}
+
+type weatherConditionConfiguration struct {
+	Type   string   `json:"type"`
+	Hours  int      `json:"hours"`
+	Above  *float64 `json:"above"`
+	Below  *float64 `json:"below"`
+	Amount float64  `json:"amount"`
+	Chance float64  `json:"chance"`
+}
+
+type weatherRuleConfiguration struct {
+	weatherConditionConfiguration
+	Action   string `json:"action"`
+	Postpone int    `json:"postpone"`
 }
 
 type appConfiguration struct {
-	Rooms      []roomConfiguration    `json:"rooms"`
-	Sources    []monitorConfiguration `json:"sources"`
-	Stations   []stationConfiguration `json:"stations"`
-	DataPath   string                 `json:"dataPath"`
-	StaticPath string                 `json:"staticPath"`
-	Weather    *weatherConfiguration  `json:"weather"`
+	Name               string                         `json:"name"`
+	Rooms              []roomConfiguration            `json:"rooms"`
+	Sources            []monitorConfiguration         `json:"sources"`
+	Stations           []stationConfiguration         `json:"stations"`
+	StationCheckPeriod int64                          `json:"stationCheck"`
+	StationClient      *stationClientConfiguration    `json:"stationClient"`
+	Replication        *replicationConfiguration      `json:"replication"`
+	Discovery          *discoveryConfiguration        `json:"discovery"`
+	PeerSecurity       *peerSecurityConfiguration     `json:"peerSecurity"`
+	Authentication     *authenticationConfiguration   `json:"authentication"`
+	Roles              []roleConfiguration            `json:"roles"`
+	CORS               *corsConfiguration             `json:"cors"`
+	ContentPolicy      string                         `json:"contentSecurityPolicy"`
+	TLS                *tlsConfiguration              `json:"tls"`
+	RateLimits         []rateLimitConfiguration       `json:"rateLimits"`
+	Metrics            *metricsConfiguration          `json:"metrics"`
+	Influx             *influxConfiguration           `json:"influx"`
+	MQTT               *mqttConfiguration             `json:"mqtt"`
+	DataPath           string                         `json:"dataPath"`
+	StaticPath         string                         `json:"staticPath"`
+	Weather            *weatherConfiguration          `json:"weather"`
+	WeatherPhrasing    []weatherPhrasingConfiguration `json:"weatherPhrasing"`
+	Speech             *speechConfiguration           `json:"speech"`
+	Schedules          []scheduleConfiguration        `json:"schedules"`
+
+	stations   map[string]stationConfiguration
+	discovered map[string]bool
+	mutex      sync.Mutex
+}
 
-	stations map[string]stationConfiguration
+func (config *appConfiguration) StationName() string {
+	if config.Name != "" {
+		return config.Name
+	}
+	name, _ := os.Hostname()
+	return name
 }
 
 func (config *appConfiguration) FindStation(name string) *stationConfiguration {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
 	station, ok := config.stations[name]
 	if !ok {
 		return nil
@@ -52,6 +273,125 @@ func (config *appConfiguration) FindStation(name string) *stationConfiguration {
 	return &station
 }
 
+func (config *appConfiguration) StationAddress(station *stationConfiguration) string {
+	if current := config.FindStation(station.Name); current != nil {
+		return current.Address
+	}
+	return station.Address
+}
+
+func (config *appConfiguration) StationList() []stationConfiguration {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	return append([]stationConfiguration{}, config.Stations...)
+}
+
+func (config *appConfiguration) UpdateStationAddress(name, address string) bool {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	station, ok := config.stations[name]
+	if !ok || station.Address == address {
+		return false
+	}
+	station.Address = address
+	config.stations[name] = station
+	for pos := range config.Stations {
+		if config.Stations[pos].Name == name {
+			config.Stations[pos].Address = address
+		}
+	}
+	config.saveDiscoveredStation(name)
+	return true
+}
+
+func (config *appConfiguration) AddStation(station stationConfiguration) bool {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	if _, ok := config.stations[station.Name]; ok {
+		return false
+	}
+	config.stations[station.Name] = station
+	config.Stations = append(config.Stations, station)
+	config.saveDiscoveredStation(station.Name)
+	return true
+}
+
+// Stations found by discovery are kept apart from config.json, which is only ever edited by hand
+func (config *appConfiguration) loadDiscoveredStations() error {
+	data, err := ioutil.ReadFile(filepath.Join(config.DataPath, discoveredStationsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var stations []stationConfiguration
+	if err = json.Unmarshal(data, &stations); err != nil {
+		return err
+	}
+
+	for _, station := range stations {
+		config.discovered[station.Name] = true
+		current, ok := config.stations[station.Name]
+		if !ok {
+			config.stations[station.Name] = station
+			config.Stations = append(config.Stations, station)
+			continue
+		}
+		// Only the address is taken for a configured station, its secret and roles stay as configured
+		current.Address = station.Address
+		config.stations[station.Name] = current
+		for pos := range config.Stations {
+			if config.Stations[pos].Name == station.Name {
+				config.Stations[pos].Address = station.Address
+			}
+		}
+	}
+	return nil
+}
+
+func (config *appConfiguration) saveDiscoveredStation(name string) {
+	if config.discovered == nil {
+		config.discovered = map[string]bool{}
+	}
+	config.discovered[name] = true
+	stations := []stationConfiguration{}
+	for _, station := range config.Stations {
+		if config.discovered[station.Name] {
+			stations = append(stations, config.stations[station.Name])
+		}
+	}
+
+	// Adopted stations can carry a secret, so the file is only readable by the server
+	data, err := json.MarshalIndent(stations, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(config.DataPath, 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(config.DataPath, discoveredStationsFile), data, 0600)
+	}
+	if err != nil {
+		log.Printf("[Discovery] Unable to save discovered stations: %v", err)
+	}
+}
+
+// Metrics describe every source and station, so once users have to log in they are only served with a token
+func (config *appConfiguration) metricsEnabled() bool {
+	if config.Metrics != nil && config.Metrics.IsDisabled {
+		return false
+	}
+	authenticated := config.Authentication != nil && !config.Authentication.IsDisabled
+	return !authenticated || (config.Metrics != nil && config.Metrics.Token != "")
+}
+
+func (config *appConfiguration) validate() error {
+	// Browsers refuse a wildcard with credentials, reflecting the origin instead would let any site act as the user
+	if cors := config.CORS; cors != nil && cors.AllowCredentials && containsString(cors.AllowedOrigins, "*") {
+		return errors.New("CORS cannot allow credentials from any origin")
+	}
+	return nil
+}
+
 func readConfiguration(filePath string) (*appConfiguration, error) {
 	file, err := ioutil.ReadFile(filePath)
 	if err != nil {
@@ -66,6 +406,11 @@ func readConfiguration(filePath string) (*appConfiguration, error) {
 		return nil, err
 	}
 
+	if err = settings.validate(); err != nil {
+		log.Println("Invalid configuration:", err)
+		return nil, err
+	}
+
 	if settings.DataPath == "" {
 		settings.DataPath = "data"
 	}
@@ -78,6 +423,10 @@ func readConfiguration(filePath string) (*appConfiguration, error) {
 	for _, station := range settings.Stations {
 		settings.stations[station.Name] = station
 	}
+	settings.discovered = map[string]bool{}
+	if err = settings.loadDiscoveredStations(); err != nil {
+		log.Println("Unable to read discovered stations:", err)
+	}
 
 	return &settings, nil
 }
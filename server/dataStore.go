@@ -48,17 +48,26 @@ func (store *sourceDataStore) GetLast(number int) *[]monitorResult {
 	return &out
 }
 
+func storeKey(station, source string) string {
+	if station == "" {
+		return source
+	}
+	return station + "/" + source
+}
+
 type dataStore struct {
 	sources    map[string]*sourceDataStore
 	input      chan *monitorResult
 	stopSignal chan int
 	stopResult chan int
+	snapshots  chan chan map[string][]monitorResult
 	running    bool
 }
 
 func (store *dataStore) Initialise() monitorListener {
 	store.sources = make(map[string]*sourceDataStore)
 	store.input = make(chan *monitorResult)
+	store.snapshots = make(chan chan map[string][]monitorResult)
 	return store.input
 }
 
@@ -69,6 +78,8 @@ func (store *dataStore) Start() error {
 
 	store.stopSignal = make(chan int)
 	store.stopResult = make(chan int)
+	// Marked before the goroutine starts so a snapshot straight after Start goes through it
+	store.running = true
 	go store.run()
 	return nil
 }
@@ -103,17 +114,37 @@ func (store *dataStore) GetLast(name string, number int) *[]monitorResult {
 	return source.GetLast(number)
 }
 
+// Snapshot copies every source from the store goroutine, so callers outside it do not race with new results
+func (store *dataStore) Snapshot() map[string][]monitorResult {
+	if !store.running {
+		return store.copySources()
+	}
+	reply := make(chan map[string][]monitorResult)
+	store.snapshots <- reply
+	return <-reply
+}
+
+func (store *dataStore) copySources() map[string][]monitorResult {
+	out := make(map[string][]monitorResult, len(store.sources))
+	for name, source := range store.sources {
+		out[name] = *source.Get()
+	}
+	return out
+}
+
 func (store *dataStore) run() {
-	store.running = true
 	running := true
 	for running {
 		select {
 		case _ = <-store.stopSignal:
 			running = false
 
+		case reply := <-store.snapshots:
+			reply <- store.copySources()
+
 		case result, ok := <-store.input:
 			if ok {
-				name := result.Source
+				name := storeKey(result.Station, result.Source)
 				source, ok := store.sources[name]
 				if !ok {
 					source = newSourceDataStore(name)
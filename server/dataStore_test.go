@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestDataStoreSnapshotWhileRunning(t *testing.T) {
+	store := &dataStore{}
+	input := store.Initialise()
+	if err := store.Start(); err != nil {
+		t.Fatalf("unable to start store: %v", err)
+	}
+
+	// Readers take snapshots while results arrive, run with -race to check they do not share the map
+	var readers sync.WaitGroup
+	for reader := 0; reader < 4; reader++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for loop := 0; loop < 50; loop++ {
+				store.Snapshot()
+			}
+		}()
+	}
+	for counter := int64(1); counter <= 100; counter++ {
+		input <- &monitorResult{Station: "Balcony", Source: "Plant", Counter: counter}
+		input <- &monitorResult{Source: "Pump", Counter: counter}
+	}
+	readers.Wait()
+
+	snapshot := store.Snapshot()
+	if len(snapshot) != 2 || len(snapshot["Balcony/Plant"]) == 0 || len(snapshot["Pump"]) == 0 {
+		t.Fatalf("expected a local and a remote source, got %d sources", len(snapshot))
+	}
+	snapshot["Pump"][0].Counter = -1
+	if (*store.GetItems("Pump"))[0].Counter == -1 {
+		t.Fatal("expected the snapshot to be a copy")
+	}
+	close(input)
+}
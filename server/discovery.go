@@ -0,0 +1,346 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	discoveryServiceName   = "jarvis"
+	defaultDiscoveryPort   = 48520
+	defaultDiscoveryPeriod = 30
+	discoveryExpiryPeriods = 4
+	maxDiscoveredPeers     = 64
+	maxAnnouncementSize    = 4096
+)
+
+type discoveryAnnouncement struct {
+	Service    string            `json:"service"`
+	Name       string            `json:"name"`
+	Port       string            `json:"port"`
+	Version    string            `json:"version"`
+	APIVersion int               `json:"apiVersion"`
+	Addresses  []string          `json:"addresses,omitempty"`
+	Time       int64             `json:"time,omitempty"`
+	Signatures map[string]string `json:"signatures,omitempty"`
+}
+
+// Services that keep their own list of stations are told about adopted ones
+type stationTracker interface {
+	AddStation(station stationConfiguration)
+}
+
+type discoveredPeer struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	Version    string `json:"version"`
+	APIVersion int    `json:"apiVersion"`
+	LastSeen   string `json:"lastSeen"`
+	IsKnown    bool   `json:"known"`
+	Configured string `json:"configuredAddress,omitempty"`
+
+	seen time.Time
+}
+
+type discoveryService struct {
+	config      *appConfiguration
+	trackers    []stationTracker
+	name        string
+	httpPort    string
+	port        int
+	period      time.Duration
+	conn        net.PacketConn
+	peers       map[string]*discoveredPeer
+	mutex       sync.Mutex
+	isRunning   bool
+	stopRequest chan int
+	finished    sync.WaitGroup
+}
+
+func (service *discoveryService) Start(config *appConfiguration, httpPort string, trackers ...stationTracker) error {
+	if service.isRunning {
+		return nil
+	}
+
+	log.Printf("[Discovery] Starting service")
+	settings := config.Discovery
+	service.config = config
+	service.trackers = trackers
+	service.httpPort = httpPort
+	service.name = settings.Name
+	if service.name == "" {
+		service.name = config.StationName()
+	}
+	service.port = settings.Port
+	if service.port <= 0 {
+		service.port = defaultDiscoveryPort
+	}
+	period := settings.Period
+	if period <= 0 {
+		period = defaultDiscoveryPeriod
+	}
+	service.period = time.Duration(period) * time.Second
+
+	conn, err := net.ListenPacket("udp4", ":"+strconv.Itoa(service.port))
+	if err != nil {
+		return err
+	}
+	service.conn = conn
+	service.peers = map[string]*discoveredPeer{}
+	service.stopRequest = make(chan int)
+	service.finished.Add(2)
+	go service.listen()
+	go service.announce()
+	service.isRunning = true
+	return nil
+}
+
+func (service *discoveryService) Stop(timeOut time.Duration) error {
+	if !service.isRunning {
+		return nil
+	}
+
+	log.Printf("[Discovery] Stopping service")
+	service.isRunning = false
+	close(service.stopRequest)
+	service.conn.Close()
+
+	done := make(chan int)
+	go func() {
+		service.finished.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeOut):
+		return errors.New("Stop discovery service timed out")
+	}
+}
+
+func (service *discoveryService) Pending() []discoveredPeer {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	out := []discoveredPeer{}
+	for _, peer := range service.peers {
+		if time.Since(peer.seen) > discoveryExpiryPeriods*service.period {
+			continue
+		}
+		item := *peer
+		item.IsKnown, item.Configured = false, ""
+		if station := service.config.FindStation(peer.Name); station != nil {
+			if station.Address == peer.Address {
+				continue
+			}
+			item.IsKnown, item.Configured = true, station.Address
+		}
+		out = append(out, item)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+func (service *discoveryService) Peer(name string) *discoveredPeer {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	peer, ok := service.peers[name]
+	if !ok || time.Since(peer.seen) > discoveryExpiryPeriods*service.period {
+		return nil
+	}
+	item := *peer
+	return &item
+}
+
+func (service *discoveryService) Adopt(peer *discoveredPeer, secret string) (*stationConfiguration, bool) {
+	if station := service.config.FindStation(peer.Name); station != nil {
+		service.config.UpdateStationAddress(peer.Name, peer.Address)
+		station.Address = peer.Address
+		return station, false
+	}
+
+	station := stationConfiguration{Name: peer.Name, Address: peer.Address, Secret: secret}
+	service.config.AddStation(station)
+	for _, tracker := range service.trackers {
+		tracker.AddStation(station)
+	}
+	return &station, true
+}
+
+func (service *discoveryService) announcement() ([]byte, error) {
+	message := discoveryAnnouncement{
+		Service:    discoveryServiceName,
+		Name:       service.name,
+		Port:       service.httpPort,
+		Version:    serverVersion,
+		APIVersion: apiVersion,
+		Addresses:  localAddresses(),
+		Time:       time.Now().Unix(),
+		Signatures: map[string]string{},
+	}
+	for _, station := range service.config.StationList() {
+		if station.Secret != "" {
+			message.Signatures[station.Name] = announcementSignature(station.Secret, &message)
+		}
+	}
+	return json.Marshal(message)
+}
+
+func announcementSignature(secret string, message *discoveryAnnouncement) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message.Name + "\n" + message.Port + "\n" + strconv.FormatInt(message.Time, 10) + "\n" + strings.Join(message.Addresses, ",")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// A signed announcement only moves a station to an address the station itself listed, so replaying it from elsewhere does nothing
+func (service *discoveryService) verifyAnnouncement(message *discoveryAnnouncement, station *stationConfiguration, host string) error {
+	if station.Secret == "" {
+		return errors.New("No secret is configured for the station")
+	}
+	expected := announcementSignature(station.Secret, message)
+	if !hmac.Equal([]byte(expected), []byte(message.Signatures[service.name])) {
+		return errors.New("Announcement is not signed for this station")
+	}
+	window := stationClockWindow(service.config.PeerSecurity)
+	if age := time.Since(time.Unix(message.Time, 0)); age > window || age < -window {
+		return errors.New("Announcement is outside the allowed window")
+	}
+	for _, address := range message.Addresses {
+		if address == host {
+			return nil
+		}
+	}
+	return fmt.Errorf("Announcement does not list %s", host)
+}
+
+func localAddresses() []string {
+	addresses, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Printf("[Discovery] Unable to list local addresses: %v", err)
+		return nil
+	}
+	out := []string{}
+	for _, address := range addresses {
+		if ip, ok := address.(*net.IPNet); ok && !ip.IP.IsLoopback() && ip.IP.To4() != nil {
+			out = append(out, ip.IP.String())
+		}
+	}
+	return out
+}
+
+func (service *discoveryService) announce() {
+	defer service.finished.Done()
+	target := &net.UDPAddr{IP: net.IPv4bcast, Port: service.port}
+	ticker := time.NewTicker(service.period)
+	defer ticker.Stop()
+	for {
+		// Signatures carry the time, so every announcement is generated afresh
+		data, err := service.announcement()
+		if err != nil {
+			log.Printf("[Discovery] Unable to generate announcement: %v", err)
+		} else if _, err = service.conn.WriteTo(data, target); err != nil {
+			log.Printf("[Discovery] Unable to send announcement: %v", err)
+		}
+
+		select {
+		case <-service.stopRequest:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (service *discoveryService) listen() {
+	defer service.finished.Done()
+	buf := make([]byte, maxAnnouncementSize)
+	for {
+		n, from, err := service.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-service.stopRequest:
+				return
+			default:
+			}
+			log.Printf("[Discovery] Unable to read announcement: %v", err)
+			continue
+		}
+
+		var message discoveryAnnouncement
+		if err = json.Unmarshal(buf[:n], &message); err != nil || message.Service != discoveryServiceName {
+			continue
+		}
+		if message.Name == service.name {
+			continue
+		}
+
+		host, _, err := net.SplitHostPort(from.String())
+		if err != nil {
+			continue
+		}
+		address := net.JoinHostPort(host, message.Port)
+		moved := service.addPeer(message, address)
+		service.updateStation(&message, host, address, moved)
+	}
+}
+
+// Any device can announce itself, so peers are only listed until adopted and the list is capped
+func (service *discoveryService) addPeer(message discoveryAnnouncement, address string) bool {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	peer, ok := service.peers[message.Name]
+	if !ok {
+		if len(service.peers) >= maxDiscoveredPeers {
+			service.removeExpiredPeers()
+		}
+		if len(service.peers) >= maxDiscoveredPeers {
+			return false
+		}
+		log.Printf("[Discovery] Discovered %s at %s", message.Name, address)
+		peer = &discoveredPeer{Name: message.Name}
+		service.peers[message.Name] = peer
+	}
+	moved := peer.Address != address
+	peer.Address = address
+	peer.Version = message.Version
+	peer.APIVersion = message.APIVersion
+	peer.seen = time.Now()
+	peer.LastSeen = peer.seen.Format(time.RFC3339)
+	return moved
+}
+
+func (service *discoveryService) removeExpiredPeers() {
+	for name, peer := range service.peers {
+		if time.Since(peer.seen) > discoveryExpiryPeriods*service.period {
+			delete(service.peers, name)
+		}
+	}
+}
+
+// Known stations follow their signed announcements, anything else waits to be adopted
+func (service *discoveryService) updateStation(message *discoveryAnnouncement, host, address string, moved bool) {
+	station := service.config.FindStation(message.Name)
+	if station == nil || station.Address == address {
+		return
+	}
+	if err := service.verifyAnnouncement(message, station, host); err != nil {
+		if moved {
+			log.Printf("[Discovery] Station %s announced %s, waiting for adoption: %v", station.Name, address, err)
+		}
+		return
+	}
+	if service.config.UpdateStationAddress(station.Name, address) {
+		log.Printf("[Discovery] Station %s moved from %s to %s", station.Name, station.Address, address)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestDiscovery(t *testing.T, stations ...stationConfiguration) *discoveryService {
+	dir := t.TempDir()
+	data, _ := json.Marshal(map[string]interface{}{"name": "Hub", "dataPath": filepath.Join(dir, "data"), "stations": stations})
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("unable to write configuration: %v", err)
+	}
+	config, err := readConfiguration(path)
+	if err != nil {
+		t.Fatalf("unable to read configuration: %v", err)
+	}
+	return &discoveryService{config: config, name: "Hub", period: 30 * time.Second, peers: map[string]*discoveredPeer{}}
+}
+
+func TestSignedAnnouncementsMoveKnownStations(t *testing.T) {
+	service := newTestDiscovery(t, stationConfiguration{Name: "Plant", Address: "192.168.0.2:80", Secret: "plant-secret"})
+	announce := func(secret string, sent time.Time, host string) {
+		message := discoveryAnnouncement{Service: discoveryServiceName, Name: "Plant", Port: "80", Addresses: []string{"192.168.0.7"}, Time: sent.Unix()}
+		if secret != "" {
+			message.Signatures = map[string]string{"Hub": announcementSignature(secret, &message)}
+		}
+		address := host + ":80"
+		service.updateStation(&message, host, address, service.addPeer(message, address))
+	}
+
+	announce("", time.Now(), "192.168.0.7")
+	if address := service.config.FindStation("Plant").Address; address != "192.168.0.2:80" {
+		t.Fatalf("expected an unsigned announcement to leave the station alone, got %s", address)
+	}
+	if pending := service.Pending(); len(pending) != 1 || pending[0].Configured != "192.168.0.2:80" {
+		t.Fatalf("expected the new address to wait for adoption, got %+v", pending)
+	}
+
+	announce("guess", time.Now(), "192.168.0.7")
+	announce("plant-secret", time.Now().Add(-time.Hour), "192.168.0.7")
+	announce("plant-secret", time.Now(), "192.168.0.66")
+	if address := service.config.FindStation("Plant").Address; address != "192.168.0.2:80" {
+		t.Fatalf("expected a wrong secret, an old or a replayed announcement to be ignored, got %s", address)
+	}
+
+	announce("plant-secret", time.Now(), "192.168.0.7")
+	if address := service.config.FindStation("Plant").Address; address != "192.168.0.7:80" {
+		t.Fatalf("expected the signed announcement to move the station, got %s", address)
+	}
+	if stations := service.config.StationList(); stations[0].Address != "192.168.0.7:80" {
+		t.Fatalf("expected the station list to follow, got %+v", stations)
+	}
+	if pending := service.Pending(); len(pending) != 0 {
+		t.Fatalf("expected nothing left to adopt, got %+v", pending)
+	}
+}
+
+func TestAnnouncementIsSignedForEachStation(t *testing.T) {
+	plant := newTestDiscovery(t,
+		stationConfiguration{Name: "Hub", Address: "192.168.0.1:80", Secret: "plant-secret"},
+		stationConfiguration{Name: "Shed", Address: "192.168.0.3:80"})
+	plant.name, plant.httpPort = "Plant", "8080"
+
+	data, err := plant.announcement()
+	if err != nil {
+		t.Fatalf("unable to generate announcement: %v", err)
+	}
+	message := discoveryAnnouncement{}
+	if err = json.Unmarshal(data, &message); err != nil {
+		t.Fatalf("unable to parse announcement: %v", err)
+	}
+	if len(message.Signatures) != 1 || message.Signatures["Hub"] != announcementSignature("plant-secret", &message) {
+		t.Fatalf("expected one signature for the hub, got %v", message.Signatures)
+	}
+	if message.Name != "Plant" || message.Port != "8080" || message.Time == 0 {
+		t.Fatalf("expected the station name, port and time, got %+v", message)
+	}
+}
+
+func TestAdoptedStationsArePersisted(t *testing.T) {
+	service := newTestDiscovery(t, stationConfiguration{Name: "Plant", Address: "192.168.0.2:80", Secret: "plant-secret", Roles: []string{"operator"}})
+	health := &stationHealthChecker{health: map[string]*stationHealth{}}
+	service.trackers = []stationTracker{health}
+	service.addPeer(discoveryAnnouncement{Name: "Greenhouse", Port: "80"}, "192.168.0.9:80")
+	service.addPeer(discoveryAnnouncement{Name: "Plant", Port: "80"}, "192.168.0.7:80")
+
+	station, isNew := service.Adopt(service.Peer("Greenhouse"), "greenhouse-secret")
+	if !isNew || station.Address != "192.168.0.9:80" {
+		t.Fatalf("expected the greenhouse to be adopted, got %+v", station)
+	}
+	if status := health.Get("Greenhouse"); status == nil || status.Status != stationStatusUnknown {
+		t.Fatalf("expected health checks to include the adopted station, got %+v", status)
+	}
+	if _, isNew = service.Adopt(service.Peer("Plant"), ""); isNew {
+		t.Fatal("expected a known station to be moved rather than added")
+	}
+
+	// A restart sees both changes, while the configured secret and roles are kept
+	config, err := readConfiguration(filepath.Join(filepath.Dir(service.config.DataPath), "config.json"))
+	if err != nil {
+		t.Fatalf("unable to read configuration: %v", err)
+	}
+	stations := config.StationList()
+	if len(stations) != 2 {
+		t.Fatalf("expected two stations after a restart, got %+v", stations)
+	}
+	if plant := config.FindStation("Plant"); plant.Address != "192.168.0.7:80" || plant.Secret != "plant-secret" || len(plant.Roles) != 1 {
+		t.Fatalf("expected the plant monitor at its new address with its settings, got %+v", plant)
+	}
+	if greenhouse := config.FindStation("Greenhouse"); greenhouse == nil || greenhouse.Secret != "greenhouse-secret" {
+		t.Fatalf("expected the adopted greenhouse with its secret, got %+v", greenhouse)
+	}
+}
+
+func TestDiscoveredPeersAreCapped(t *testing.T) {
+	service := newTestDiscovery(t)
+	for pos := 0; pos <= maxDiscoveredPeers; pos++ {
+		service.addPeer(discoveryAnnouncement{Name: fmt.Sprintf("peer-%d", pos), Port: "80"}, "192.168.1.1:80")
+	}
+	if len(service.peers) != maxDiscoveredPeers || service.Peer(fmt.Sprintf("peer-%d", maxDiscoveredPeers)) != nil {
+		t.Fatalf("expected the list to stop at %d peers, got %d", maxDiscoveredPeers, len(service.peers))
+	}
+
+	// Peers that stopped announcing make room for new ones
+	service.peers["peer-0"].seen = time.Now().Add(-time.Hour)
+	service.addPeer(discoveryAnnouncement{Name: "late", Port: "80"}, "192.168.1.2:80")
+	if service.Peer("late") == nil || service.Peer("peer-0") != nil {
+		t.Fatal("expected an expired peer to be replaced")
+	}
+}
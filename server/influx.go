@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	influxBufferFile         = "influx-buffer.lp"
+	defaultInfluxMeasurement = "sensors"
+	defaultInfluxBatchSize   = 100
+	defaultInfluxFlushPeriod = 10
+	defaultInfluxMaxBuffer   = 50 * 1024 * 1024
+	defaultInfluxTimeout     = 10
+)
+
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+type influxExporter struct {
+	config      *influxConfiguration
+	station     string
+	bufferPath  string
+	client      *http.Client
+	input       chan *monitorResult
+	batch       []string
+	mutex       sync.Mutex
+	isRunning   bool
+	stopRequest chan int
+	stopReply   chan int
+}
+
+func (exporter *influxExporter) Initialise() monitorListener {
+	exporter.input = make(chan *monitorResult)
+	return exporter.input
+}
+
+func (exporter *influxExporter) Start(config *influxConfiguration, dataPath, station string) error {
+	if exporter.isRunning {
+		return nil
+	}
+	if config.URL == "" {
+		return errors.New("No InfluxDB address has been configured")
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultInfluxTimeout
+	}
+
+	log.Printf("[Influx] Starting exporter to %s", config.URL)
+	exporter.config = config
+	exporter.station = station
+	exporter.bufferPath = filepath.Join(dataPath, influxBufferFile)
+	exporter.client = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	exporter.stopRequest = make(chan int)
+	exporter.stopReply = make(chan int, 1)
+	go exporter.run()
+	exporter.isRunning = true
+	return nil
+}
+
+func (exporter *influxExporter) Stop(timeOut time.Duration) error {
+	if !exporter.isRunning {
+		return nil
+	}
+
+	log.Printf("[Influx] Stopping exporter")
+	exporter.isRunning = false
+	deadline := time.After(timeOut)
+	select {
+	case exporter.stopRequest <- 1:
+	case <-deadline:
+		return errors.New("Stop InfluxDB exporter timed out")
+	}
+	select {
+	case <-exporter.stopReply:
+		return nil
+	case <-deadline:
+		return errors.New("Stop InfluxDB exporter timed out")
+	}
+}
+
+func (exporter *influxExporter) run() {
+	period := exporter.config.FlushPeriod
+	if period <= 0 {
+		period = defaultInfluxFlushPeriod
+	}
+	batchSize := exporter.batchSize()
+
+	ticker := time.NewTicker(time.Duration(period) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-exporter.stopRequest:
+			exporter.flush()
+			exporter.stopReply <- 1
+			return
+
+		case result, ok := <-exporter.input:
+			if !ok {
+				exporter.flush()
+				return
+			}
+			exporter.batch = append(exporter.batch, exporter.formatResult(result))
+			if len(exporter.batch) >= batchSize {
+				exporter.flush()
+			}
+
+		case <-ticker.C:
+			exporter.flush()
+		}
+	}
+}
+
+func (exporter *influxExporter) batchSize() int {
+	if exporter.config.BatchSize > 0 {
+		return exporter.config.BatchSize
+	}
+	return defaultInfluxBatchSize
+}
+
+func (exporter *influxExporter) flush() {
+	lines := exporter.batch
+	exporter.batch = nil
+
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	if err := exporter.sendBuffered(); err != nil {
+		log.Printf("[Influx] Unable to send buffered results: %v", err)
+		exporter.buffer(lines)
+		return
+	}
+	if len(lines) == 0 {
+		return
+	}
+	if err := exporter.write(lines); err != nil {
+		log.Printf("[Influx] Unable to write %d results, buffering: %v", len(lines), err)
+		exporter.buffer(lines)
+	}
+}
+
+func (exporter *influxExporter) Backfill(data *dataStore) (int, error) {
+	lines := []string{}
+	for _, items := range data.Snapshot() {
+		for pos := range items {
+			lines = append(lines, exporter.formatResult(&items[pos]))
+		}
+	}
+
+	// The batches are written without the lock so regular flushes are not held up behind a long backfill
+	log.Printf("[Influx] Backfilling %d results", len(lines))
+	batchSize := exporter.batchSize()
+	for start := 0; start < len(lines); start += batchSize {
+		end := start + batchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+		if err := exporter.write(lines[start:end]); err != nil {
+			return start, err
+		}
+	}
+	return len(lines), nil
+}
+
+func (exporter *influxExporter) formatResult(result *monitorResult) string {
+	station := result.Station
+	if station == "" {
+		station = exporter.station
+	}
+	measurement := exporter.config.Measurement
+	if measurement == "" {
+		measurement = defaultInfluxMeasurement
+	}
+
+	fields := make([]string, 0, len(result.Values))
+	for _, value := range result.Values {
+		fields = append(fields, influxEscaper.Replace(value.Name)+"="+strconv.FormatFloat(float64(value.Value), 'f', -1, 32))
+	}
+	fields = append(fields, "count="+strconv.FormatInt(result.Counter, 10)+"i")
+
+	line := fmt.Sprintf("%s,station=%s,source=%s %s",
+		influxEscaper.Replace(measurement),
+		influxEscaper.Replace(station),
+		influxEscaper.Replace(result.Source),
+		strings.Join(fields, ","))
+	if timeStamp, err := time.Parse(time.RFC3339, result.TimeStamp); err == nil {
+		line += " " + strconv.FormatInt(timeStamp.Unix(), 10)
+	}
+	return line
+}
+
+func (exporter *influxExporter) writeURL() string {
+	config := exporter.config
+	args := url.Values{}
+	args.Set("precision", "s")
+	if config.Bucket != "" {
+		args.Set("org", config.Organisation)
+		args.Set("bucket", config.Bucket)
+		return strings.TrimSuffix(config.URL, "/") + "/api/v2/write?" + args.Encode()
+	}
+	args.Set("db", config.Database)
+	return strings.TrimSuffix(config.URL, "/") + "/write?" + args.Encode()
+}
+
+func (exporter *influxExporter) write(lines []string) error {
+	req, err := http.NewRequest("POST", exporter.writeURL(), strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		return fmt.Errorf("Unable to generate request: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if exporter.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+exporter.config.Token)
+	} else if exporter.config.UserName != "" {
+		req.SetBasicAuth(exporter.config.UserName, exporter.config.Password)
+	}
+
+	resp, err := exporter.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to InfluxDB: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("InfluxDB returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (exporter *influxExporter) buffer(lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+
+	maxBuffer := exporter.config.MaxBuffer
+	if maxBuffer <= 0 {
+		maxBuffer = defaultInfluxMaxBuffer
+	}
+	if info, err := os.Stat(exporter.bufferPath); err == nil && info.Size() >= maxBuffer {
+		log.Printf("[Influx] Buffer is full, dropping %d results", len(lines))
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(exporter.bufferPath), 0700); err != nil {
+		log.Printf("[Influx] Unable to create data path: %v", err)
+		return
+	}
+	file, err := os.OpenFile(exporter.bufferPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("[Influx] Unable to open buffer: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err = file.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		log.Printf("[Influx] Unable to write buffer: %v", err)
+	}
+}
+
+func (exporter *influxExporter) sendBuffered() error {
+	data, err := ioutil.ReadFile(exporter.bufferPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Unable to read buffer: %v", err)
+	}
+
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	batchSize := exporter.batchSize()
+	for start := 0; start < len(lines); start += batchSize {
+		end := start + batchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+		if err = exporter.write(lines[start:end]); err != nil {
+			// Keep whatever has not been sent for the next attempt
+			remaining := strings.Join(lines[start:], "\n") + "\n"
+			if writeErr := ioutil.WriteFile(exporter.bufferPath, []byte(remaining), 0600); writeErr != nil {
+				log.Printf("[Influx] Unable to rewrite buffer: %v", writeErr)
+			}
+			return err
+		}
+	}
+
+	if len(lines) > 0 {
+		log.Printf("[Influx] Sent %d buffered results", len(lines))
+	}
+	return os.Remove(exporter.bufferPath)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type influxRecorder struct {
+	mutex   sync.Mutex
+	failing bool
+	queries []string
+	lines   []string
+}
+
+func (recorder *influxRecorder) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if recorder.failing {
+		http.Error(resp, "database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	recorder.queries = append(recorder.queries, req.URL.Path+"?"+req.URL.RawQuery)
+	recorder.lines = append(recorder.lines, strings.Split(string(body), "\n")...)
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (recorder *influxRecorder) setFailing(failing bool) {
+	recorder.mutex.Lock()
+	recorder.failing = failing
+	recorder.mutex.Unlock()
+}
+
+func TestInfluxLineFormat(t *testing.T) {
+	exporter := &influxExporter{config: &influxConfiguration{Measurement: "garden sensors"}, station: "Hub"}
+	line := exporter.formatResult(&monitorResult{
+		Source:    "Plant Monitor",
+		TimeStamp: "2026-10-18T09:00:00Z",
+		Counter:   12,
+		Values:    []monitorResultValue{{Name: "Soil,moisture", Value: 41.5}},
+	})
+	if want := `garden\ sensors,station=Hub,source=Plant\ Monitor Soil\,moisture=41.5,count=12i 1792314000`; line != want {
+		t.Fatalf("expected %s, got %s", want, line)
+	}
+
+	exporter.config = &influxConfiguration{}
+	if line = exporter.formatResult(&monitorResult{Station: "Balcony", Source: "Pump", TimeStamp: "soon"}); line != "sensors,station=Balcony,source=Pump count=0i" {
+		t.Fatalf("expected the default measurement, the remote station and no time, got %s", line)
+	}
+}
+
+func TestInfluxWriteURL(t *testing.T) {
+	v1 := &influxExporter{config: &influxConfiguration{URL: "http://influx:8086/", Database: "jarvis"}}
+	if got := v1.writeURL(); got != "http://influx:8086/write?db=jarvis&precision=s" {
+		t.Errorf("unexpected 1.x address %s", got)
+	}
+	v2 := &influxExporter{config: &influxConfiguration{URL: "http://influx:8086", Organisation: "home", Bucket: "garden"}}
+	if got := v2.writeURL(); got != "http://influx:8086/api/v2/write?bucket=garden&org=home&precision=s" {
+		t.Errorf("unexpected 2.x address %s", got)
+	}
+}
+
+func TestInfluxBuffersWhileUnavailable(t *testing.T) {
+	recorder := &influxRecorder{failing: true}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	exporter := &influxExporter{
+		config:     &influxConfiguration{URL: server.URL, Database: "jarvis"},
+		station:    "Hub",
+		bufferPath: filepath.Join(t.TempDir(), influxBufferFile),
+		client:     &http.Client{Timeout: time.Second},
+	}
+	exporter.batch = []string{exporter.formatResult(&monitorResult{Source: "Plant", Counter: 1})}
+	exporter.flush()
+	exporter.batch = []string{exporter.formatResult(&monitorResult{Source: "Plant", Counter: 2})}
+	exporter.flush()
+
+	buffered, err := ioutil.ReadFile(exporter.bufferPath)
+	if err != nil || strings.Count(string(buffered), "\n") != 2 {
+		t.Fatalf("expected both results in the buffer, got %q, %v", buffered, err)
+	}
+
+	// The buffer goes out ahead of the new results once InfluxDB is back
+	recorder.setFailing(false)
+	exporter.batch = []string{exporter.formatResult(&monitorResult{Source: "Plant", Counter: 3})}
+	exporter.flush()
+	if _, err = os.Stat(exporter.bufferPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the buffer to be removed, got %v", err)
+	}
+	if len(recorder.lines) != 3 || !strings.HasSuffix(recorder.lines[0], "count=1i") || !strings.HasSuffix(recorder.lines[2], "count=3i") {
+		t.Fatalf("expected the three results in order, got %q", recorder.lines)
+	}
+}
+
+func TestInfluxBackfill(t *testing.T) {
+	recorder := &influxRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	data := &dataStore{}
+	input := data.Initialise()
+	data.Start()
+	for counter := int64(1); counter <= 5; counter++ {
+		input <- &monitorResult{Source: "Plant", Counter: counter}
+	}
+	input <- &monitorResult{Station: "Balcony", Source: "Pump", Counter: 1}
+
+	exporter := &influxExporter{
+		config:  &influxConfiguration{URL: server.URL, Organisation: "home", Bucket: "garden", Token: "secret", BatchSize: 2},
+		station: "Hub",
+		client:  &http.Client{Timeout: time.Second},
+	}
+	stored := 0
+	for _, items := range data.Snapshot() {
+		stored += len(items)
+	}
+	sent, err := exporter.Backfill(data)
+	if err != nil || sent != stored || stored == 0 {
+		t.Fatalf("expected all %d stored results to be sent, got %d: %v", stored, sent, err)
+	}
+	if len(recorder.lines) != sent || len(recorder.queries) != (sent+1)/2 {
+		t.Fatalf("expected %d results in batches of two, got %d lines in %d requests", sent, len(recorder.lines), len(recorder.queries))
+	}
+	if !strings.HasPrefix(recorder.queries[0], "/api/v2/write?bucket=garden") {
+		t.Fatalf("expected writes to the configured bucket, got %s", recorder.queries[0])
+	}
+	close(input)
+}
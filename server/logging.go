@@ -11,6 +11,9 @@ import (
 	"time"
 )
 
+// Request bodies on these paths carry passwords or secrets, so they are never written to the log
+var privateRequestPaths = []string{"/api/login", "/api/me/password", "/api/users", "/api/tokens", "/api/stations/discovered/"}
+
 type loggedResponseWriter struct {
 	StatusCode int
 	http.ResponseWriter
@@ -48,6 +51,8 @@ func logRequestsMiddleware(handler http.Handler) http.Handler {
 		}
 		if noPrintBody {
 			body = []byte("File Upload")
+		} else if isPrivateRequest(req) {
+			body = []byte("Private")
 		} else {
 			body, err = httputil.DumpRequest(req, true)
 			if err != nil {
@@ -55,7 +60,9 @@ func logRequestsMiddleware(handler http.Handler) http.Handler {
 			}
 		}
 		handler.ServeHTTP(logger, req)
-		elapsed := roundElapsedDuration(time.Since(start), time.Microsecond*10)
+		duration := time.Since(start)
+		recordRequestMetrics(req, logger.StatusCode, duration.Seconds())
+		elapsed := roundElapsedDuration(duration, time.Microsecond*10)
 
 		bodyStr := string(body)
 		dblNewlineIndex := strings.Index(string(body), "\r\n\r\n")
@@ -70,12 +77,33 @@ func logRequestsMiddleware(handler http.Handler) http.Handler {
 			elapsed,
 			"",
 			req.Method,
-			req.RequestURI,
+			redactedRequestURI(req),
 			bodyStr,
 		)
 	})
 }
 
+func isPrivateRequest(req *http.Request) bool {
+	for _, path := range privateRequestPaths {
+		if strings.HasPrefix(req.URL.Path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// API tokens can be passed as a parameter, which would otherwise end up in the log
+func redactedRequestURI(req *http.Request) string {
+	query := req.URL.Query()
+	if _, ok := query["token"]; !ok {
+		return req.RequestURI
+	}
+	query.Set("token", "REDACTED")
+	redacted := *req.URL
+	redacted.RawQuery = query.Encode()
+	return redacted.RequestURI()
+}
+
 func roundElapsedDuration(d, r time.Duration) time.Duration {
 	if r <= 0 {
 		return d
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return out
+}
+
+func TestLogRequestsKeepsSecretsOut(t *testing.T) {
+	handler := logRequestsMiddleware(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNoContent)
+	}))
+	send := func(method, target, body string) string {
+		out := captureLog(t)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, target, strings.NewReader(body)))
+		return out.String()
+	}
+
+	if logged := send("POST", "/api/login", `{"name":"admin","password":"hunter2"}`); strings.Contains(logged, "hunter2") {
+		t.Errorf("expected the login password to be left out, got %s", logged)
+	}
+	if logged := send("POST", "/api/me/password", `{"current":"hunter2","password":"correct horse"}`); strings.Contains(logged, "horse") {
+		t.Errorf("expected the new password to be left out, got %s", logged)
+	}
+	if logged := send("POST", "/api/stations/discovered/Plant", `{"secret":"plant-secret"}`); strings.Contains(logged, "plant-secret") {
+		t.Errorf("expected the station secret to be left out, got %s", logged)
+	}
+
+	logged := send("GET", "/api/speech?text=hello&token=abc123", "")
+	if strings.Contains(logged, "abc123") || !strings.Contains(logged, "token=REDACTED") || !strings.Contains(logged, "text=hello") {
+		t.Errorf("expected only the token parameter to be redacted, got %s", logged)
+	}
+
+	// Other requests are still logged in full
+	if logged = send("POST", "/api/sources/Pump/effectors", `{"name":"valve","action":"on"}`); !strings.Contains(logged, `"action":"on"`) || !strings.Contains(logged, "[204]") {
+		t.Errorf("expected the command and status in the log, got %s", logged)
+	}
+}
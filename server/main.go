@@ -5,9 +5,11 @@ import (
 	"flag"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,6 +18,14 @@ import (
 	"github.com/tarm/serial"
 )
 
+const (
+	serverVersion = "1.1.0"
+	apiVersion    = 1
+
+	defaultContentPolicy = "default-src 'self'; img-src 'self' data:; style-src 'self' 'unsafe-inline'; " +
+		"connect-src 'self' ws: wss:; frame-ancestors 'none'"
+)
+
 func main() {
 	log.Printf("[Server] Starting...")
 
@@ -24,6 +34,13 @@ func main() {
 		port       = flag.String("port", "80", "port to serve on")
 		monitors   = &monitorStore{}
 		weather    = &weatherService{}
+		stations   = &stationFederation{}
+		health     = &stationHealthChecker{}
+		replicator = &stationReplicator{}
+		discovery  = &discoveryService{}
+		influx     = &influxExporter{}
+		bridge     = &mqttBridge{}
+		schedules  = &scheduler{}
 	)
 	flag.Parse()
 
@@ -38,16 +55,40 @@ func main() {
 	dataChan := data.Initialise()
 	data.Start()
 
+	weatherAlerts := make(chan *weatherAlertEvent)
 	if config.Weather != nil {
 		log.Printf("[Main] Starting weather service")
-		weather.Start(config.Weather)
+		weather.AddListener(dataChan)
+		weather.AddAlertListener(weatherAlerts)
+		if err := weather.Start(config.Weather, config.DataPath); err != nil {
+			log.Printf("[Main] Unable to start weather service: %v", err)
+		}
 	}
 
 	log.Printf("[Main] Initialising webserver")
 	addr := ":" + *port
-	api, srv := initialiseWebServer(addr, data, monitors, weather, config)
+	api, srv := initialiseWebServer(addr, data, monitors, weather, health, replicator, discovery, config)
 	out := make(chan *monitorResult)
 	go handleResult(out, api)
+	metricsChan := api.metrics.Initialise()
+	api.influx = influx
+	influxChan := influx.Initialise()
+	mqttChan, mqttEffectors, mqttTypes := bridge.Initialise()
+	if config.MQTT != nil && !config.MQTT.IsDisabled {
+		log.Printf("[Main] Starting MQTT bridge")
+		if err := bridge.Start(config, monitors, api.audit); err != nil {
+			log.Printf("[Main] Unable to start MQTT bridge: %v", err)
+		}
+	}
+	if config.Influx != nil && !config.Influx.IsDisabled {
+		log.Printf("[Main] Starting InfluxDB exporter")
+		if err := influx.Start(config.Influx, config.DataPath, config.StationName()); err != nil {
+			log.Printf("[Main] Unable to start InfluxDB exporter: %v", err)
+		}
+	}
+	events := make(chan *stationStatusEvent)
+	go handleStationEvents(events, api)
+	go handleWeatherAlerts(weatherAlerts, api)
 
 	log.Printf("[Main] Starting monitors")
 	for _, sensor := range config.Sources {
@@ -62,14 +103,96 @@ func main() {
 
 			mon.AddListener(out)
 			mon.AddListener(dataChan)
+			mon.AddListener(metricsChan)
+			if influx.isRunning {
+				mon.AddListener(influxChan)
+			}
+			if bridge.isRunning {
+				mon.AddListener(mqttChan)
+				mon.AddEffectorListener(mqttEffectors)
+				mon.AddTypesListener(mqttTypes)
+			}
 			monitors.Add(sensor.Name, mon)
 		} else {
 			log.Printf("[Main] Skipping monitor %s - disabled", sensor.Name)
 		}
 	}
 
+	if config.Discovery != nil && !config.Discovery.IsDisabled {
+		log.Printf("[Main] Starting station discovery")
+		if err := discovery.Start(config, *port, stations, health, replicator); err != nil {
+			log.Printf("[Main] Unable to start station discovery: %v", err)
+		}
+	}
+
+	log.Printf("[Main] Starting station links")
+	stations.AddListener(out)
+	stations.AddListener(metricsChan)
+	if influx.isRunning {
+		stations.AddListener(influxChan)
+	}
+	if bridge.isRunning {
+		stations.AddListener(mqttChan)
+	}
+	stations.Start(config, api.stations)
+	health.AddListener(events)
+	health.Start(config)
+
+	if config.Replication != nil {
+		log.Printf("[Main] Starting station replication")
+		replicator.Start(config.Replication, config.StationList(), api.stations, dataChan)
+	}
+
+	if len(config.Schedules) > 0 {
+		log.Printf("[Main] Starting schedules")
+		api.schedules = schedules
+		if err := schedules.Start(config.Schedules, monitors, weather, api.audit); err != nil {
+			log.Printf("[Main] Unable to start schedules: %v", err)
+		}
+	}
+
 	log.Printf("[Main] Starting webserver")
 	api.start()
+	servers := []*http.Server{srv}
+	var certificates *certificateManager
+	if config.TLS != nil && !config.TLS.IsDisabled {
+		certificates, err = newCertificateManager(config.TLS, config.DataPath, config.StationName())
+		if err != nil {
+			log.Fatalf("[Main] Unable to load certificate: %v", err)
+		}
+		tlsConfig, err := certificates.TLSConfig(config.TLS.ClientCA)
+		if err != nil {
+			log.Fatalf("[Main] Unable to configure TLS: %v", err)
+		}
+		certificates.Start()
+
+		tlsPort := config.TLS.Port
+		if tlsPort == "" {
+			tlsPort = defaultTLSPort
+		}
+		secureSrv := &http.Server{
+			Addr:         ":" + tlsPort,
+			Handler:      srv.Handler,
+			TLSConfig:    tlsConfig,
+			IdleTimeout:  srv.IdleTimeout,
+			WriteTimeout: srv.WriteTimeout,
+			ReadTimeout:  srv.ReadTimeout,
+		}
+		if !config.TLS.NoRedirect {
+			srv.Handler = redirectToHTTPS(tlsPort)
+		}
+		servers = append(servers, secureSrv)
+		go func() {
+			log.Printf("[WebServer] Listening securely on %s", secureSrv.Addr)
+			if err := secureSrv.ListenAndServeTLS("", ""); err != nil {
+				if err == http.ErrServerClosed {
+					log.Printf("[WebServer] Stopped secure server")
+				} else {
+					log.Printf("[WebServer] Unable to start secure server: %v", err)
+				}
+			}
+		}()
+	}
 	go func() {
 		log.Printf("[WebServer] Listening on %s", addr)
 		if err := srv.ListenAndServe(); err != nil {
@@ -80,8 +203,12 @@ func main() {
 			}
 		}
 	}()
-	waitForShutdown(srv)
+	waitForShutdown(servers...)
+	if certificates != nil {
+		certificates.Stop()
+	}
 
+	stopService("schedules", schedules.Stop)
 	log.Printf("[Main] Stopping monitors")
 	for _, mon := range *monitors {
 		mon.Stop()
@@ -89,9 +216,38 @@ func main() {
 			log.Fatalf("[Main] Unable to stop monitor %s: %v", mon.Name(), err)
 		}
 	}
-	weather.Stop(time.Second * 5)
-	close(out)
+	weatherStopped := stopService("weather service", weather.Stop)
+	stationsStopped := stopService("station links", stations.Stop)
+	healthStopped := stopService("station health checks", health.Stop)
+	stopService("replication", replicator.Stop)
+	stopService("discovery", discovery.Stop)
+	stopService("InfluxDB exporter", influx.Stop)
+	stopService("MQTT bridge", bridge.Stop)
+	// Closing a channel that a running service still sends to would panic, so they are left open
+	if stationsStopped {
+		close(out)
+	}
+	if stationsStopped && weatherStopped {
+		api.metrics.Close()
+	}
+	if healthStopped {
+		close(events)
+	}
+	if weatherStopped {
+		close(weatherAlerts)
+	}
+	api.speech.Close()
+	api.audit.Close()
+}
+
+func stopService(name string, stop func(time.Duration) error) bool {
+	if err := stop(time.Second * 5); err != nil {
+		log.Printf("[Main] Unable to stop %s: %v", name, err)
+		return false
+	}
+	return true
 }
+
 func handleResult(input <-chan *monitorResult, srv *webAPI) {
 	for {
 		result, open := <-input
@@ -104,7 +260,32 @@ func handleResult(input <-chan *monitorResult, srv *webAPI) {
 	}
 }
 
-func initialiseWebServer(addr string, data *dataStore, monitors *monitorStore, weather *weatherService, config *appConfiguration) (*webAPI, *http.Server) {
+func handleStationEvents(input <-chan *stationStatusEvent, srv *webAPI) {
+	for {
+		event, open := <-input
+		if open {
+			log.Printf("[Main] Station %s is now %s", event.Station, event.Status)
+			srv.hub.sendEvent(event, event.Station)
+		} else {
+			return
+		}
+	}
+}
+
+func handleWeatherAlerts(input <-chan *weatherAlertEvent, srv *webAPI) {
+	for {
+		event, open := <-input
+		if open {
+			log.Printf("[Main] Weather alert %s is now %s", event.Alert.Name, event.Status)
+			srv.announceWeatherAlert(event)
+			srv.hub.sendEvent(event, "")
+		} else {
+			return
+		}
+	}
+}
+
+func initialiseWebServer(addr string, data *dataStore, monitors *monitorStore, weather *weatherService, health *stationHealthChecker, replicator *stationReplicator, discovery *discoveryService, config *appConfiguration) (*webAPI, *http.Server) {
 	rootMiddleware := interpose.New()
 
 	rootRouter := mux.NewRouter()
@@ -115,20 +296,35 @@ func initialiseWebServer(addr string, data *dataStore, monitors *monitorStore, w
 	rootMiddleware.Use(logRequestsMiddleware)
 	rootMiddleware.UseHandler(rootRouter)
 
-	api, err := newWebAPI(addr, data, monitors, weather, config)
+	api, err := newWebAPI(addr, data, monitors, weather, health, replicator, discovery, config)
 	if err != nil {
 		log.Fatalf("[Main] Unable to initialise API: %v", err)
 	}
 
 	apiRouter := api.Router
 	apiMiddleware := interpose.New()
-	apiMiddleware.Use(setOriginRequestAndHeadersMiddleware)
+	apiMiddleware.Use(api.setOriginRequestAndHeadersMiddleware(config.CORS))
+	apiMiddleware.Use(api.authenticateStationsMiddleware)
+	apiMiddleware.Use(api.rateLimitMiddleware(newRateLimiter(config.RateLimits)))
+	apiMiddleware.Use(api.authenticateUsersMiddleware)
 	apiMiddleware.UseHandler(apiRouter)
 	rootRouter.PathPrefix("/api").Handler(apiMiddleware)
 
+	api.metrics = newMetricsCollector(monitors, api.hub, weather)
+	if config.metricsEnabled() {
+		metricsHandler, err := api.metrics.Handler(config.Metrics)
+		if err != nil {
+			log.Fatalf("[Main] Unable to initialise metrics: %v", err)
+		}
+		rootRouter.Handle("/metrics", metricsHandler).Methods("GET")
+	} else if config.Metrics == nil || !config.Metrics.IsDisabled {
+		log.Printf("[Main] Not serving metrics, a metrics token is needed when authentication is enabled")
+	}
+
 	log.Printf("[Main] Serving website from %s", config.StaticPath)
 	fileServeRouter := formatFileName(http.FileServer(http.Dir(config.StaticPath)))
 	fileServeMiddleware := interpose.New()
+	fileServeMiddleware.Use(securityHeadersMiddleware(config.ContentPolicy))
 	fileServeMiddleware.Use(disableCaching)
 	fileServeMiddleware.UseHandler(fileServeRouter)
 	rootRouter.PathPrefix("/").Handler(fileServeMiddleware)
@@ -143,20 +339,76 @@ func initialiseWebServer(addr string, data *dataStore, monitors *monitorStore, w
 	return api, srv
 }
 
-func setOriginRequestAndHeadersMiddleware(handler http.Handler) http.Handler {
-	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
-		if origin := req.Header.Get("Origin"); origin != "" {
-			resp.Header().Set("Access-Control-Allow-Origin", origin)
-			resp.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, PATCH")
-			resp.Header().Set("Access-Control-Allow-Headers",
-				"Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+func (api *webAPI) setOriginRequestAndHeadersMiddleware(config *corsConfiguration) func(http.Handler) http.Handler {
+	methods := "POST, GET, OPTIONS, PUT, DELETE, PATCH"
+	headers := "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, X-XSRF-Token, Authorization"
+	origins := map[string]bool{}
+	if config != nil {
+		if len(config.AllowedMethods) > 0 {
+			methods = strings.Join(config.AllowedMethods, ", ")
 		}
-		if req.Method == "OPTIONS" {
-			return
+		if len(config.AllowedHeaders) > 0 {
+			headers = strings.Join(config.AllowedHeaders, ", ")
 		}
+		for _, origin := range config.AllowedOrigins {
+			origins[strings.TrimSuffix(origin, "/")] = true
+		}
+	}
 
-		handler.ServeHTTP(resp, req)
-	})
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			origin := req.Header.Get("Origin")
+			if origin != "" && !isSameOrigin(origin, req) {
+				if !origins[origin] && !origins["*"] {
+					// Browsers still send simple requests cross-origin, so anything that could change state is refused
+					if req.Method != "GET" && req.Method != "HEAD" {
+						log.Printf("[WebServer] Rejecting %s request from origin %s", req.Method, origin)
+						api.writeStatusJSON(resp, http.StatusForbidden, "Forbidden", "Origin not allowed")
+						return
+					}
+				} else {
+					resp.Header().Set("Access-Control-Allow-Origin", origin)
+					resp.Header().Set("Access-Control-Allow-Methods", methods)
+					resp.Header().Set("Access-Control-Allow-Headers", headers)
+					resp.Header().Add("Vary", "Origin")
+					if config.AllowCredentials {
+						resp.Header().Set("Access-Control-Allow-Credentials", "true")
+					}
+					if config.MaxAge > 0 {
+						resp.Header().Set("Access-Control-Max-Age", strconv.Itoa(config.MaxAge))
+					}
+				}
+			}
+			if req.Method == "OPTIONS" {
+				return
+			}
+
+			handler.ServeHTTP(resp, req)
+		})
+	}
+}
+
+func isSameOrigin(origin string, req *http.Request) bool {
+	parsed, err := url.Parse(origin)
+	return err == nil && parsed.Host == req.Host
+}
+
+func securityHeadersMiddleware(contentPolicy string) func(http.Handler) http.Handler {
+	if contentPolicy == "" {
+		contentPolicy = defaultContentPolicy
+	}
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			if req.TLS != nil {
+				resp.Header().Set("Strict-Transport-Security", "max-age=31536000")
+			}
+			resp.Header().Set("Content-Security-Policy", contentPolicy)
+			resp.Header().Set("X-Content-Type-Options", "nosniff")
+			resp.Header().Set("X-Frame-Options", "DENY")
+			resp.Header().Set("Referrer-Policy", "same-origin")
+			handler.ServeHTTP(resp, req)
+		})
+	}
 }
 
 func disableCaching(handler http.Handler) http.Handler {
@@ -168,7 +420,7 @@ func disableCaching(handler http.Handler) http.Handler {
 	})
 }
 
-func waitForShutdown(srv *http.Server) {
+func waitForShutdown(servers ...*http.Server) {
 	signalChan := make(chan os.Signal, 1)
 	cleanupDone := make(chan bool)
 	signal.Notify(signalChan, os.Interrupt)
@@ -176,9 +428,11 @@ func waitForShutdown(srv *http.Server) {
 		for _ = range signalChan {
 			log.Printf("[WebServer] Stopping")
 			ctx, f := context.WithTimeout(context.Background(), 15*time.Second)
-			if err := srv.Shutdown(ctx); err != nil {
-				log.Println("[WebServer] Unable to shutdown server gracefully: " + err.Error())
-				srv.Close()
+			for _, srv := range servers {
+				if err := srv.Shutdown(ctx); err != nil {
+					log.Println("[WebServer] Unable to shutdown server gracefully: " + err.Error())
+					srv.Close()
+				}
 			}
 			f()
 			cleanupDone <- true
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCORSPolicy(t *testing.T) {
+	api := &webAPI{}
+	reached := false
+	handler := api.setOriginRequestAndHeadersMiddleware(&corsConfiguration{
+		AllowedOrigins:   []string{"https://dashboard.local/"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	})(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		reached = true
+	}))
+	send := func(method, origin string) *httptest.ResponseRecorder {
+		reached = false
+		req := httptest.NewRequest(method, "http://jarvis.local/api/sources/Pump/effectors", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := send("POST", ""); !reached || resp.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("expected a request without an origin to pass untouched")
+	}
+	if send("POST", "http://jarvis.local"); !reached {
+		t.Error("expected a same origin request to pass")
+	}
+
+	resp := send("POST", "https://evil.example")
+	status := map[string]string{}
+	json.Unmarshal(resp.Body.Bytes(), &status)
+	if reached || resp.Code != http.StatusForbidden || status["status"] != "Forbidden" {
+		t.Errorf("expected a command from another origin to be refused as JSON, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp = send("GET", "https://evil.example"); !reached || resp.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("expected a read from another origin to pass without allowing the browser to see it")
+	}
+
+	resp = send("POST", "https://dashboard.local")
+	if !reached || resp.Header().Get("Access-Control-Allow-Origin") != "https://dashboard.local" || resp.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("expected the allowed origin with credentials, got %v", resp.Header())
+	}
+	resp = send("OPTIONS", "https://dashboard.local")
+	if reached || resp.Header().Get("Access-Control-Max-Age") != "600" || !strings.Contains(resp.Header().Get("Access-Control-Allow-Headers"), "X-XSRF-Token") {
+		t.Errorf("expected the preflight to be answered by the middleware, got %v", resp.Header())
+	}
+}
+
+func TestCORSWildcardWithCredentialsIsRefused(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(cors string) error {
+		ioutil.WriteFile(path, []byte(`{"cors":`+cors+`}`), 0600)
+		_, err := readConfiguration(path)
+		return err
+	}
+
+	if err := write(`{"origins":["*"],"credentials":true}`); err == nil {
+		t.Error("expected any origin with credentials to be refused")
+	}
+	if err := write(`{"origins":["*"]}`); err != nil {
+		t.Errorf("expected any origin without credentials to be accepted: %v", err)
+	}
+	if err := write(`{"origins":["https://dashboard.local"],"credentials":true}`); err != nil {
+		t.Errorf("expected a listed origin with credentials to be accepted: %v", err)
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie string
+		header string
+		want   bool
+	}{
+		{"matching", "token-1", "token-1", true},
+		{"different", "token-1", "token-2", false},
+		{"no header", "token-1", "", false},
+		{"empty cookie", "", "", false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/api/users", nil)
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: test.cookie})
+		req.Header.Set(csrfHeader, test.header)
+		if got := hasValidCSRFToken(req); got != test.want {
+			t.Errorf("%s: expected %t, got %t", test.name, test.want, got)
+		}
+	}
+
+	if hasValidCSRFToken(httptest.NewRequest("POST", "/api/users", nil)) {
+		t.Error("expected a request without the cookie to fail")
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	handler := securityHeadersMiddleware("")(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {}))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/index.html", nil))
+
+	if resp.Header().Get("Content-Security-Policy") != defaultContentPolicy || resp.Header().Get("X-Frame-Options") != "DENY" {
+		t.Errorf("expected the default policy and framing refused, got %v", resp.Header())
+	}
+	if resp.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("expected no HSTS header over plain HTTP")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "jarvis_http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "path", "code"})
+
+	weatherDownloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "jarvis_weather_downloads_total",
+		Help: "Weather downloads by result.",
+	}, []string{"result"})
+)
+
+var (
+	sensorValueDesc       = prometheus.NewDesc("jarvis_sensor_value", "Latest value reported by a sensor.", []string{"station", "source", "sensor"}, nil)
+	sensorUpdatedDesc     = prometheus.NewDesc("jarvis_sensor_updated_count", "Counter of the latest result from a source.", []string{"station", "source"}, nil)
+	monitorConnectedDesc  = prometheus.NewDesc("jarvis_monitor_connected", "Whether a monitor is connected to its source.", []string{"source"}, nil)
+	monitorResultsDesc    = prometheus.NewDesc("jarvis_monitor_results_total", "Results received by a monitor.", []string{"source"}, nil)
+	monitorBytesDesc      = prometheus.NewDesc("jarvis_monitor_received_bytes_total", "Bytes received by a monitor.", []string{"source"}, nil)
+	monitorLinesDesc      = prometheus.NewDesc("jarvis_monitor_received_lines_total", "Lines received by a monitor.", []string{"source"}, nil)
+	monitorUnknownDesc    = prometheus.NewDesc("jarvis_monitor_unknown_lines_total", "Lines received by a monitor that could not be understood.", []string{"source"}, nil)
+	websocketClientsDesc  = prometheus.NewDesc("jarvis_websocket_clients", "Connected websocket clients.", nil, nil)
+	weatherDownloadedDesc = prometheus.NewDesc("jarvis_weather_downloaded_timestamp_seconds", "Time of the last successful weather download.", nil, nil)
+)
+
+type sensorMetric struct {
+	station string
+	source  string
+	counter int64
+	values  []monitorResultValue
+}
+
+type metricsCollector struct {
+	monitors *monitorStore
+	hub      *websocketHub
+	weather  *weatherService
+	sensors  map[string]*sensorMetric
+	input    chan *monitorResult
+	mutex    sync.Mutex
+}
+
+func newMetricsCollector(monitors *monitorStore, hub *websocketHub, weather *weatherService) *metricsCollector {
+	return &metricsCollector{
+		monitors: monitors,
+		hub:      hub,
+		weather:  weather,
+		sensors:  map[string]*sensorMetric{},
+	}
+}
+
+func (metrics *metricsCollector) Initialise() monitorListener {
+	metrics.input = make(chan *monitorResult)
+	go metrics.run()
+	return metrics.input
+}
+
+func (metrics *metricsCollector) run() {
+	for result := range metrics.input {
+		metrics.mutex.Lock()
+		metrics.sensors[storeKey(result.Station, result.Source)] = &sensorMetric{
+			station: result.Station,
+			source:  result.Source,
+			counter: result.Counter,
+			values:  result.Values,
+		}
+		metrics.mutex.Unlock()
+	}
+}
+
+func (metrics *metricsCollector) Close() {
+	if metrics.input != nil {
+		close(metrics.input)
+	}
+}
+
+func (metrics *metricsCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- sensorValueDesc
+	descs <- sensorUpdatedDesc
+	descs <- monitorConnectedDesc
+	descs <- monitorResultsDesc
+	descs <- monitorBytesDesc
+	descs <- monitorLinesDesc
+	descs <- monitorUnknownDesc
+	descs <- websocketClientsDesc
+	descs <- weatherDownloadedDesc
+}
+
+func (metrics *metricsCollector) Collect(values chan<- prometheus.Metric) {
+	metrics.mutex.Lock()
+	for _, sensor := range metrics.sensors {
+		values <- prometheus.MustNewConstMetric(sensorUpdatedDesc, prometheus.GaugeValue, float64(sensor.counter), sensor.station, sensor.source)
+		for _, value := range sensor.values {
+			values <- prometheus.MustNewConstMetric(sensorValueDesc, prometheus.GaugeValue, float64(value.Value), sensor.station, sensor.source, value.Name)
+		}
+	}
+	metrics.mutex.Unlock()
+
+	for name, mon := range *metrics.monitors {
+		connected := 0.0
+		if mon.IsRunning() {
+			connected = 1
+		}
+		stats := mon.Statistics()
+		values <- prometheus.MustNewConstMetric(monitorConnectedDesc, prometheus.GaugeValue, connected, name)
+		values <- prometheus.MustNewConstMetric(monitorResultsDesc, prometheus.CounterValue, float64(stats.Counter), name)
+		values <- prometheus.MustNewConstMetric(monitorBytesDesc, prometheus.CounterValue, float64(stats.BytesReceived), name)
+		values <- prometheus.MustNewConstMetric(monitorLinesDesc, prometheus.CounterValue, float64(stats.LinesReceived), name)
+		values <- prometheus.MustNewConstMetric(monitorUnknownDesc, prometheus.CounterValue, float64(stats.UnknownLines), name)
+	}
+
+	values <- prometheus.MustNewConstMetric(websocketClientsDesc, prometheus.GaugeValue, float64(metrics.hub.ClientCount()))
+	if downloaded := metrics.weather.Downloaded(); !downloaded.IsZero() {
+		values <- prometheus.MustNewConstMetric(weatherDownloadedDesc, prometheus.GaugeValue, float64(downloaded.Unix()))
+	}
+}
+
+func (metrics *metricsCollector) Handler(config *metricsConfiguration) (http.Handler, error) {
+	registry := prometheus.NewRegistry()
+	for _, collector := range []prometheus.Collector{metrics, httpRequestDuration, weatherDownloads} {
+		if err := registry.Register(collector); err != nil {
+			return nil, fmt.Errorf("Unable to register metrics: %v", err)
+		}
+	}
+
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	if config == nil || config.Token == "" {
+		return handler, nil
+	}
+
+	// Prometheus sends a fixed bearer token rather than logging in like a user
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.Token)) != 1 {
+			log.Printf("[Metrics] Rejecting unauthenticated request from %s", req.RemoteAddr)
+			resp.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(resp, req)
+	}), nil
+}
+
+func recordRequestMetrics(req *http.Request, statusCode int, seconds float64) {
+	httpRequestDuration.WithLabelValues(req.Method, metricsPath(req.URL.Path), strconv.Itoa(statusCode)).Observe(seconds)
+}
+
+func metricsPath(path string) string {
+	// Only keep the first part of API paths so source and station names do not explode the label count
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if parts[0] != "api" {
+		if path == "/metrics" {
+			return path
+		}
+		return "static"
+	}
+	if len(parts) == 1 {
+		return "/api"
+	}
+	return "/api/" + parts[1]
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrapeMetrics(t *testing.T, handler http.Handler, token string) (int, string) {
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.Code, string(body)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	metrics := newMetricsCollector(&monitorStore{}, newHub(), &weatherService{})
+	input := metrics.Initialise()
+	defer metrics.Close()
+
+	// The collector handles one result at a time, so the first is stored once the second is taken
+	result := &monitorResult{Station: "Balcony", Source: "Plant", Counter: 7, Values: []monitorResultValue{{Name: "Moisture", Value: 41}}}
+	input <- result
+	input <- result
+
+	handler, err := metrics.Handler(&metricsConfiguration{Token: "scraper"})
+	if err != nil {
+		t.Fatalf("unable to create handler: %v", err)
+	}
+	if code, _ := scrapeMetrics(t, handler, ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected a scrape without the token to be refused, got %d", code)
+	}
+	if code, _ := scrapeMetrics(t, handler, "guess"); code != http.StatusUnauthorized {
+		t.Fatalf("expected a scrape with the wrong token to be refused, got %d", code)
+	}
+
+	code, body := scrapeMetrics(t, handler, "scraper")
+	if code != http.StatusOK {
+		t.Fatalf("expected the metrics with the token, got %d", code)
+	}
+	for _, line := range []string{
+		`jarvis_sensor_value{sensor="Moisture",source="Plant",station="Balcony"} 41`,
+		`jarvis_sensor_updated_count{source="Plant",station="Balcony"} 7`,
+		`jarvis_websocket_clients 0`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected %s in the metrics", line)
+		}
+	}
+	if strings.Contains(body, "jarvis_weather_downloaded_timestamp_seconds ") {
+		t.Error("expected no download time before the first weather download")
+	}
+}
+
+func TestMetricsNeedATokenWithAuthentication(t *testing.T) {
+	enabled := &authenticationConfiguration{}
+	for _, config := range []struct {
+		name    string
+		config  *appConfiguration
+		enabled bool
+	}{
+		{"open station", &appConfiguration{}, true},
+		{"disabled metrics", &appConfiguration{Metrics: &metricsConfiguration{IsDisabled: true}}, false},
+		{"users without a token", &appConfiguration{Authentication: enabled}, false},
+		{"users with a token", &appConfiguration{Authentication: enabled, Metrics: &metricsConfiguration{Token: "scraper"}}, true},
+		{"users turned off", &appConfiguration{Authentication: &authenticationConfiguration{IsDisabled: true}}, true},
+	} {
+		if got := config.config.metricsEnabled(); got != config.enabled {
+			t.Errorf("%s: expected metrics enabled %t, got %t", config.name, config.enabled, got)
+		}
+	}
+}
+
+func TestMetricsPath(t *testing.T) {
+	paths := map[string]string{
+		"/metrics":                        "/metrics",
+		"/index.html":                     "static",
+		"/api":                            "/api",
+		"/api/sources":                    "/api/sources",
+		"/api/sources/Plant Monitor/logs": "/api/sources",
+		"/api/stations/Balcony/sources":   "/api/stations",
+	}
+	for path, label := range paths {
+		if got := metricsPath(path); got != label {
+			t.Errorf("expected %s to be recorded as %s, got %s", path, label, got)
+		}
+	}
+}
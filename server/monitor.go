@@ -8,12 +8,14 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tarm/serial"
 )
 
 type monitorResult struct {
+	Station   string               `json:"station,omitempty"`
 	Source    string               `json:"source"`
 	TimeStamp string               `json:"time"`
 	Counter   int64                `json:"count"`
@@ -27,6 +29,31 @@ type monitorResultValue struct {
 
 type monitorListener chan<- *monitorResult
 
+type effectorEvent struct {
+	Source   string `json:"source"`
+	Effector string `json:"effector"`
+	Action   string `json:"action"`
+	Duration *int   `json:"duration,omitempty"`
+	Time     string `json:"time"`
+}
+
+type effectorListener chan<- *effectorEvent
+
+type monitorTypesEvent struct {
+	Source  string
+	Inputs  []string
+	Outputs []string
+}
+
+type monitorTypesListener chan<- *monitorTypesEvent
+
+type monitorStatistics struct {
+	Counter       int64 `json:"count"`
+	BytesReceived int64 `json:"bytes"`
+	LinesReceived int64 `json:"lines"`
+	UnknownLines  int64 `json:"unknown"`
+}
+
 type monitorStore map[string]*monitor
 
 func (store monitorStore) Get(name string) *monitor {
@@ -45,7 +72,12 @@ type monitor struct {
 	stopResult   chan int
 	lastError    error
 	listeners    map[monitorListener]bool
+	effectors    map[effectorListener]bool
+	types        map[monitorTypesListener]bool
 	counter      int64
+	bytes        int64
+	lines        int64
+	unknown      int64
 	inputValues  []string
 	outputValues []string
 	mux          sync.Mutex
@@ -67,6 +99,34 @@ func (mon *monitor) RemoveListener(listener monitorListener) {
 	}
 }
 
+func (mon *monitor) AddEffectorListener(listener effectorListener) {
+	if mon.effectors == nil {
+		mon.effectors = map[effectorListener]bool{}
+	}
+	mon.effectors[listener] = true
+}
+
+func (mon *monitor) RemoveEffectorListener(listener effectorListener) {
+	if mon.effectors == nil {
+		return
+	}
+	delete(mon.effectors, listener)
+}
+
+func (mon *monitor) AddTypesListener(listener monitorTypesListener) {
+	if mon.types == nil {
+		mon.types = map[monitorTypesListener]bool{}
+	}
+	mon.types[listener] = true
+}
+
+func (mon *monitor) RemoveTypesListener(listener monitorTypesListener) {
+	if mon.types == nil {
+		return
+	}
+	delete(mon.types, listener)
+}
+
 func (mon *monitor) Start(config *serial.Config, name string) error {
 	if mon.running {
 		return fmt.Errorf("Monitor is already running")
@@ -110,6 +170,15 @@ func (mon *monitor) LastError() error {
 	return mon.lastError
 }
 
+func (mon *monitor) Statistics() monitorStatistics {
+	return monitorStatistics{
+		Counter:       atomic.LoadInt64(&mon.counter),
+		BytesReceived: atomic.LoadInt64(&mon.bytes),
+		LinesReceived: atomic.LoadInt64(&mon.lines),
+		UnknownLines:  atomic.LoadInt64(&mon.unknown),
+	}
+}
+
 func (mon *monitor) InputTypes() []string {
 	mon.mux.Lock()
 	defer mon.mux.Unlock()
@@ -164,7 +233,21 @@ func (mon *monitor) SendCommand(cmd *command) error {
 	} else {
 		msg = fmt.Sprintf("C:%d%s", outputNumber, action)
 	}
-	return mon.send(msg)
+	if err := mon.send(msg); err != nil {
+		return err
+	}
+
+	event := &effectorEvent{
+		Source:   mon.name,
+		Effector: cmd.Name,
+		Action:   cmd.Action,
+		Duration: cmd.Duration,
+		Time:     time.Now().Format(time.RFC3339),
+	}
+	for listener := range mon.effectors {
+		listener <- event
+	}
+	return nil
 }
 
 func (mon *monitor) send(msg string) error {
@@ -200,6 +283,7 @@ func (mon *monitor) run() {
 				}
 			} else if n > 0 {
 				log.Printf("[Monitor] Received %d bytes from %s", n, mon.name)
+				atomic.AddInt64(&mon.bytes, int64(n))
 			}
 			for loop := 0; loop < n; loop++ {
 				char := buf[loop]
@@ -210,6 +294,7 @@ func (mon *monitor) run() {
 				case '\n':
 					rawData := out.String()
 					if len(rawData) > 0 {
+						atomic.AddInt64(&mon.lines, 1)
 						msgData := strings.Split(rawData[2:], ",")
 						switch rawData[0] {
 						case 'O':
@@ -226,6 +311,7 @@ func (mon *monitor) run() {
 
 						default:
 							log.Printf("[Monitor] Received unknown input '%s' from %s", rawData, mon.name)
+							atomic.AddInt64(&mon.unknown, 1)
 						}
 						out.Reset()
 					}
@@ -246,6 +332,7 @@ func (mon *monitor) loadOutputTypes(values []string) {
 	mon.mux.Lock()
 	mon.outputValues = values
 	mon.mux.Unlock()
+	mon.notifyTypes()
 }
 
 func (mon *monitor) loadInputTypes(values []string) {
@@ -253,6 +340,18 @@ func (mon *monitor) loadInputTypes(values []string) {
 	mon.mux.Lock()
 	mon.inputValues = values
 	mon.mux.Unlock()
+	mon.notifyTypes()
+}
+
+func (mon *monitor) notifyTypes() {
+	event := &monitorTypesEvent{
+		Source:  mon.name,
+		Inputs:  mon.InputTypes(),
+		Outputs: mon.OutputTypes(),
+	}
+	for listener := range mon.types {
+		listener <- event
+	}
 }
 
 func (mon *monitor) readData(values []string) {
@@ -271,7 +370,7 @@ func (mon *monitor) readData(values []string) {
 		}
 	}
 
-	mon.counter++
+	atomic.AddInt64(&mon.counter, 1)
 	if mon.listeners != nil {
 		for listener := range mon.listeners {
 			listener <- result
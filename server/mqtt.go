@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultMQTTPrefix = "jarvis"
+	mqttOnline        = "online"
+	mqttOffline       = "offline"
+	mqttWaitTime      = 10 * time.Second
+
+	homeAssistantOnline = "online"
+)
+
+var mqttTopicEscaper = strings.NewReplacer("/", "_", "+", "_", "#", "_", " ", "_")
+
+type mqttBridge struct {
+	config         *mqttConfiguration
+	station        string
+	prefix         string
+	sources        []monitorConfiguration
+	monitors       *monitorStore
+	audit          *auditLog
+	client         mqtt.Client
+	input          chan *monitorResult
+	effectors      chan *effectorEvent
+	types          chan *monitorTypesEvent
+	discoveryPath  string
+	announced      map[string][]string
+	discoveryMutex sync.Mutex
+	isRunning      bool
+	stopRequest    chan int
+	stopReply      chan int
+}
+
+func (bridge *mqttBridge) Initialise() (monitorListener, effectorListener, monitorTypesListener) {
+	bridge.input = make(chan *monitorResult)
+	bridge.effectors = make(chan *effectorEvent)
+	bridge.types = make(chan *monitorTypesEvent)
+	return bridge.input, bridge.effectors, bridge.types
+}
+
+func (bridge *mqttBridge) Start(appConfig *appConfiguration, monitors *monitorStore, audit *auditLog) error {
+	if bridge.isRunning {
+		return nil
+	}
+	config := appConfig.MQTT
+	if config.Broker == "" {
+		return errors.New("No MQTT broker has been configured")
+	}
+	if config.QoS > 2 {
+		return fmt.Errorf("Invalid MQTT QoS %d, it must be 0, 1 or 2", config.QoS)
+	}
+
+	station := appConfig.StationName()
+	bridge.config = config
+	bridge.station = station
+	bridge.sources = appConfig.Sources
+	bridge.monitors = monitors
+	bridge.audit = audit
+	bridge.discoveryPath = filepath.Join(appConfig.DataPath, mqttDiscoveryFile)
+	bridge.loadAnnounced()
+	bridge.prefix = config.Prefix
+	if bridge.prefix == "" {
+		bridge.prefix = defaultMQTTPrefix
+	}
+
+	clientID := config.ClientID
+	if clientID == "" {
+		clientID = "jarvis-" + mqttTopicEscaper.Replace(station)
+	}
+
+	options := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(clientID).
+		SetUsername(config.UserName).
+		SetPassword(config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOrderMatters(false).
+		SetWill(bridge.availabilityTopic(), mqttOffline, config.QoS, true).
+		SetOnConnectHandler(bridge.onConnect).
+		SetConnectionLostHandler(func(client mqtt.Client, err error) {
+			log.Printf("[MQTT] Lost connection to %s: %v", config.Broker, err)
+		})
+
+	log.Printf("[MQTT] Connecting to %s", config.Broker)
+	bridge.client = mqtt.NewClient(options)
+	bridge.client.Connect()
+
+	bridge.stopRequest = make(chan int)
+	bridge.stopReply = make(chan int, 1)
+	go bridge.run()
+	bridge.isRunning = true
+	return nil
+}
+
+func (bridge *mqttBridge) Stop(timeOut time.Duration) error {
+	if !bridge.isRunning {
+		return nil
+	}
+
+	log.Printf("[MQTT] Stopping bridge")
+	bridge.isRunning = false
+	deadline := time.After(timeOut)
+	select {
+	case bridge.stopRequest <- 1:
+	case <-deadline:
+		return errors.New("Stop MQTT bridge timed out")
+	}
+	select {
+	case <-bridge.stopReply:
+		return nil
+	case <-deadline:
+		return errors.New("Stop MQTT bridge timed out")
+	}
+}
+
+func (bridge *mqttBridge) run() {
+	for {
+		select {
+		case <-bridge.stopRequest:
+			if bridge.client.IsConnected() {
+				bridge.publish(bridge.availabilityTopic(), mqttOffline, true)
+			}
+			bridge.client.Disconnect(250)
+			bridge.stopReply <- 1
+			return
+
+		case result, ok := <-bridge.input:
+			if !ok {
+				bridge.input = nil
+			} else {
+				bridge.publishResult(result)
+			}
+
+		case event, ok := <-bridge.effectors:
+			if !ok {
+				bridge.effectors = nil
+			} else {
+				bridge.publishEffector(event)
+			}
+
+		case event, ok := <-bridge.types:
+			if !ok {
+				bridge.types = nil
+			} else {
+				bridge.announce(event)
+			}
+		}
+	}
+}
+
+func (bridge *mqttBridge) onConnect(client mqtt.Client) {
+	log.Printf("[MQTT] Connected to %s", bridge.config.Broker)
+	bridge.publish(bridge.availabilityTopic(), mqttOnline, true)
+
+	bridge.subscribe(bridge.stationTopic(bridge.station)+"/+/+/set", bridge.handleCommand)
+	if bridge.config.Discovery != "" {
+		// Home Assistant forgets entities that are not retained when it restarts, so announce them again
+		bridge.subscribe(bridge.config.Discovery+"/status", func(client mqtt.Client, msg mqtt.Message) {
+			if string(msg.Payload()) == homeAssistantOnline {
+				log.Printf("[MQTT] Home Assistant is online, announcing entities")
+				bridge.announceAll()
+			}
+		})
+		bridge.announceAll()
+	}
+}
+
+func (bridge *mqttBridge) subscribe(topic string, handler mqtt.MessageHandler) {
+	token := bridge.client.Subscribe(topic, bridge.config.QoS, handler)
+	if token.WaitTimeout(mqttWaitTime) && token.Error() != nil {
+		log.Printf("[MQTT] Unable to subscribe to %s: %v", topic, token.Error())
+	}
+}
+
+func (bridge *mqttBridge) stationTopic(station string) string {
+	return bridge.prefix + "/" + mqttTopicEscaper.Replace(station)
+}
+
+func (bridge *mqttBridge) sourceTopic(station, source string) string {
+	return bridge.stationTopic(station) + "/" + mqttTopicEscaper.Replace(source)
+}
+
+func (bridge *mqttBridge) availabilityTopic() string {
+	return bridge.stationTopic(bridge.station) + "/status"
+}
+
+func (bridge *mqttBridge) publish(topic, payload string, retain bool) {
+	if !bridge.client.IsConnected() {
+		return
+	}
+	token := bridge.client.Publish(topic, bridge.config.QoS, retain, payload)
+	if token.WaitTimeout(mqttWaitTime) && token.Error() != nil {
+		log.Printf("[MQTT] Unable to publish to %s: %v", topic, token.Error())
+	}
+}
+
+func (bridge *mqttBridge) publishResult(result *monitorResult) {
+	station := result.Station
+	if station == "" {
+		station = bridge.station
+	}
+	topic := bridge.sourceTopic(station, result.Source)
+	for _, value := range result.Values {
+		bridge.publish(topic+"/"+mqttTopicEscaper.Replace(value.Name), strconv.FormatFloat(float64(value.Value), 'f', -1, 32), bridge.config.Retain)
+	}
+}
+
+func (bridge *mqttBridge) publishEffector(event *effectorEvent) {
+	// Effector state is always retained so subscribers see it even if no command has been sent since they connected
+	topic := bridge.sourceTopic(bridge.station, event.Source) + "/" + mqttTopicEscaper.Replace(event.Effector)
+	bridge.publish(topic, event.Action, true)
+}
+
+func (bridge *mqttBridge) handleCommand(client mqtt.Client, msg mqtt.Message) {
+	// A retained command would be run again every time the bridge reconnects
+	if msg.Retained() {
+		log.Printf("[MQTT] Ignoring retained command on %s", msg.Topic())
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(msg.Topic(), bridge.stationTopic(bridge.station)+"/"), "/")
+	if len(parts) != 3 {
+		log.Printf("[MQTT] Ignoring command on unexpected topic %s", msg.Topic())
+		return
+	}
+
+	mon := bridge.findMonitor(parts[0])
+	if mon == nil {
+		log.Printf("[MQTT] Ignoring command for unknown source %s", parts[0])
+		return
+	}
+	effector := bridge.findEffector(mon, parts[1])
+	if effector == "" {
+		log.Printf("[MQTT] Ignoring command for unknown effector %s on %s", parts[1], mon.Name())
+		return
+	}
+
+	cmd, err := parseMQTTCommand(msg.Payload())
+	if err != nil {
+		log.Printf("[MQTT] Ignoring command on %s: %v", msg.Topic(), err)
+		return
+	}
+	cmd.Name = effector
+
+	log.Printf("[MQTT] Sending command %s %s to %s", cmd.Name, cmd.Action, mon.Name())
+	if err = bridge.audit.SendCommand(mon, cmd, auditOriginMQTT, ""); err != nil {
+		log.Printf("[MQTT] Unable to send command to %s: %v", mon.Name(), err)
+	}
+}
+
+func (bridge *mqttBridge) findMonitor(topicName string) *monitor {
+	for name, mon := range *bridge.monitors {
+		if mqttTopicEscaper.Replace(name) == topicName {
+			return mon
+		}
+	}
+	return nil
+}
+
+func (bridge *mqttBridge) findEffector(mon *monitor, topicName string) string {
+	for _, name := range mon.OutputTypes() {
+		if mqttTopicEscaper.Replace(name) == topicName {
+			return name
+		}
+	}
+	return ""
+}
+
+func parseMQTTCommand(payload []byte) (*command, error) {
+	text := strings.TrimSpace(string(payload))
+	cmd := &command{}
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), cmd); err != nil {
+			return nil, fmt.Errorf("Unable to parse command: %v", err)
+		}
+	} else {
+		cmd.Action = text
+	}
+
+	// Home Assistant sends ON and OFF by default
+	cmd.Action = strings.ToLower(cmd.Action)
+	if cmd.Action != "on" && cmd.Action != "off" {
+		return nil, fmt.Errorf("Unknown action '%s'", cmd.Action)
+	}
+	return cmd, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const mqttDiscoveryFile = "mqtt-discovery.json"
+
+type homeAssistantDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	Version      string   `json:"sw_version"`
+}
+
+type homeAssistantEntity struct {
+	Name              string              `json:"name"`
+	UniqueID          string              `json:"unique_id"`
+	StateTopic        string              `json:"state_topic"`
+	CommandTopic      string              `json:"command_topic,omitempty"`
+	AvailabilityTopic string              `json:"availability_topic"`
+	DeviceClass       string              `json:"device_class,omitempty"`
+	Unit              string              `json:"unit_of_measurement,omitempty"`
+	StateClass        string              `json:"state_class,omitempty"`
+	PayloadOn         string              `json:"payload_on,omitempty"`
+	PayloadOff        string              `json:"payload_off,omitempty"`
+	StateOn           string              `json:"state_on,omitempty"`
+	StateOff          string              `json:"state_off,omitempty"`
+	Device            homeAssistantDevice `json:"device"`
+}
+
+func (bridge *mqttBridge) loadAnnounced() {
+	bridge.announced = map[string][]string{}
+	data, err := ioutil.ReadFile(bridge.discoveryPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[MQTT] Unable to read announced entities: %v", err)
+		}
+		return
+	}
+	if err = json.Unmarshal(data, &bridge.announced); err != nil {
+		log.Printf("[MQTT] Unable to parse announced entities: %v", err)
+	}
+}
+
+func (bridge *mqttBridge) saveAnnounced() {
+	data, err := json.MarshalIndent(bridge.announced, "", "  ")
+	if err != nil {
+		log.Printf("[MQTT] Unable to generate announced entities: %v", err)
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(bridge.discoveryPath), 0700); err != nil {
+		log.Printf("[MQTT] Unable to create data path: %v", err)
+		return
+	}
+	if err = ioutil.WriteFile(bridge.discoveryPath, data, 0600); err != nil {
+		log.Printf("[MQTT] Unable to write announced entities: %v", err)
+	}
+}
+
+func (bridge *mqttBridge) announceAll() {
+	if bridge.config.Discovery == "" {
+		return
+	}
+
+	for name, mon := range *bridge.monitors {
+		bridge.announce(&monitorTypesEvent{
+			Source:  name,
+			Inputs:  mon.InputTypes(),
+			Outputs: mon.OutputTypes(),
+		})
+	}
+
+	// Sources that were announced before but are no longer configured
+	bridge.discoveryMutex.Lock()
+	defer bridge.discoveryMutex.Unlock()
+	removed := false
+	for source, topics := range bridge.announced {
+		if bridge.monitors.Get(source) != nil {
+			continue
+		}
+		log.Printf("[MQTT] Removing entities for %s", source)
+		for _, topic := range topics {
+			bridge.publish(topic, "", true)
+		}
+		delete(bridge.announced, source)
+		removed = true
+	}
+	if removed {
+		bridge.saveAnnounced()
+	}
+}
+
+func (bridge *mqttBridge) announce(event *monitorTypesEvent) {
+	if bridge.config.Discovery == "" || !bridge.client.IsConnected() {
+		return
+	}
+	if len(event.Inputs) == 0 && len(event.Outputs) == 0 {
+		// The source has not told us what it has yet
+		return
+	}
+
+	entities := bridge.discoveryEntities(event)
+	topics := make([]string, 0, len(entities))
+	for topic, entity := range entities {
+		data, err := json.Marshal(entity)
+		if err != nil {
+			log.Printf("[MQTT] Unable to generate discovery message for %s: %v", entity.UniqueID, err)
+			continue
+		}
+		bridge.publish(topic, string(data), true)
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	log.Printf("[MQTT] Announced %d entities for %s", len(topics), event.Source)
+
+	bridge.discoveryMutex.Lock()
+	defer bridge.discoveryMutex.Unlock()
+	for _, topic := range bridge.announced[event.Source] {
+		if _, ok := entities[topic]; !ok {
+			bridge.publish(topic, "", true)
+		}
+	}
+	if len(topics) > 0 {
+		bridge.announced[event.Source] = topics
+	} else {
+		delete(bridge.announced, event.Source)
+	}
+	bridge.saveAnnounced()
+}
+
+func (bridge *mqttBridge) discoveryEntities(event *monitorTypesEvent) map[string]*homeAssistantEntity {
+	node := mqttTopicEscaper.Replace(bridge.station + "_" + event.Source)
+	device := homeAssistantDevice{
+		Identifiers:  []string{"jarvis_" + node},
+		Name:         event.Source,
+		Manufacturer: "Jarvis",
+		Model:        bridge.station,
+		Version:      serverVersion,
+	}
+	sensors := map[string]sensorConfiguration{}
+	for _, source := range bridge.sources {
+		if source.Name == event.Source {
+			for _, sensor := range source.Sensors {
+				sensors[sensor.Name] = sensor
+			}
+		}
+	}
+
+	sourceTopic := bridge.sourceTopic(bridge.station, event.Source)
+	out := map[string]*homeAssistantEntity{}
+	for _, name := range event.Inputs {
+		objectID := mqttTopicEscaper.Replace(name)
+		entity := &homeAssistantEntity{
+			Name:              name,
+			UniqueID:          fmt.Sprintf("jarvis_%s_%s", node, objectID),
+			StateTopic:        sourceTopic + "/" + objectID,
+			AvailabilityTopic: bridge.availabilityTopic(),
+			StateClass:        "measurement",
+			Device:            device,
+		}
+		if sensor, ok := sensors[name]; ok {
+			if sensor.Title != "" {
+				entity.Name = sensor.Title
+			}
+			entity.DeviceClass = sensor.DeviceClass
+			entity.Unit = sensor.Unit
+		}
+		out[bridge.discoveryTopic("sensor", node, objectID)] = entity
+	}
+	for _, name := range event.Outputs {
+		objectID := mqttTopicEscaper.Replace(name)
+		entity := &homeAssistantEntity{
+			Name:              name,
+			UniqueID:          fmt.Sprintf("jarvis_%s_%s", node, objectID),
+			StateTopic:        sourceTopic + "/" + objectID,
+			CommandTopic:      sourceTopic + "/" + objectID + "/set",
+			AvailabilityTopic: bridge.availabilityTopic(),
+			PayloadOn:         "on",
+			PayloadOff:        "off",
+			StateOn:           "on",
+			StateOff:          "off",
+			Device:            device,
+		}
+		if sensor, ok := sensors[name]; ok && sensor.Title != "" {
+			entity.Name = sensor.Title
+		}
+		out[bridge.discoveryTopic("switch", node, objectID)] = entity
+	}
+	return out
+}
+
+func (bridge *mqttBridge) discoveryTopic(component, node, objectID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/config", bridge.config.Discovery, component, node, objectID)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type testMQTTToken struct {
+	mqtt.Token
+}
+
+func (token *testMQTTToken) WaitTimeout(time.Duration) bool { return true }
+func (token *testMQTTToken) Error() error                   { return nil }
+
+// testMQTTClient records retained publishes the way a broker would keep them, an empty payload clears the topic
+type testMQTTClient struct {
+	mqtt.Client
+	retained map[string]string
+}
+
+func (client *testMQTTClient) IsConnected() bool { return true }
+
+func (client *testMQTTClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	if retained && payload == "" {
+		delete(client.retained, topic)
+	} else if retained {
+		client.retained[topic] = payload.(string)
+	}
+	return &testMQTTToken{}
+}
+
+func newTestDiscoveryBridge(t *testing.T, dataPath string) (*mqttBridge, *testMQTTClient) {
+	client := &testMQTTClient{retained: map[string]string{}}
+	bridge := &mqttBridge{
+		config:        &mqttConfiguration{Discovery: "homeassistant"},
+		station:       "Hub",
+		prefix:        defaultMQTTPrefix,
+		monitors:      &monitorStore{},
+		client:        client,
+		discoveryPath: filepath.Join(dataPath, mqttDiscoveryFile),
+		sources: []monitorConfiguration{{Name: "Plant Monitor", Sensors: []sensorConfiguration{
+			{Name: "Moisture", Title: "Soil moisture", DeviceClass: "moisture", Unit: "%"},
+		}}},
+	}
+	bridge.loadAnnounced()
+	return bridge, client
+}
+
+func TestMQTTDiscoveryEntities(t *testing.T) {
+	bridge, client := newTestDiscoveryBridge(t, t.TempDir())
+	bridge.announce(&monitorTypesEvent{Source: "Plant Monitor", Inputs: []string{"Moisture", "Light"}, Outputs: []string{"Pump"}})
+
+	if len(client.retained) != 3 {
+		t.Fatalf("expected three entities, got %v", client.retained)
+	}
+	sensor := homeAssistantEntity{}
+	json.Unmarshal([]byte(client.retained["homeassistant/sensor/Hub_Plant_Monitor/Moisture/config"]), &sensor)
+	if sensor.Name != "Soil moisture" || sensor.DeviceClass != "moisture" || sensor.Unit != "%" || sensor.CommandTopic != "" {
+		t.Errorf("expected the configured sensor details, got %+v", sensor)
+	}
+	if sensor.UniqueID != "jarvis_Hub_Plant_Monitor_Moisture" || sensor.StateTopic != bridge.sourceTopic("Hub", "Plant Monitor")+"/Moisture" {
+		t.Errorf("unexpected identity or topic for the sensor: %+v", sensor)
+	}
+
+	pump := homeAssistantEntity{}
+	json.Unmarshal([]byte(client.retained["homeassistant/switch/Hub_Plant_Monitor/Pump/config"]), &pump)
+	if pump.CommandTopic != pump.StateTopic+"/set" || pump.PayloadOn != "on" || pump.Device.Name != "Plant Monitor" {
+		t.Errorf("expected a switch with a command topic, got %+v", pump)
+	}
+}
+
+func TestMQTTDiscoveryRemovesOldEntities(t *testing.T) {
+	dataPath := t.TempDir()
+	bridge, client := newTestDiscoveryBridge(t, dataPath)
+	bridge.announce(&monitorTypesEvent{Source: "Plant Monitor", Inputs: []string{"Moisture", "Light"}})
+	bridge.announce(&monitorTypesEvent{Source: "Shed", Outputs: []string{"Heater"}})
+
+	// A source that stops reporting an input has its entity cleared
+	bridge.announce(&monitorTypesEvent{Source: "Plant Monitor", Inputs: []string{"Moisture"}})
+	if _, ok := client.retained["homeassistant/sensor/Hub_Plant_Monitor/Light/config"]; ok || len(client.retained) != 2 {
+		t.Fatalf("expected the light sensor to be removed, got %v", client.retained)
+	}
+
+	// After a restart the sources that are no longer configured are cleared from the broker
+	restarted, _ := newTestDiscoveryBridge(t, dataPath)
+	restarted.client = client
+	if len(restarted.announced) != 2 {
+		t.Fatalf("expected the announced entities to be remembered, got %v", restarted.announced)
+	}
+	restarted.announceAll()
+	if len(client.retained) != 0 || len(restarted.announced) != 0 {
+		t.Fatalf("expected every entity to be removed, got %v and %v", client.retained, restarted.announced)
+	}
+}
+
+func TestMQTTDiscoveryWaitsForTypes(t *testing.T) {
+	bridge, client := newTestDiscoveryBridge(t, t.TempDir())
+	bridge.announce(&monitorTypesEvent{Source: "Plant Monitor"})
+	bridge.config.Discovery = ""
+	bridge.announce(&monitorTypesEvent{Source: "Plant Monitor", Inputs: []string{"Moisture"}})
+	if len(client.retained) != 0 {
+		t.Fatalf("expected nothing to be announced, got %v", client.retained)
+	}
+}
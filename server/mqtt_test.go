@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+type testMQTTMessage struct {
+	topic    string
+	payload  []byte
+	retained bool
+}
+
+func (msg *testMQTTMessage) Duplicate() bool   { return false }
+func (msg *testMQTTMessage) Qos() byte         { return 0 }
+func (msg *testMQTTMessage) Retained() bool    { return msg.retained }
+func (msg *testMQTTMessage) Topic() string     { return msg.topic }
+func (msg *testMQTTMessage) MessageID() uint16 { return 0 }
+func (msg *testMQTTMessage) Payload() []byte   { return msg.payload }
+func (msg *testMQTTMessage) Ack()              {}
+
+func TestParseMQTTCommand(t *testing.T) {
+	tests := []struct {
+		name         string
+		payload      string
+		wantAction   string
+		wantDuration int
+		wantErr      string
+	}{
+		{name: "plain", payload: "on", wantAction: "on"},
+		{name: "home assistant", payload: "OFF", wantAction: "off"},
+		{name: "whitespace", payload: " on\n", wantAction: "on"},
+		{name: "json", payload: `{"action":"on","duration":30}`, wantAction: "on", wantDuration: 30},
+		{name: "unknown action", payload: "toggle", wantErr: "Unknown action"},
+		{name: "empty", payload: "", wantErr: "Unknown action"},
+		{name: "invalid json", payload: `{"action":`, wantErr: "Unable to parse command"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd, err := parseMQTTCommand([]byte(test.payload))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cmd.Action != test.wantAction {
+				t.Fatalf("expected action %s, got %s", test.wantAction, cmd.Action)
+			}
+			duration := 0
+			if cmd.Duration != nil {
+				duration = *cmd.Duration
+			}
+			if duration != test.wantDuration {
+				t.Fatalf("expected duration %d, got %d", test.wantDuration, duration)
+			}
+		})
+	}
+}
+
+func TestHandleCommandIgnoresRetained(t *testing.T) {
+	// Without monitors or an audit log anything past the retained check would panic
+	bridge := &mqttBridge{station: "hub", prefix: defaultMQTTPrefix}
+	bridge.handleCommand(nil, &testMQTTMessage{
+		topic:    bridge.stationTopic("hub") + "/pump/valve/set",
+		payload:  []byte("on"),
+		retained: true,
+	})
+}
+
+func TestMQTTStartRejectsInvalidQoS(t *testing.T) {
+	bridge := &mqttBridge{}
+	config := &appConfiguration{MQTT: &mqttConfiguration{Broker: "tcp://localhost:1883", QoS: 3}}
+	err := bridge.Start(config, &monitorStore{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "Invalid MQTT QoS") {
+		t.Fatalf("expected an invalid QoS error, got %v", err)
+	}
+	if bridge.isRunning {
+		t.Fatalf("expected the bridge not to start")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+const (
+	permissionView       = "view"
+	permissionCommand    = "command"
+	permissionAdminister = "administer"
+
+	defaultAdminRole   = "admin"
+	defaultStationRole = "viewer"
+)
+
+var permissionLevels = map[string]int{
+	permissionView:       1,
+	permissionCommand:    2,
+	permissionAdminister: 3,
+}
+
+var builtInRoles = []roleConfiguration{
+	{Name: defaultAdminRole, Permissions: []permissionConfiguration{{Level: permissionAdminister}}},
+	{Name: "operator", Permissions: []permissionConfiguration{{Level: permissionCommand}}},
+	{Name: "viewer", Permissions: []permissionConfiguration{{Level: permissionView}}},
+}
+
+func (config *appConfiguration) FindRole(name string) *roleConfiguration {
+	for pos := range config.Roles {
+		if config.Roles[pos].Name == name {
+			return &config.Roles[pos]
+		}
+	}
+	for pos := range builtInRoles {
+		if builtInRoles[pos].Name == name {
+			return &builtInRoles[pos]
+		}
+	}
+	return nil
+}
+
+func (config *appConfiguration) findRoom(name string) *roomConfiguration {
+	for pos := range config.Rooms {
+		if config.Rooms[pos].Name == name {
+			return &config.Rooms[pos]
+		}
+	}
+	return nil
+}
+
+func (config *appConfiguration) HasPermission(item *user, level, station, source string) bool {
+	required := permissionLevels[level]
+	for _, roleName := range item.Roles {
+		role := config.FindRole(roleName)
+		if role == nil {
+			continue
+		}
+		for _, perm := range role.Permissions {
+			if permissionLevels[perm.Level] >= required && config.permissionCovers(&perm, station, source) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (config *appConfiguration) permissionCovers(perm *permissionConfiguration, station, source string) bool {
+	if len(perm.Rooms) == 0 && len(perm.Sources) == 0 {
+		return true
+	}
+
+	for _, name := range perm.Sources {
+		if station == "" && source != "" && name == source {
+			return true
+		}
+		if station != "" && (name == station || name == station+"/"+source) {
+			return true
+		}
+	}
+
+	for _, roomName := range perm.Rooms {
+		room := config.findRoom(roomName)
+		if room == nil {
+			continue
+		}
+		if station == "" && source != "" && containsString(room.Sources, source) {
+			return true
+		}
+		if station != "" && containsString(room.Stations, station) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Stations calling in are held to the roles configured for them, whether or not users are set up
+func (config *appConfiguration) stationCaller(name string) *user {
+	item := &user{Name: name, Roles: []string{defaultStationRole}}
+	if station := config.FindStation(name); station != nil && len(station.Roles) > 0 {
+		item.Roles = station.Roles
+	}
+	return item
+}
+
+func (api *webAPI) isPermitted(req *http.Request, level, station, source string) bool {
+	if caller := stationFromRequest(req); caller != "" {
+		return api.config.HasPermission(api.config.stationCaller(caller), level, station, source)
+	}
+	if api.users == nil {
+		return true
+	}
+
+	item := userFromRequest(req)
+	return item != nil && api.config.HasPermission(item, level, station, source)
+}
+
+func (api *webAPI) authorize(resp http.ResponseWriter, req *http.Request, level, station, source string) bool {
+	if api.isPermitted(req, level, station, source) {
+		return true
+	}
+
+	target := source
+	if station != "" {
+		target = storeKey(station, source)
+	}
+	api.forbid(resp, req, level, target)
+	return false
+}
+
+// A room can be seen by anyone who can see one of its sources or stations, unknown rooms only by those who can see everything
+func (api *webAPI) authorizeRoom(resp http.ResponseWriter, req *http.Request, level, name string, room *roomConfiguration) bool {
+	if room != nil {
+		for _, source := range room.Sources {
+			if api.isPermitted(req, level, "", source) {
+				return true
+			}
+		}
+		for _, station := range room.Stations {
+			if api.isPermitted(req, level, station, "") {
+				return true
+			}
+		}
+	}
+	if api.isPermitted(req, level, "", "") {
+		return true
+	}
+
+	api.forbid(resp, req, level, "room "+name)
+	return false
+}
+
+func (api *webAPI) forbid(resp http.ResponseWriter, req *http.Request, level, target string) {
+	caller := "User " + userNameFromRequest(req)
+	if name := stationFromRequest(req); name != "" {
+		caller = "Station " + name
+	}
+	log.Printf("[Security] %s does not have %s permission for '%s'", caller, level, target)
+	api.writeStatusJSON(resp, http.StatusForbidden, "Forbidden", fmt.Sprintf("You do not have %s permission", level))
+}
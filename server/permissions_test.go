@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func testPermissionConfiguration() *appConfiguration {
+	return &appConfiguration{
+		Rooms: []roomConfiguration{
+			{Name: "Kitchen", Sources: []string{"Herbs"}},
+			{Name: "Garden", Sources: []string{"Pump"}, Stations: []string{"Greenhouse"}},
+		},
+		Roles: []roleConfiguration{
+			{Name: "kids", Permissions: []permissionConfiguration{{Level: permissionView, Rooms: []string{"Kitchen"}}}},
+			{Name: "gardener", Permissions: []permissionConfiguration{
+				{Level: permissionCommand, Rooms: []string{"Garden", "Attic"}},
+				{Level: permissionView, Sources: []string{"Herbs", "Shed/Lights"}},
+			}},
+		},
+	}
+}
+
+func TestPermissionCovers(t *testing.T) {
+	config := testPermissionConfiguration()
+	perm := func(rooms, sources []string) *permissionConfiguration {
+		return &permissionConfiguration{Level: permissionView, Rooms: rooms, Sources: sources}
+	}
+
+	// Each permission is checked against the same set of local and station targets
+	targets := [][2]string{{"", "Herbs"}, {"", "Pump"}, {"Greenhouse", "Tomatoes"}, {"Shed", "Lights"}, {"Shed", "Heater"}, {"", ""}}
+	tests := []struct {
+		name    string
+		perm    *permissionConfiguration
+		covered []bool
+	}{
+		{"unrestricted", perm(nil, nil), []bool{true, true, true, true, true, true}},
+		{"kitchen", perm([]string{"Kitchen"}, nil), []bool{true, false, false, false, false, false}},
+		{"garden", perm([]string{"Garden"}, nil), []bool{false, true, true, false, false, false}},
+		{"unknown room", perm([]string{"Attic"}, nil), []bool{false, false, false, false, false, false}},
+		{"local source", perm(nil, []string{"Pump"}), []bool{false, true, false, false, false, false}},
+		{"whole station", perm(nil, []string{"Shed"}), []bool{false, false, false, true, true, false}},
+		{"station source", perm(nil, []string{"Shed/Lights"}), []bool{false, false, false, true, false, false}},
+	}
+
+	for _, test := range tests {
+		for pos, target := range targets {
+			if got := config.permissionCovers(test.perm, target[0], target[1]); got != test.covered[pos] {
+				t.Errorf("%s: expected %t for %q, got %t", test.name, test.covered[pos], target, got)
+			}
+		}
+	}
+}
+
+func TestHasPermissionLevels(t *testing.T) {
+	config := testPermissionConfiguration()
+	kid := &user{Name: "sam", Roles: []string{"kids"}}
+	gardener := &user{Name: "alex", Roles: []string{"gardener", "missing"}}
+	admin := &user{Name: "admin", Roles: []string{defaultAdminRole}}
+
+	if !config.HasPermission(kid, permissionView, "", "Herbs") || config.HasPermission(kid, permissionCommand, "", "Herbs") {
+		t.Error("expected kids to view but not water the herbs")
+	}
+	if config.HasPermission(kid, permissionView, "", "Pump") {
+		t.Error("expected kids not to see the garden pump")
+	}
+	if !config.HasPermission(gardener, permissionCommand, "Greenhouse", "Vent") || !config.HasPermission(gardener, permissionView, "", "Pump") {
+		t.Error("expected the gardener to run and view everything in the garden")
+	}
+	if config.HasPermission(gardener, permissionCommand, "", "Herbs") || config.HasPermission(gardener, permissionAdminister, "", "Pump") {
+		t.Error("expected the gardener to only view the herbs and not administer")
+	}
+	if !config.HasPermission(admin, permissionAdminister, "", "") {
+		t.Error("expected the built in admin role to administer")
+	}
+}
+
+func TestForbiddenBeforeUnknown(t *testing.T) {
+	config := testPermissionConfiguration()
+	config.stations = map[string]stationConfiguration{}
+	api := &webAPI{config: config, users: &userStore{}}
+	send := func(handler http.HandlerFunc, item *user, vars map[string]string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req = mux.SetURLVars(req.WithContext(context.WithValue(req.Context(), userContextKey{}, item)), vars)
+		resp := httptest.NewRecorder()
+		handler(resp, req)
+		return resp.Code
+	}
+	kid := &user{Name: "sam", Roles: []string{"kids"}}
+	admin := &user{Name: "admin", Roles: []string{defaultAdminRole}}
+
+	// Someone who may not see a station cannot tell whether it exists
+	if code := send(api.getStationDetails, kid, map[string]string{"station": "Nowhere"}); code != http.StatusForbidden {
+		t.Errorf("expected a restricted user to be refused an unknown station, got %d", code)
+	}
+	if code := send(api.getStationDetails, admin, map[string]string{"station": "Nowhere"}); code != http.StatusNotFound {
+		t.Errorf("expected the admin to be told the station is unknown, got %d", code)
+	}
+	if code := send(api.processStationSourceCommand, kid, map[string]string{"station": "Nowhere", "source": "Pump"}); code != http.StatusForbidden {
+		t.Errorf("expected a restricted user to be refused a command, got %d", code)
+	}
+
+	if code := send(api.getRoomDetails, kid, map[string]string{"room": "Kitchen"}); code != http.StatusOK {
+		t.Errorf("expected kids to see the kitchen, got %d", code)
+	}
+	if code := send(api.getRoomDetails, kid, map[string]string{"room": "Garden"}); code != http.StatusForbidden {
+		t.Errorf("expected kids to be refused the garden, got %d", code)
+	}
+	if code := send(api.getRoomDetails, kid, map[string]string{"room": "Attic"}); code != http.StatusForbidden {
+		t.Errorf("expected kids to be refused an unknown room, got %d", code)
+	}
+	if code := send(api.getRoomDetails, admin, map[string]string{"room": "Attic"}); code != http.StatusNotFound {
+		t.Errorf("expected the admin to be told the room is unknown, got %d", code)
+	}
+}
+
+func TestReplicationProgressIsFiltered(t *testing.T) {
+	api := &webAPI{config: testPermissionConfiguration(), users: &userStore{}, replicas: &stationReplicator{
+		stations: []stationConfiguration{{Name: "Greenhouse"}, {Name: "Shed"}},
+		progress: map[string]*replicationProgress{"Greenhouse": {Station: "Greenhouse"}, "Shed": {Station: "Shed"}},
+	}}
+	req := httptest.NewRequest("GET", "/api/stations/replication", nil)
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey{}, &user{Name: "alex", Roles: []string{"gardener"}}))
+	resp := httptest.NewRecorder()
+	api.getReplicationProgress(resp, req)
+
+	if body := resp.Body.String(); resp.Code != http.StatusOK || !strings.Contains(body, `"Greenhouse"`) || strings.Contains(body, `"Shed"`) {
+		t.Fatalf("expected only the greenhouse progress, got %d %s", resp.Code, body)
+	}
+}
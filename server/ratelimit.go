@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const rateLimitCleanupPeriod = 5 * time.Minute
+
+var defaultRateLimits = []rateLimitConfiguration{
+	{Name: "speech", Paths: []string{"/api/speech"}, Rate: 10, Burst: 5},
+	{Name: "commands", Paths: []string{"/api/sources/*/effectors", "/api/stations/*/sources/*/effectors"}, Methods: []string{"POST"}, Rate: 30, Burst: 10},
+	{Name: "login", Paths: []string{"/api/login"}, Methods: []string{"POST"}, Rate: 10, Burst: 5},
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type rateLimitGroup struct {
+	config  rateLimitConfiguration
+	perSec  float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+type rateLimiter struct {
+	groups      []*rateLimitGroup
+	lastCleanup time.Time
+	mutex       sync.Mutex
+}
+
+func newRateLimiter(config []rateLimitConfiguration) *rateLimiter {
+	if config == nil {
+		config = defaultRateLimits
+	}
+
+	limiter := &rateLimiter{lastCleanup: time.Now()}
+	for _, item := range config {
+		if item.Rate <= 0 {
+			continue
+		}
+		burst := item.Burst
+		if burst <= 0 {
+			burst = 1
+		}
+		limiter.groups = append(limiter.groups, &rateLimitGroup{
+			config:  item,
+			perSec:  item.Rate / 60,
+			burst:   float64(burst),
+			buckets: map[string]*tokenBucket{},
+		})
+	}
+	return limiter
+}
+
+func (limiter *rateLimiter) findGroup(req *http.Request) *rateLimitGroup {
+	for _, group := range limiter.groups {
+		if len(group.config.Methods) > 0 && !containsString(group.config.Methods, req.Method) {
+			continue
+		}
+		for _, pattern := range group.config.Paths {
+			if matched, _ := path.Match(pattern, req.URL.Path); matched {
+				return group
+			}
+		}
+	}
+	return nil
+}
+
+func (limiter *rateLimiter) Allow(group *rateLimitGroup, client string, now time.Time) (bool, time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if now.Sub(limiter.lastCleanup) > rateLimitCleanupPeriod {
+		limiter.cleanup(now)
+	}
+
+	bucket, ok := group.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: group.burst, updated: now}
+		group.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(group.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*group.perSec)
+	bucket.updated = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / group.perSec * float64(time.Second))
+	return false, wait
+}
+
+func (limiter *rateLimiter) cleanup(now time.Time) {
+	// Buckets that would have refilled completely are no different from new ones
+	for _, group := range limiter.groups {
+		refill := time.Duration(group.burst / group.perSec * float64(time.Second))
+		for client, bucket := range group.buckets {
+			if now.Sub(bucket.updated) > refill {
+				delete(group.buckets, client)
+			}
+		}
+	}
+	limiter.lastCleanup = now
+}
+
+func (api *webAPI) rateLimitClient(req *http.Request) string {
+	if station := stationFromRequest(req); station != "" {
+		return "station:" + station
+	}
+	// Only credentials that are accepted get their own bucket, otherwise every made up token would start a fresh one
+	if api.users != nil && !publicAPIPaths[req.URL.Path] {
+		if item, _ := api.findRequestUser(req); item != nil {
+			return "user:" + item.Name
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func (api *webAPI) rateLimitMiddleware(limiter *rateLimiter) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			group := limiter.findGroup(req)
+			if group == nil {
+				handler.ServeHTTP(resp, req)
+				return
+			}
+
+			client := api.rateLimitClient(req)
+			if allowed, wait := limiter.Allow(group, client, time.Now()); !allowed {
+				seconds := int(math.Ceil(wait.Seconds()))
+				log.Printf("[Security] Rate limiting %s requests from %s", group.config.Name, client)
+				resp.Header().Set("Retry-After", strconv.Itoa(seconds))
+				api.writeStatusJSON(resp, http.StatusTooManyRequests, "Failure", fmt.Sprintf("Too many requests, try again in %d seconds", seconds))
+				return
+			}
+			handler.ServeHTTP(resp, req)
+		})
+	}
+}
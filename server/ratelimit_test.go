@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		offsets  []time.Duration
+		want     []bool
+		wantWait time.Duration
+	}{
+		{
+			name:    "burst is allowed",
+			offsets: []time.Duration{0, 0, 0},
+			want:    []bool{true, true, true},
+		},
+		{
+			name:     "limited once the burst is used",
+			offsets:  []time.Duration{0, 0, 0, 0},
+			want:     []bool{true, true, true, false},
+			wantWait: 2 * time.Second,
+		},
+		{
+			name:    "tokens refill over time",
+			offsets: []time.Duration{0, 0, 0, 2 * time.Second},
+			want:    []bool{true, true, true, true},
+		},
+		{
+			name:     "refill never exceeds the burst",
+			offsets:  []time.Duration{0, 0, 0, time.Hour, time.Hour, time.Hour, time.Hour},
+			want:     []bool{true, true, true, true, true, true, false},
+			wantWait: 2 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := newRateLimiter([]rateLimitConfiguration{{Name: "speech", Paths: []string{"/api/speech"}, Rate: 30, Burst: 3}})
+			group := limiter.groups[0]
+			var wait time.Duration
+			for pos, offset := range test.offsets {
+				var allowed bool
+				allowed, wait = limiter.Allow(group, "ip:10.0.0.1", start.Add(offset))
+				if allowed != test.want[pos] {
+					t.Fatalf("request %d: expected allowed %t, got %t", pos, test.want[pos], allowed)
+				}
+			}
+			if wait != test.wantWait {
+				t.Fatalf("expected wait %v, got %v", test.wantWait, wait)
+			}
+		})
+	}
+}
+
+func TestRateLimiterSeparatesClients(t *testing.T) {
+	limiter := newRateLimiter([]rateLimitConfiguration{{Name: "login", Paths: []string{"/api/login"}, Rate: 1, Burst: 1}})
+	group := limiter.groups[0]
+	now := time.Now()
+	if allowed, _ := limiter.Allow(group, "ip:10.0.0.1", now); !allowed {
+		t.Fatalf("expected the first request to be allowed")
+	}
+	if allowed, _ := limiter.Allow(group, "ip:10.0.0.1", now); allowed {
+		t.Fatalf("expected the second request to be limited")
+	}
+	if allowed, _ := limiter.Allow(group, "ip:10.0.0.2", now); !allowed {
+		t.Fatalf("expected another client to have its own bucket")
+	}
+}
+
+func TestRateLimitClient(t *testing.T) {
+	users, err := loadUserStore(t.TempDir(), &authenticationConfiguration{})
+	if err != nil {
+		t.Fatalf("unable to create user store: %v", err)
+	}
+	secret, _, err := users.CreateToken(defaultAdminName, "robot")
+	if err != nil {
+		t.Fatalf("unable to create token: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		want  string
+	}{
+		{name: "anonymous", path: "/api/speech", want: "ip:10.0.0.1"},
+		{name: "valid token", path: "/api/speech", token: secret, want: "user:" + defaultAdminName},
+		{name: "unknown token", path: "/api/speech", token: "made-up", want: "ip:10.0.0.1"},
+		{name: "public path", path: "/api/login", token: secret, want: "ip:10.0.0.1"},
+	}
+
+	api := &webAPI{users: users}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", test.path, nil)
+			req.RemoteAddr = "10.0.0.1:5000"
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			if got := api.rateLimitClient(req); got != test.want {
+				t.Fatalf("expected %s, got %s", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReplicationPeriod = 60
+	defaultReplicationCount  = storeSize
+)
+
+type replicationMarker struct {
+	time    time.Time
+	counter int64
+}
+
+type replicationProgress struct {
+	Station     string `json:"station"`
+	LastRun     string `json:"lastRun,omitempty"`
+	LastReading string `json:"lastReading,omitempty"`
+	Lag         int64  `json:"lag"`
+	Replicated  int64  `json:"replicated"`
+	LastError   string `json:"error,omitempty"`
+
+	lastReading time.Time
+	sources     map[string]replicationMarker
+}
+
+type stationReplicator struct {
+	stations    []stationConfiguration
+	client      *stationClient
+	output      monitorListener
+	count       int
+	progress    map[string]*replicationProgress
+	mutex       sync.Mutex
+	isRunning   bool
+	stopRequest chan int
+	stopReply   chan int
+}
+
+func (rep *stationReplicator) Start(config *replicationConfiguration, stations []stationConfiguration, client *stationClient, output monitorListener) error {
+	if rep.isRunning {
+		return nil
+	}
+
+	log.Printf("[Replication] Starting replication")
+	period, count := config.Period, config.Count
+	if period <= 0 {
+		period = defaultReplicationPeriod
+	}
+	if count <= 0 {
+		count = defaultReplicationCount
+	}
+
+	rep.mutex.Lock()
+	rep.stations = []stationConfiguration{}
+	rep.progress = map[string]*replicationProgress{}
+	for _, station := range stations {
+		if station.IsDisabled {
+			continue
+		}
+		rep.stations = append(rep.stations, station)
+		rep.progress[station.Name] = &replicationProgress{
+			Station: station.Name,
+			sources: map[string]replicationMarker{},
+		}
+	}
+	rep.mutex.Unlock()
+
+	rep.client = client
+	rep.output = output
+	rep.count = count
+	rep.stopRequest = make(chan int)
+	rep.stopReply = make(chan int, 1)
+	go rep.run(time.Duration(period) * time.Second)
+	rep.isRunning = true
+	return nil
+}
+
+func (rep *stationReplicator) AddStation(station stationConfiguration) {
+	rep.mutex.Lock()
+	defer rep.mutex.Unlock()
+	if rep.progress == nil || rep.progress[station.Name] != nil {
+		return
+	}
+	log.Printf("[Replication] Adding station %s", station.Name)
+	rep.stations = append(rep.stations, station)
+	rep.progress[station.Name] = &replicationProgress{
+		Station: station.Name,
+		sources: map[string]replicationMarker{},
+	}
+}
+
+func (rep *stationReplicator) Stop(timeOut time.Duration) error {
+	if !rep.isRunning {
+		return nil
+	}
+
+	log.Printf("[Replication] Stopping replication")
+	rep.isRunning = false
+	deadline := time.After(timeOut)
+	select {
+	case rep.stopRequest <- 1:
+	case <-deadline:
+		return errors.New("Stop replication timed out")
+	}
+	select {
+	case <-rep.stopReply:
+		return nil
+	case <-deadline:
+		return errors.New("Stop replication timed out")
+	}
+}
+
+func (rep *stationReplicator) Progress() []replicationProgress {
+	rep.mutex.Lock()
+	defer rep.mutex.Unlock()
+	out := make([]replicationProgress, 0, len(rep.stations))
+	for _, station := range rep.stations {
+		progress := *rep.progress[station.Name]
+		if !progress.lastReading.IsZero() {
+			progress.Lag = int64(time.Since(progress.lastReading).Seconds())
+		}
+		out = append(out, progress)
+	}
+	return out
+}
+
+func (rep *stationReplicator) run(period time.Duration) {
+	rep.replicateAll()
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rep.stopRequest:
+			rep.stopReply <- 1
+			return
+
+		case <-ticker.C:
+			rep.replicateAll()
+		}
+	}
+}
+
+func (rep *stationReplicator) replicateAll() {
+	rep.mutex.Lock()
+	stations := rep.stations
+	rep.mutex.Unlock()
+
+	for _, station := range stations {
+		err := rep.replicate(station)
+
+		rep.mutex.Lock()
+		progress := rep.progress[station.Name]
+		progress.LastRun = time.Now().Format(time.RFC3339)
+		if err != nil {
+			log.Printf("[Replication] Unable to replicate %s: %v", station.Name, err)
+			progress.LastError = err.Error()
+		} else {
+			progress.LastError = ""
+		}
+		rep.mutex.Unlock()
+	}
+}
+
+func (rep *stationReplicator) replicate(station stationConfiguration) error {
+	data, err := rep.client.Fetch(&station, "/api/stations/local")
+	if err != nil {
+		return err
+	}
+
+	details := struct {
+		Sources []sourceDetails `json:"sources"`
+	}{}
+	if err = json.Unmarshal(data, &details); err != nil {
+		return fmt.Errorf("Unable to decode station information: %v", err)
+	}
+
+	for _, source := range details.Sources {
+		if err = rep.replicateSource(station, source.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rep *stationReplicator) replicateSource(station stationConfiguration, source string) error {
+	rep.mutex.Lock()
+	progress := rep.progress[station.Name]
+	marker := progress.sources[source]
+	rep.mutex.Unlock()
+
+	query := url.Values{}
+	query.Set("count", strconv.Itoa(rep.count))
+	if !marker.time.IsZero() {
+		query.Set("since", marker.time.Format(time.RFC3339))
+	}
+	data, err := rep.client.Fetch(&station, "/api/sources/"+url.PathEscape(source)+"/values?"+query.Encode())
+	if err != nil {
+		return err
+	}
+
+	values := struct {
+		Items []monitorResult `json:"items"`
+	}{}
+	if err = json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("Unable to decode values for %s: %v", source, err)
+	}
+
+	added := int64(0)
+	for pos := range values.Items {
+		result := values.Items[pos]
+		timeStamp, err := time.Parse(time.RFC3339, result.TimeStamp)
+		if err != nil {
+			continue
+		}
+
+		// Counters restart when the remote station restarts, so they are only
+		// compared between results sharing the same time stamp
+		if timeStamp.Before(marker.time) || (timeStamp.Equal(marker.time) && result.Counter <= marker.counter) {
+			continue
+		}
+
+		result.Station = station.Name
+		result.Source = source
+		rep.output <- &result
+		marker = replicationMarker{time: timeStamp, counter: result.Counter}
+		added++
+	}
+
+	rep.mutex.Lock()
+	defer rep.mutex.Unlock()
+	progress.sources[source] = marker
+	progress.Replicated += added
+	if marker.time.After(progress.lastReading) {
+		progress.lastReading = marker.time
+		progress.LastReading = marker.time.Format(time.RFC3339)
+	}
+	if added > 0 {
+		log.Printf("[Replication] Replicated %d results for %s from %s", added, source, station.Name)
+	}
+	return nil
+}
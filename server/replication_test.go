@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReplicateSource(t *testing.T) {
+	var mutex sync.Mutex
+	var sinces []string
+	items := []monitorResult{
+		{Source: "Plant", TimeStamp: "2026-10-18T09:00:00Z", Counter: 1},
+		{Source: "Plant", TimeStamp: "2026-10-18T09:01:00Z", Counter: 2},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch req.URL.Path {
+		case "/api/stations/local":
+			resp.Write([]byte(`{"sources":[{"name":"Plant"}]}`))
+		case "/api/sources/Plant/values":
+			since := req.URL.Query().Get("since")
+			sinces = append(sinces, since)
+			json.NewEncoder(resp).Encode(map[string]interface{}{"items": filterResultsSince(&items, since)})
+		default:
+			http.NotFound(resp, req)
+		}
+	}))
+	defer server.Close()
+
+	station := stationConfiguration{Name: "Balcony", Address: strings.TrimPrefix(server.URL, "http://")}
+	output := make(chan *monitorResult, 10)
+	rep := &stationReplicator{
+		stations: []stationConfiguration{station},
+		client:   newStationClient(&appConfiguration{Name: "Hub"}),
+		output:   output,
+		count:    10,
+		progress: map[string]*replicationProgress{station.Name: {Station: station.Name, sources: map[string]replicationMarker{}}},
+	}
+
+	rep.replicateAll()
+	if len(output) != 2 {
+		t.Fatalf("expected both results on the first run, got %d", len(output))
+	}
+	if result := <-output; result.Station != "Balcony" || result.Counter != 1 {
+		t.Fatalf("expected the first result tagged with the station, got %+v", result)
+	}
+	<-output
+
+	// A reading in the same second is told apart by its counter, and a restarted counter by its time
+	mutex.Lock()
+	items = append(items,
+		monitorResult{Source: "Plant", TimeStamp: "2026-10-18T09:01:00Z", Counter: 3},
+		monitorResult{Source: "Plant", TimeStamp: "2026-10-18T09:02:00Z", Counter: 1})
+	mutex.Unlock()
+	rep.replicateAll()
+	if len(output) != 2 {
+		t.Fatalf("expected only the two new results, got %d", len(output))
+	}
+
+	rep.replicateAll()
+	if len(output) != 2 {
+		t.Fatalf("expected nothing new on the third run, got %d", len(output))
+	}
+
+	if sinces[0] != "" || sinces[1] != "2026-10-18T09:01:00Z" || sinces[2] != "2026-10-18T09:02:00Z" {
+		t.Fatalf("expected each run to ask from the last replicated time, got %q", sinces)
+	}
+	progress := rep.Progress()
+	if len(progress) != 1 || progress[0].Replicated != 4 || progress[0].LastReading != "2026-10-18T09:02:00Z" || progress[0].LastError != "" {
+		t.Fatalf("expected four results up to 09:02, got %+v", progress)
+	}
+	if progress[0].Lag <= 0 {
+		t.Fatalf("expected the lag behind the last reading, got %d", progress[0].Lag)
+	}
+
+	server.Close()
+	rep.replicateAll()
+	if progress = rep.Progress(); progress[0].LastError == "" {
+		t.Fatal("expected the error from an unreachable station")
+	}
+}
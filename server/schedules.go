@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	scheduleActionSkip     = "skip"
+	scheduleActionPostpone = "postpone"
+
+	scheduleResultRan       = "Ran"
+	scheduleResultSkipped   = "Skipped"
+	scheduleResultPostponed = "Postponed"
+	scheduleResultFailed    = "Failed"
+
+	scheduleCheckPeriod     = 30 * time.Second
+	defaultSchedulePostpone = 60
+)
+
+type scheduleDecision struct {
+	Time        string `json:"time"`
+	Result      string `json:"result"`
+	Explanation string `json:"explanation,omitempty"`
+}
+
+type scheduleStatus struct {
+	Name     string            `json:"name"`
+	Source   string            `json:"source"`
+	Effector string            `json:"effector"`
+	Action   string            `json:"action"`
+	Time     string            `json:"time"`
+	Next     string            `json:"next,omitempty"`
+	Last     *scheduleDecision `json:"last,omitempty"`
+}
+
+type scheduleState struct {
+	config scheduleConfiguration
+	hour   int
+	minute int
+	days   map[time.Weekday]bool
+	due    time.Time
+	next   time.Time
+	last   *scheduleDecision
+}
+
+// The scheduler is only as much as the weather rules need to act on: one command at a fixed time of day on
+// chosen weekdays. Anything more, such as intervals, sunrise offsets or sequences, belongs in its own change.
+type scheduler struct {
+	schedules   []*scheduleState
+	monitors    *monitorStore
+	weather     *weatherService
+	audit       *auditLog
+	mutex       sync.Mutex
+	isRunning   bool
+	stopRequest chan int
+	stopReply   chan int
+}
+
+func parseScheduleDays(days []string) (map[time.Weekday]bool, error) {
+	out := map[time.Weekday]bool{}
+	for _, day := range days {
+		found := false
+		for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+			if strings.EqualFold(day, weekday.String()) || strings.EqualFold(day, weekday.String()[:3]) {
+				out[weekday] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown day '%s'", day)
+		}
+	}
+	return out, nil
+}
+
+func newScheduleState(config scheduleConfiguration) (*scheduleState, error) {
+	at, err := time.Parse("15:04", config.Time)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse time for schedule %s: %v", config.Name, err)
+	}
+	days, err := parseScheduleDays(config.Days)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse days for schedule %s: %v", config.Name, err)
+	}
+	for _, rule := range config.Weather {
+		if rule.Action != "" && rule.Action != scheduleActionSkip && rule.Action != scheduleActionPostpone {
+			return nil, fmt.Errorf("Unknown weather action '%s' for schedule %s", rule.Action, config.Name)
+		}
+	}
+	return &scheduleState{
+		config: config,
+		hour:   at.Hour(),
+		minute: at.Minute(),
+		days:   days,
+	}, nil
+}
+
+func (state *scheduleState) nextRun(after time.Time) time.Time {
+	year, month, day := after.Date()
+	for offset := 0; offset <= 7; offset++ {
+		candidate := time.Date(year, month, day+offset, state.hour, state.minute, 0, 0, after.Location())
+		if candidate.After(after) && (len(state.days) == 0 || state.days[candidate.Weekday()]) {
+			return candidate
+		}
+	}
+	return time.Time{}
+}
+
+func (service *scheduler) Start(config []scheduleConfiguration, monitors *monitorStore, weather *weatherService, audit *auditLog) error {
+	if service.isRunning {
+		return nil
+	}
+
+	now := time.Now()
+	schedules := []*scheduleState{}
+	for _, item := range config {
+		if item.IsDisabled {
+			log.Printf("[Schedule] Skipping schedule %s - disabled", item.Name)
+			continue
+		}
+		state, err := newScheduleState(item)
+		if err != nil {
+			return err
+		}
+		state.due = state.nextRun(now)
+		state.next = state.due
+		schedules = append(schedules, state)
+	}
+
+	log.Printf("[Schedule] Starting %d schedules", len(schedules))
+	service.mutex.Lock()
+	service.schedules = schedules
+	service.mutex.Unlock()
+	service.monitors = monitors
+	service.weather = weather
+	service.audit = audit
+	service.stopRequest = make(chan int)
+	service.stopReply = make(chan int, 1)
+	go service.run()
+	service.isRunning = true
+	return nil
+}
+
+func (service *scheduler) Stop(timeOut time.Duration) error {
+	if !service.isRunning {
+		return nil
+	}
+
+	log.Printf("[Schedule] Stopping schedules")
+	service.isRunning = false
+	deadline := time.After(timeOut)
+	select {
+	case service.stopRequest <- 1:
+	case <-deadline:
+		return errors.New("Stop schedules timed out")
+	}
+	select {
+	case <-service.stopReply:
+		return nil
+	case <-deadline:
+		return errors.New("Stop schedules timed out")
+	}
+}
+
+func (service *scheduler) run() {
+	ticker := time.NewTicker(scheduleCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-service.stopRequest:
+			service.stopReply <- 1
+			return
+
+		case now := <-ticker.C:
+			service.mutex.Lock()
+			schedules := service.schedules
+			service.mutex.Unlock()
+			for _, state := range schedules {
+				if !state.next.IsZero() && !state.next.After(now) {
+					service.execute(state, now)
+				}
+			}
+		}
+	}
+}
+
+func (service *scheduler) execute(state *scheduleState, now time.Time) {
+	config := &state.config
+	result, explanation := service.checkWeather(state, now)
+	if result == "" {
+		result = scheduleResultRan
+		mon := service.monitors.Get(config.Source)
+		if mon == nil {
+			result, explanation = scheduleResultFailed, fmt.Sprintf("Unknown source %s", config.Source)
+		} else {
+			cmd := &command{Name: config.Effector, Action: config.Action, Duration: config.Duration}
+			if err := service.audit.SendCommand(mon, cmd, auditOriginSchedule, ""); err != nil {
+				result, explanation = scheduleResultFailed, err.Error()
+			}
+		}
+	} else {
+		service.audit.Record(auditEntry{
+			Type:     auditTypeSchedule,
+			Origin:   auditOriginSchedule,
+			Source:   config.Source,
+			Effector: config.Effector,
+			Action:   config.Action,
+			Duration: config.Duration,
+			Result:   result,
+			Message:  fmt.Sprintf("%s: %s", config.Name, explanation),
+		})
+	}
+	log.Printf("[Schedule] %s: %s %s", config.Name, result, explanation)
+
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	state.last = &scheduleDecision{
+		Time:        now.Format(time.RFC3339),
+		Result:      result,
+		Explanation: explanation,
+	}
+	if result != scheduleResultPostponed {
+		state.due = state.nextRun(now)
+		state.next = state.due
+	}
+}
+
+func (service *scheduler) checkWeather(state *scheduleState, now time.Time) (string, string) {
+	if len(state.config.Weather) == 0 {
+		return "", ""
+	}
+	// Without a forecast the schedule runs as it would without any weather rules
+	forecast := service.weather.GetWeatherForecast()
+	if forecast == nil {
+		return "", "No weather forecast is available"
+	}
+
+	for _, rule := range state.config.Weather {
+		matched, explanation, err := rule.Evaluate(forecast, now)
+		if err != nil {
+			log.Printf("[Schedule] Unable to check %s weather for %s: %v", rule.Type, state.config.Name, err)
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		if rule.Action != scheduleActionPostpone {
+			return scheduleResultSkipped, explanation
+		}
+		postpone := rule.Postpone
+		if postpone <= 0 {
+			postpone = defaultSchedulePostpone
+		}
+		// A postponed run stays on the day it was due, carrying it over could run it twice the next day
+		next := now.Add(time.Duration(postpone) * time.Minute)
+		if next.YearDay() != state.due.YearDay() || next.Year() != state.due.Year() {
+			return scheduleResultSkipped, explanation + ", and it cannot be postponed past the end of the day"
+		}
+		service.mutex.Lock()
+		state.next = next
+		service.mutex.Unlock()
+		return scheduleResultPostponed, fmt.Sprintf("%s, postponed until %s", explanation, next.Format("15:04"))
+	}
+	return "", ""
+}
+
+func (service *scheduler) Status() []scheduleStatus {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	out := make([]scheduleStatus, 0, len(service.schedules))
+	for _, state := range service.schedules {
+		status := scheduleStatus{
+			Name:     state.config.Name,
+			Source:   state.config.Source,
+			Effector: state.config.Effector,
+			Action:   state.config.Action,
+			Time:     state.config.Time,
+		}
+		if !state.next.IsZero() {
+			status.Next = state.next.Format(time.RFC3339)
+		}
+		if state.last != nil {
+			last := *state.last
+			status.Last = &last
+		}
+		out = append(out, status)
+	}
+	return out
+}
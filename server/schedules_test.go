@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleNextRun(t *testing.T) {
+	state, err := newScheduleState(scheduleConfiguration{Name: "Balcony", Time: "06:30", Days: []string{"Mon", "thursday"}})
+	if err != nil {
+		t.Fatalf("unable to create schedule: %v", err)
+	}
+
+	monday := time.Date(2026, 10, 19, 6, 0, 0, 0, time.Local)
+	for after, want := range map[time.Time]time.Time{
+		monday:                       time.Date(2026, 10, 19, 6, 30, 0, 0, time.Local),
+		monday.Add(30 * time.Minute): time.Date(2026, 10, 22, 6, 30, 0, 0, time.Local),
+		monday.AddDate(0, 0, 4):      time.Date(2026, 10, 26, 6, 30, 0, 0, time.Local),
+	} {
+		if next := state.nextRun(after); !next.Equal(want) {
+			t.Errorf("after %v expected %v, got %v", after, want, next)
+		}
+	}
+
+	daily, _ := newScheduleState(scheduleConfiguration{Time: "23:59"})
+	if next := daily.nextRun(monday); next.Day() != 19 || next.Hour() != 23 {
+		t.Errorf("expected a schedule without days to run every day, got %v", next)
+	}
+}
+
+func TestScheduleConfigurationErrors(t *testing.T) {
+	for _, config := range []scheduleConfiguration{
+		{Name: "no time"},
+		{Name: "bad time", Time: "25:00"},
+		{Name: "bad day", Time: "06:30", Days: []string{"Someday"}},
+		{Name: "bad action", Time: "06:30", Weather: []weatherRuleConfiguration{{Action: "cancel"}}},
+	} {
+		if _, err := newScheduleState(config); err == nil || !strings.Contains(err.Error(), config.Name) {
+			t.Errorf("%s: expected an error naming the schedule, got %v", config.Name, err)
+		}
+	}
+}
+
+func TestSchedulePostponesWithinTheDay(t *testing.T) {
+	audit, err := openAuditLog(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to open audit log: %v", err)
+	}
+	defer audit.Close()
+
+	due := time.Date(2026, 10, 19, 22, 0, 0, 0, time.Local)
+	rain := &WeatherForecast{Items: []WeatherConditions{{Time: due.Add(-time.Hour), Hours: 24, Precipitation: 4, PrecipitationChance: 0.9}}}
+	state, _ := newScheduleState(scheduleConfiguration{
+		Name: "Balcony", Source: "Pump", Effector: "valve", Action: "on", Time: "22:00",
+		Weather: []weatherRuleConfiguration{{
+			weatherConditionConfiguration: weatherConditionConfiguration{Type: weatherConditionRain, Hours: 3},
+			Action:                        scheduleActionPostpone,
+			Postpone:                      90,
+		}},
+	})
+	state.due, state.next = due, due
+	service := &scheduler{schedules: []*scheduleState{state}, monitors: &monitorStore{}, weather: &weatherService{forecast: rain}, audit: audit}
+
+	service.execute(state, due)
+	status := service.Status()[0]
+	if status.Last.Result != scheduleResultPostponed || status.Next != due.Add(90*time.Minute).Format(time.RFC3339) {
+		t.Fatalf("expected the run to move to 23:30, got %+v %+v", status, status.Last)
+	}
+
+	// Another 90 minutes would be tomorrow, which has its own run
+	service.execute(state, state.next)
+	status = service.Status()[0]
+	if status.Last.Result != scheduleResultSkipped || !strings.Contains(status.Last.Explanation, "end of the day") {
+		t.Fatalf("expected the run to be skipped at the end of the day, got %+v", status.Last)
+	}
+	if !state.next.Equal(due.AddDate(0, 0, 1)) {
+		t.Fatalf("expected the next run tomorrow at 22:00, got %v", state.next)
+	}
+
+	// Once the rain has gone the command is sent, the pump is not connected so it fails
+	service.weather = &weatherService{forecast: &WeatherForecast{}}
+	service.execute(state, state.next)
+	if last := service.Status()[0].Last; last.Result != scheduleResultFailed || last.Explanation != "Unknown source Pump" {
+		t.Fatalf("expected the command to fail on the unknown source, got %+v", last)
+	}
+
+	skipped, _ := audit.Search(auditFilter{Type: auditTypeSchedule})
+	if len(skipped) != 2 || !strings.HasPrefix(skipped[0].Message, "Balcony: ") {
+		t.Fatalf("expected the postponed and skipped runs in the audit log, got %+v", skipped)
+	}
+}
@@ -2,44 +2,184 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	texttospeech "cloud.google.com/go/texttospeech/apiv1"
 	texttospeechpb "google.golang.org/genproto/googleapis/cloud/texttospeech/v1"
 )
 
-func generateSpeech(text, voice, format string) (*texttospeechpb.SynthesizeSpeechResponse, error) {
+const (
+	defaultSpeechLanguage = "en-GB"
+
+	speechEngineGoogle = "google"
+	speechEngineExec   = "exec"
+	speechEngineFake   = "fake"
+
+	speechFormatMP3 = "mp3"
+	speechFormatWAV = "wav"
+
+	defaultSpeechCommand = "espeak-ng"
+	defaultSpeechTimeout = 30
+	espeakWordsPerMinute = 175
+)
+
+type speechRequest struct {
+	Text     string
+	Language string
+	Voice    string
+	Format   string
+}
+
+type speechAudio struct {
+	Content []byte
+	Format  string
+}
+
+type speechSynthesizer interface {
+	Name() string
+	DefaultFormat() string
+	Synthesize(request *speechRequest) (*speechAudio, error)
+	Close() error
+}
+
+func newSpeechSynthesizer(config *speechConfiguration) (speechSynthesizer, error) {
+	if config == nil {
+		config = &speechConfiguration{}
+	}
+	switch config.Engine {
+	case "", speechEngineGoogle:
+		return &googleSpeechSynthesizer{config: config}, nil
+
+	case speechEngineExec:
+		command := config.Command
+		if command == "" {
+			command = defaultSpeechCommand
+		}
+		if _, err := exec.LookPath(command); err != nil {
+			return nil, fmt.Errorf("Unable to find speech command %s: %v", command, err)
+		}
+		return &execSpeechSynthesizer{config: config, command: command}, nil
+
+	case speechEngineFake:
+		return &fakeSpeechSynthesizer{config: config}, nil
+
+	default:
+		return nil, fmt.Errorf("Unknown speech engine '%s'", config.Engine)
+	}
+}
+
+func isSpeechFormat(format string) bool {
+	return format == speechFormatMP3 || format == speechFormatWAV
+}
+
+func speechContentType(format string) string {
+	if format == speechFormatWAV {
+		return "audio/wav"
+	}
+	return "audio/mpeg"
+}
+
+func (config *speechConfiguration) language(request *speechRequest) string {
+	return firstNonEmpty(request.Language, config.Language, defaultSpeechLanguage)
+}
+
+func (config *speechConfiguration) rate() float64 {
+	if config.Rate <= 0 {
+		return 1
+	}
+	return config.Rate
+}
+
+// Voice names are looked up for the language first, so a German phrase is not given an English voice
+func (config *speechConfiguration) voiceName(language, voice string) string {
+	if name, ok := config.Voices[language+"/"+voice]; ok {
+		return name
+	}
+	if language == firstNonEmpty(config.Language, defaultSpeechLanguage) {
+		return config.Voices[voice]
+	}
+	return ""
+}
+
+type googleSpeechSynthesizer struct {
+	config *speechConfiguration
+	client *texttospeech.Client
+	mutex  sync.Mutex
+}
+
+func (synth *googleSpeechSynthesizer) Name() string {
+	return speechEngineGoogle
+}
+
+func (synth *googleSpeechSynthesizer) DefaultFormat() string {
+	return speechFormatMP3
+}
+
+func (synth *googleSpeechSynthesizer) connect(ctx context.Context) (*texttospeech.Client, error) {
+	synth.mutex.Lock()
+	defer synth.mutex.Unlock()
+	if synth.client == nil {
+		client, err := texttospeech.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to generate speech context: %v", err)
+		}
+		synth.client = client
+	}
+	return synth.client, nil
+}
+
+func (synth *googleSpeechSynthesizer) Synthesize(request *speechRequest) (*speechAudio, error) {
+	var formatType texttospeechpb.AudioEncoding
+	switch request.Format {
+	case speechFormatMP3:
+		formatType = texttospeechpb.AudioEncoding_MP3
+
+	case speechFormatWAV:
+		formatType = texttospeechpb.AudioEncoding_LINEAR16
+
+	default:
+		return nil, fmt.Errorf("Unsupported speech format '%s'", request.Format)
+	}
+
 	ctx := context.Background()
-	client, err := texttospeech.NewClient(ctx)
+	client, err := synth.connect(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to generate speech context: %v", err)
+		return nil, err
 	}
 
-	voiceGender := texttospeechpb.SsmlVoiceGender_NEUTRAL
-	switch voice {
+	language := synth.config.language(request)
+	voice := &texttospeechpb.VoiceSelectionParams{
+		LanguageCode: language,
+		SsmlGender:   texttospeechpb.SsmlVoiceGender_NEUTRAL,
+		Name:         synth.config.voiceName(language, request.Voice),
+	}
+	switch request.Voice {
 	case "male":
-		voiceGender = texttospeechpb.SsmlVoiceGender_MALE
+		voice.SsmlGender = texttospeechpb.SsmlVoiceGender_MALE
 
 	case "female":
-		voiceGender = texttospeechpb.SsmlVoiceGender_FEMALE
-	}
-
-	formatType := texttospeechpb.AudioEncoding_MP3
-	switch format {
-	case "wav":
-		formatType = texttospeechpb.AudioEncoding_LINEAR16
+		voice.SsmlGender = texttospeechpb.SsmlVoiceGender_FEMALE
 	}
 
 	req := texttospeechpb.SynthesizeSpeechRequest{
 		Input: &texttospeechpb.SynthesisInput{
-			InputSource: &texttospeechpb.SynthesisInput_Text{Text: text},
-		},
-		Voice: &texttospeechpb.VoiceSelectionParams{
-			LanguageCode: "en-GB",
-			SsmlGender:   voiceGender,
+			InputSource: &texttospeechpb.SynthesisInput_Text{Text: request.Text},
 		},
+		Voice: voice,
 		AudioConfig: &texttospeechpb.AudioConfig{
 			AudioEncoding: formatType,
+			SpeakingRate:  synth.config.rate(),
+			Pitch:         synth.config.Pitch,
 		},
 	}
 
@@ -47,6 +187,136 @@ func generateSpeech(text, voice, format string) (*texttospeechpb.SynthesizeSpeec
 	if err != nil {
 		return nil, fmt.Errorf("Unable to synthesize speech: %v", err)
 	}
+	return &speechAudio{Content: resp.AudioContent, Format: request.Format}, nil
+}
+
+func (synth *googleSpeechSynthesizer) Close() error {
+	synth.mutex.Lock()
+	defer synth.mutex.Unlock()
+	if synth.client == nil {
+		return nil
+	}
+	err := synth.client.Close()
+	synth.client = nil
+	return err
+}
+
+type execSpeechSynthesizer struct {
+	config  *speechConfiguration
+	command string
+}
+
+func (synth *execSpeechSynthesizer) Name() string {
+	return speechEngineExec
+}
+
+// The commands only write WAV files and there is nothing to convert them with
+func (synth *execSpeechSynthesizer) DefaultFormat() string {
+	return speechFormatWAV
+}
+
+func (synth *execSpeechSynthesizer) arguments() []string {
+	if len(synth.config.Arguments) > 0 {
+		return synth.config.Arguments
+	}
+	switch filepath.Base(synth.command) {
+	case "pico2wave":
+		return []string{"-l", "{language}", "-w", "{output}", "{text}"}
+	default:
+		return []string{"-v", "{voice}", "-s", "{wordsPerMinute}", "-p", "{pitch}", "-w", "{output}", "{text}"}
+	}
+}
+
+func (synth *execSpeechSynthesizer) Synthesize(request *speechRequest) (*speechAudio, error) {
+	if request.Format != speechFormatWAV {
+		return nil, fmt.Errorf("%s can only generate WAV, not %s", synth.command, request.Format)
+	}
+
+	output, err := ioutil.TempFile("", "speech-*.wav")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create speech file: %v", err)
+	}
+	output.Close()
+	defer os.Remove(output.Name())
+
+	language := synth.config.language(request)
+	voice := synth.config.voiceName(language, request.Voice)
+	if voice == "" {
+		voice = strings.ToLower(language)
+	}
+	pitch := math.Max(0, math.Min(99, 50+synth.config.Pitch*2.5))
+	// A leading space stops text starting with a dash being read as an option
+	text := request.Text
+	if strings.HasPrefix(text, "-") {
+		text = " " + text
+	}
+	replacer := strings.NewReplacer(
+		"{text}", text,
+		"{language}", language,
+		"{voice}", voice,
+		"{output}", output.Name(),
+		"{rate}", strconv.FormatFloat(synth.config.rate(), 'f', -1, 64),
+		"{wordsPerMinute}", strconv.Itoa(int(math.Round(espeakWordsPerMinute*synth.config.rate()))),
+		"{pitch}", strconv.Itoa(int(math.Round(pitch))),
+	)
+	args := []string{}
+	for _, arg := range synth.arguments() {
+		args = append(args, replacer.Replace(arg))
+	}
+
+	timeOut := synth.config.Timeout
+	if timeOut <= 0 {
+		timeOut = defaultSpeechTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeOut)*time.Second)
+	defer cancel()
+	if data, err := exec.CommandContext(ctx, synth.command, args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("Unable to run %s: %v %s", synth.command, err, strings.TrimSpace(string(data)))
+	}
+
+	content, err := ioutil.ReadFile(output.Name())
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read speech file: %v", err)
+	}
+	return &speechAudio{Content: content, Format: speechFormatWAV}, nil
+}
+
+func (synth *execSpeechSynthesizer) Close() error {
+	return nil
+}
+
+type fakeSpeechSynthesizer struct {
+	config   *speechConfiguration
+	requests []speechRequest
+	mutex    sync.Mutex
+}
+
+func (synth *fakeSpeechSynthesizer) Name() string {
+	return speechEngineFake
+}
+
+func (synth *fakeSpeechSynthesizer) DefaultFormat() string {
+	return speechFormatMP3
+}
+
+func (synth *fakeSpeechSynthesizer) Synthesize(request *speechRequest) (*speechAudio, error) {
+	synth.mutex.Lock()
+	synth.requests = append(synth.requests, *request)
+	synth.mutex.Unlock()
+
+	language := synth.config.language(request)
+	summary := fmt.Sprintf("%s|%s|%s|%s|%g|%g|%s", language, request.Voice, synth.config.voiceName(language, request.Voice),
+		request.Format, synth.config.rate(), synth.config.Pitch, request.Text)
+	hash := sha256.Sum256([]byte(summary))
+	return &speechAudio{Content: hash[:], Format: request.Format}, nil
+}
+
+func (synth *fakeSpeechSynthesizer) Requests() []speechRequest {
+	synth.mutex.Lock()
+	defer synth.mutex.Unlock()
+	return append([]speechRequest{}, synth.requests...)
+}
 
-	return resp, nil
+func (synth *fakeSpeechSynthesizer) Close() error {
+	return nil
 }
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSpeechSynthesizer(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *speechConfiguration
+		wantName string
+		wantErr  string
+	}{
+		{name: "default", wantName: speechEngineGoogle},
+		{name: "google", config: &speechConfiguration{Engine: speechEngineGoogle}, wantName: speechEngineGoogle},
+		{name: "fake", config: &speechConfiguration{Engine: speechEngineFake}, wantName: speechEngineFake},
+		{name: "missing command", config: &speechConfiguration{Engine: speechEngineExec, Command: "no-such-speech-command"}, wantErr: "Unable to find speech command"},
+		{name: "unknown engine", config: &speechConfiguration{Engine: "parrot"}, wantErr: "Unknown speech engine"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			synth, err := newSpeechSynthesizer(test.config)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if synth.Name() != test.wantName {
+				t.Fatalf("expected %s, got %s", test.wantName, synth.Name())
+			}
+		})
+	}
+}
+
+func TestSpeechVoiceName(t *testing.T) {
+	config := &speechConfiguration{
+		Language: "en-GB",
+		Voices: map[string]string{
+			"female":       "en-GB-Wavenet-A",
+			"de-DE/female": "de-DE-Wavenet-C",
+		},
+	}
+
+	tests := []struct {
+		language string
+		voice    string
+		want     string
+	}{
+		{language: "en-GB", voice: "female", want: "en-GB-Wavenet-A"},
+		{language: "de-DE", voice: "female", want: "de-DE-Wavenet-C"},
+		{language: "fr-FR", voice: "female", want: ""},
+		{language: "en-GB", voice: "male", want: ""},
+	}
+
+	for _, test := range tests {
+		if got := config.voiceName(test.language, test.voice); got != test.want {
+			t.Errorf("%s %s: expected '%s', got '%s'", test.language, test.voice, test.want, got)
+		}
+	}
+}
+
+func TestFakeSpeechSynthesizer(t *testing.T) {
+	synth := &fakeSpeechSynthesizer{config: &speechConfiguration{Language: "en-GB"}}
+	base := speechRequest{Text: "Hello", Voice: "neutral", Format: speechFormatMP3}
+	first, err := synth.Synthesize(&base)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		request  speechRequest
+		wantSame bool
+	}{
+		{name: "same request", request: base, wantSame: true},
+		{name: "default language given explicitly", request: speechRequest{Text: "Hello", Language: "en-GB", Voice: "neutral", Format: speechFormatMP3}, wantSame: true},
+		{name: "different text", request: speechRequest{Text: "Goodbye", Voice: "neutral", Format: speechFormatMP3}},
+		{name: "different language", request: speechRequest{Text: "Hello", Language: "de-DE", Voice: "neutral", Format: speechFormatMP3}},
+		{name: "different voice", request: speechRequest{Text: "Hello", Voice: "female", Format: speechFormatMP3}},
+		{name: "different format", request: speechRequest{Text: "Hello", Voice: "neutral", Format: speechFormatWAV}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			audio, err := synth.Synthesize(&test.request)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if audio.Format != test.request.Format {
+				t.Fatalf("expected %s audio, got %s", test.request.Format, audio.Format)
+			}
+			if same := bytes.Equal(audio.Content, first.Content); same != test.wantSame {
+				t.Fatalf("expected same content %t, got %t", test.wantSame, same)
+			}
+		})
+	}
+
+	if requests := synth.Requests(); len(requests) != len(tests)+1 || requests[0] != base {
+		t.Fatalf("expected every request to be recorded, got %+v", requests)
+	}
+}
+
+func TestExecSpeechSynthesizer(t *testing.T) {
+	// The script stands in for espeak-ng and writes its arguments to the output file
+	dir := t.TempDir()
+	command := filepath.Join(dir, "espeak-ng")
+	script := "#!/bin/sh\nwhile [ $# -gt 0 ]; do\n  if [ \"$1\" = \"-w\" ]; then out=$2; fi\n  echo \"$1\" >> " + filepath.Join(dir, "args") + "\n  shift\ndone\necho wav > \"$out\"\n"
+	if err := ioutil.WriteFile(command, []byte(script), 0700); err != nil {
+		t.Fatalf("unable to write script: %v", err)
+	}
+
+	synth := &execSpeechSynthesizer{config: &speechConfiguration{Language: "en-GB", Rate: 1.2, Pitch: 4, Timeout: 5}, command: command}
+	if _, err := synth.Synthesize(&speechRequest{Text: "Hello", Format: speechFormatMP3}); err == nil || !strings.Contains(err.Error(), "only generate WAV") {
+		t.Fatalf("expected MP3 to be refused rather than labelled as WAV, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "args")); !os.IsNotExist(err) {
+		t.Fatal("expected the command not to run for a format it cannot produce")
+	}
+
+	audio, err := synth.Synthesize(&speechRequest{Text: "-5 degrees", Voice: "neutral", Format: synth.DefaultFormat()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if audio.Format != speechFormatWAV || string(audio.Content) != "wav\n" {
+		t.Fatalf("expected the WAV written by the command, got %s %q", audio.Format, audio.Content)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatalf("unable to read arguments: %v", err)
+	}
+	args := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []string{"-v", "en-gb", "-s", "210", "-p", "60", "-w"}
+	if len(args) != len(want)+2 {
+		t.Fatalf("expected %d arguments, got %q", len(want)+2, args)
+	}
+	for pos, arg := range want {
+		if args[pos] != arg {
+			t.Fatalf("expected argument %d to be %s, got %q", pos, arg, args)
+		}
+	}
+	if args[len(args)-1] != " -5 degrees" {
+		t.Fatalf("expected text starting with a dash to be protected, got %q", args[len(args)-1])
+	}
+	if _, err = os.Stat(args[len(want)]); !os.IsNotExist(err) {
+		t.Fatalf("expected the temporary speech file to be removed")
+	}
+
+	// Clients that do not ask for a format get what the command can produce
+	api := &webAPI{config: &appConfiguration{}, speech: synth}
+	resp := httptest.NewRecorder()
+	api.generateSpeechFromGET(resp, httptest.NewRequest("GET", "/api/speech?text=Hello", nil))
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "audio/wav" {
+		t.Fatalf("expected WAV by default, got %d %s", resp.Code, resp.Header().Get("Content-Type"))
+	}
+}
+
+func TestGenerateSpeech(t *testing.T) {
+	now := time.Now()
+	weather := &weatherService{
+		current:  &CurrentWeather{Conditions: WeatherConditions{Summary: "Clear", Description: "clear sky", Temperature: 18}},
+		forecast: &WeatherForecast{Items: []WeatherConditions{{Time: now, Summary: "Clouds", Description: "few clouds", MinimumTemperature: 11, MaximumTemperature: 19}}},
+	}
+
+	tests := []struct {
+		name         string
+		query        string
+		wantStatus   int
+		wantType     string
+		wantText     string
+		wantLanguage string
+		wantFormat   string
+	}{
+		{name: "text", query: "text=Hello&voice=Female", wantStatus: http.StatusOK, wantType: "audio/mpeg", wantText: "Hello", wantFormat: speechFormatMP3},
+		{name: "wav", query: "text=Hello&format=WAV", wantStatus: http.StatusOK, wantType: "audio/wav", wantText: "Hello", wantFormat: speechFormatWAV},
+		{name: "unknown format falls back", query: "text=Hello&format=ogg", wantStatus: http.StatusOK, wantType: "audio/mpeg", wantText: "Hello", wantFormat: speechFormatMP3},
+		{name: "missing text", query: "voice=female", wantStatus: http.StatusBadRequest},
+		{
+			name:       "weather",
+			query:      "weather=true&variant=1",
+			wantStatus: http.StatusOK,
+			wantType:   "audio/mpeg",
+			wantText:   "It is 18°C and clear sky at the moment. Expect few clouds, with temperatures from 11 to 19°C.",
+			wantFormat: speechFormatMP3, wantLanguage: "en-GB",
+		},
+		{
+			name:       "german weather",
+			query:      "weather=true&language=de&include=current",
+			wantStatus: http.StatusOK,
+			wantType:   "audio/mpeg",
+			wantText:   "Das Wetter ist im Moment klar, die Temperatur beträgt 18°C.",
+			wantFormat: speechFormatMP3, wantLanguage: "de-DE",
+		},
+		{name: "invalid weather", query: "weather=true&language=xx", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			synth := &fakeSpeechSynthesizer{config: &speechConfiguration{}}
+			api := &webAPI{config: &appConfiguration{}, speech: synth, weather: weather}
+			resp := httptest.NewRecorder()
+			api.generateSpeechFromGET(resp, httptest.NewRequest("GET", "/api/speech?"+test.query, nil))
+
+			if resp.Code != test.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", test.wantStatus, resp.Code, resp.Body.String())
+			}
+			requests := synth.Requests()
+			if test.wantStatus != http.StatusOK {
+				if len(requests) != 0 {
+					t.Fatalf("expected no speech to be generated, got %+v", requests)
+				}
+				return
+			}
+			if resp.Header().Get("Content-Type") != test.wantType {
+				t.Fatalf("expected %s, got %s", test.wantType, resp.Header().Get("Content-Type"))
+			}
+			if len(requests) != 1 {
+				t.Fatalf("expected one request, got %+v", requests)
+			}
+			request := requests[0]
+			if request.Text != test.wantText || request.Language != test.wantLanguage || request.Format != test.wantFormat {
+				t.Fatalf("expected %q in '%s' as %s, got %+v", test.wantText, test.wantLanguage, test.wantFormat, request)
+			}
+			if resp.Body.Len() == 0 {
+				t.Fatalf("expected audio in the response")
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	stationHeader          = "X-Jarvis-Station"
+	stationTimeHeader      = "X-Jarvis-Timestamp"
+	stationNonceHeader     = "X-Jarvis-Nonce"
+	stationSignatureHeader = "X-Jarvis-Signature"
+
+	defaultStationClockSkew = 30
+	stationNonceSize        = 16
+)
+
+type stationContextKey struct{}
+
+type stationNonceCache struct {
+	seen  map[string]time.Time
+	mutex sync.Mutex
+}
+
+func stationSignature(secret, caller, method, uri, timeStamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(caller + "\n" + method + "\n" + uri + "\n" + timeStamp + "\n" + nonce + "\n" + hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func signStationRequest(header http.Header, station *stationConfiguration, caller, method, uri string, body []byte) {
+	if station.Secret == "" {
+		return
+	}
+
+	nonce, err := generateSecret(stationNonceSize)
+	if err != nil {
+		log.Printf("[Security] Unable to sign request to %s: %v", station.Name, err)
+		return
+	}
+	timeStamp := strconv.FormatInt(time.Now().Unix(), 10)
+	header.Set(stationHeader, caller)
+	header.Set(stationTimeHeader, timeStamp)
+	header.Set(stationNonceHeader, nonce)
+	header.Set(stationSignatureHeader, stationSignature(station.Secret, caller, method, uri, timeStamp, nonce, body))
+}
+
+func stationTLSConfig(station *stationConfiguration) (*tls.Config, error) {
+	if !station.IsSecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+	if station.CAFile != "" {
+		data, err := ioutil.ReadFile(station.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read certificate authority: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificates found in %s", station.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if station.CertificateFile != "" {
+		cert, err := tls.LoadX509KeyPair(station.CertificateFile, station.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func stationFromRequest(req *http.Request) string {
+	name, _ := req.Context().Value(stationContextKey{}).(string)
+	return name
+}
+
+// A nonce only has to be remembered until its timestamp falls outside the allowed window
+func (cache *stationNonceCache) Add(nonce string, expires time.Time) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	now := time.Now()
+	if cache.seen == nil {
+		cache.seen = map[string]time.Time{}
+	}
+	for key, expiry := range cache.seen {
+		if now.After(expiry) {
+			delete(cache.seen, key)
+		}
+	}
+	if _, ok := cache.seen[nonce]; ok {
+		return false
+	}
+	cache.seen[nonce] = expires
+	return true
+}
+
+func (api *webAPI) authenticateStationsMiddleware(handler http.Handler) http.Handler {
+	nonces := &stationNonceCache{}
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		security := api.config.PeerSecurity
+		if security == nil {
+			handler.ServeHTTP(resp, req)
+			return
+		}
+
+		caller := req.Header.Get(stationHeader)
+		if caller == "" && req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+			caller = req.TLS.VerifiedChains[0][0].Subject.CommonName
+			if api.config.FindStation(caller) == nil {
+				log.Printf("[Security] Rejecting certificate for unknown station %s from %s", caller, req.RemoteAddr)
+				api.writeStatusJSON(resp, http.StatusUnauthorized, "Unauthorized", "Unknown station")
+				return
+			}
+			log.Printf("[Security] Station %s authenticated by certificate", caller)
+			if !isFederationRequest(req) {
+				api.rejectStationRequest(resp, req, caller)
+				return
+			}
+			handler.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), stationContextKey{}, caller)))
+			return
+		}
+
+		if caller == "" {
+			// Users can still send commands, but without a user store nothing else vouches for an unsigned one
+			if !security.AllowUnsignedCommands && api.users == nil && isCommandRequest(req) {
+				log.Printf("[Security] Rejecting unsigned command from %s", req.RemoteAddr)
+				api.writeStatusJSON(resp, http.StatusUnauthorized, "Unauthorized", "Signed request required")
+				return
+			}
+			handler.ServeHTTP(resp, req)
+			return
+		}
+
+		// Each caller signs with the secret this station has configured for it, so a secret only vouches for one station
+		station := api.config.FindStation(caller)
+		if station == nil || station.Secret == "" {
+			log.Printf("[Security] Rejecting request from unknown station %s at %s", caller, req.RemoteAddr)
+			api.writeStatusJSON(resp, http.StatusUnauthorized, "Unauthorized", "Unknown station")
+			return
+		}
+
+		body := []byte{}
+		if req.Body != nil {
+			var err error
+			if body, err = ioutil.ReadAll(req.Body); err != nil {
+				api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Unable to read request")
+				return
+			}
+			req.Body.Close()
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		if err := verifyStationRequest(req, security, station.Secret, nonces, body); err != nil {
+			log.Printf("[Security] Rejecting request from station %s at %s: %v", caller, req.RemoteAddr, err)
+			api.writeStatusJSON(resp, http.StatusUnauthorized, "Unauthorized", "Invalid station signature")
+			return
+		}
+		if !isFederationRequest(req) {
+			api.rejectStationRequest(resp, req, caller)
+			return
+		}
+		handler.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), stationContextKey{}, caller)))
+	})
+}
+
+func verifyStationRequest(req *http.Request, security *peerSecurityConfiguration, secret string, nonces *stationNonceCache, body []byte) error {
+	timeStamp := req.Header.Get(stationTimeHeader)
+	seconds, err := strconv.ParseInt(timeStamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid timestamp '%s'", timeStamp)
+	}
+
+	window := stationClockWindow(security)
+	sent := time.Unix(seconds, 0)
+	if age := time.Since(sent); age > window || age < -window {
+		return fmt.Errorf("Timestamp is outside the allowed window")
+	}
+
+	nonce := req.Header.Get(stationNonceHeader)
+	if nonce == "" {
+		return fmt.Errorf("Missing nonce")
+	}
+	expected := stationSignature(secret, req.Header.Get(stationHeader), req.Method, req.RequestURI, timeStamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(req.Header.Get(stationSignatureHeader))) {
+		return fmt.Errorf("Signature does not match")
+	}
+	if !nonces.Add(nonce, sent.Add(window)) {
+		return fmt.Errorf("Request has already been used")
+	}
+	return nil
+}
+
+func stationClockWindow(security *peerSecurityConfiguration) time.Duration {
+	skew := int64(defaultStationClockSkew)
+	if security != nil && security.ClockSkew > 0 {
+		skew = security.ClockSkew
+	}
+	return time.Duration(skew) * time.Second
+}
+
+func (api *webAPI) rejectStationRequest(resp http.ResponseWriter, req *http.Request, caller string) {
+	log.Printf("[Security] Rejecting %s %s from station %s", req.Method, req.URL.Path, caller)
+	api.writeStatusJSON(resp, http.StatusForbidden, "Forbidden", "Stations cannot use this endpoint")
+}
+
+// Stations only need to read values, replicate and proxy commands, so a station identity is not accepted anywhere else
+func isFederationRequest(req *http.Request) bool {
+	path := req.URL.Path
+	if req.Method == "GET" {
+		return path == "/api/ws" || path == "/api/info" || path == "/api/stations/local" ||
+			(strings.HasPrefix(path, "/api/sources/") && strings.HasSuffix(path, "/values"))
+	}
+	return isCommandRequest(req) && strings.HasPrefix(path, "/api/sources/")
+}
+
+func isCommandRequest(req *http.Request) bool {
+	return req.Method == "POST" && strings.HasSuffix(req.URL.Path, "/effectors")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyStationRequest(t *testing.T) {
+	security := &peerSecurityConfiguration{}
+	station := &stationConfiguration{Name: "garden", Secret: "shared"}
+	uri := "/api/sources/pump/effectors"
+	body := []byte(`{"name":"valve","action":"on"}`)
+
+	tests := []struct {
+		name    string
+		change  func(req *http.Request)
+		body    string
+		wantErr string
+	}{
+		{name: "valid"},
+		{name: "changed body", body: `{"name":"valve","action":"off"}`, wantErr: "Signature does not match"},
+		{name: "changed caller", change: func(req *http.Request) { req.Header.Set(stationHeader, "shed") }, wantErr: "Signature does not match"},
+		{name: "changed nonce", change: func(req *http.Request) { req.Header.Set(stationNonceHeader, "0000") }, wantErr: "Signature does not match"},
+		{name: "missing nonce", change: func(req *http.Request) { req.Header.Del(stationNonceHeader) }, wantErr: "Missing nonce"},
+		{name: "bad timestamp", change: func(req *http.Request) { req.Header.Set(stationTimeHeader, "soon") }, wantErr: "Invalid timestamp"},
+		{
+			name: "old timestamp",
+			change: func(req *http.Request) {
+				req.Header.Set(stationTimeHeader, strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+			},
+			wantErr: "outside the allowed window",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", uri, nil)
+			signStationRequest(req.Header, station, "hub", "POST", uri, body)
+			if test.change != nil {
+				test.change(req)
+			}
+			sent := body
+			if test.body != "" {
+				sent = []byte(test.body)
+			}
+
+			err := verifyStationRequest(req, security, "shared", &stationNonceCache{}, sent)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Fatalf("expected error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestVerifyStationRequestRejectsReplay(t *testing.T) {
+	security := &peerSecurityConfiguration{}
+	station := &stationConfiguration{Name: "garden", Secret: "shared"}
+	nonces := &stationNonceCache{}
+	req := httptest.NewRequest("GET", "/api/sources", nil)
+	signStationRequest(req.Header, station, "hub", "GET", "/api/sources", nil)
+
+	if err := verifyStationRequest(req, security, "shared", nonces, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := verifyStationRequest(req, security, "shared", nonces, nil); err == nil {
+		t.Fatalf("expected a replayed request to be rejected")
+	}
+}
+
+func TestAuthenticateStationsMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		security *peerSecurityConfiguration
+		users    *userStore
+		method   string
+		path     string
+		want     int
+	}{
+		{name: "no peer security", method: "POST", path: "/api/sources/pump/effectors", want: http.StatusOK},
+		{name: "unsigned command", security: &peerSecurityConfiguration{}, method: "POST", path: "/api/sources/pump/effectors", want: http.StatusUnauthorized},
+		{name: "unsigned command allowed", security: &peerSecurityConfiguration{AllowUnsignedCommands: true}, method: "POST", path: "/api/sources/pump/effectors", want: http.StatusOK},
+		{name: "unsigned command from a user", security: &peerSecurityConfiguration{}, users: &userStore{}, method: "POST", path: "/api/sources/pump/effectors", want: http.StatusOK},
+		{name: "unsigned read", security: &peerSecurityConfiguration{}, method: "GET", path: "/api/sources", want: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &webAPI{config: &appConfiguration{PeerSecurity: test.security}, users: test.users}
+			handler := api.authenticateStationsMiddleware(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+				resp.WriteHeader(http.StatusOK)
+			}))
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, httptest.NewRequest(test.method, test.path, nil))
+			if resp.Code != test.want {
+				t.Fatalf("expected status %d, got %d", test.want, resp.Code)
+			}
+		})
+	}
+}
+
+func TestStationCallersUseTheirOwnSecretAndRoles(t *testing.T) {
+	config := &appConfiguration{
+		PeerSecurity: &peerSecurityConfiguration{},
+		stations: map[string]stationConfiguration{
+			"hub":  {Name: "hub", Secret: "hub-secret", Roles: []string{"operator"}},
+			"shed": {Name: "shed", Secret: "shed-secret"},
+		},
+	}
+	api := &webAPI{config: config}
+	handler := api.authenticateStationsMiddleware(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		level := permissionView
+		if req.Method == "POST" {
+			level = permissionCommand
+		}
+		if api.authorize(resp, req, level, "", "pump") {
+			resp.WriteHeader(http.StatusOK)
+		}
+	}))
+	send := func(caller, secret, method, uri string) int {
+		req := httptest.NewRequest(method, uri, nil)
+		signStationRequest(req.Header, &stationConfiguration{Secret: secret}, caller, method, uri, nil)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	if code := send("hub", "hub-secret", "POST", "/api/sources/pump/effectors"); code != http.StatusOK {
+		t.Errorf("expected the hub to send commands as an operator, got %d", code)
+	}
+	if code := send("shed", "shed-secret", "GET", "/api/sources/pump/values"); code != http.StatusOK {
+		t.Errorf("expected the shed to read values, got %d", code)
+	}
+	if code := send("shed", "shed-secret", "POST", "/api/sources/pump/effectors"); code != http.StatusForbidden {
+		t.Errorf("expected the shed to be limited to viewing by default, got %d", code)
+	}
+	if code := send("hub", "shed-secret", "POST", "/api/sources/pump/effectors"); code != http.StatusUnauthorized {
+		t.Errorf("expected the shed secret not to vouch for the hub, got %d", code)
+	}
+	if code := send("attic", "hub-secret", "GET", "/api/sources/pump/values"); code != http.StatusUnauthorized {
+		t.Errorf("expected an unknown station to be rejected, got %d", code)
+	}
+}
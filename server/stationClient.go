@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultStationTimeout     = 5
+	defaultStationCacheTime   = 10
+	defaultStationStaleTime   = 300
+	defaultStationMaxRequests = 2
+)
+
+type stationResponse struct {
+	Body      []byte
+	Retrieved time.Time
+	IsStale   bool
+}
+
+type stationCacheEntry struct {
+	body      []byte
+	retrieved time.Time
+}
+
+type stationClient struct {
+	config      *appConfiguration
+	clients     map[string]*http.Client
+	timeout     time.Duration
+	cacheTime   time.Duration
+	staleTime   time.Duration
+	maxRequests int
+	cache       map[string]*stationCacheEntry
+	limits      map[string]chan int
+	mutex       sync.Mutex
+}
+
+func newStationClient(appConfig *appConfiguration) *stationClient {
+	timeout, cacheTime, staleTime, maxRequests := int64(defaultStationTimeout), int64(defaultStationCacheTime), int64(defaultStationStaleTime), defaultStationMaxRequests
+	if config := appConfig.StationClient; config != nil {
+		if config.Timeout > 0 {
+			timeout = config.Timeout
+		}
+		if config.CacheTime > 0 {
+			cacheTime = config.CacheTime
+		}
+		if config.StaleTime > 0 {
+			staleTime = config.StaleTime
+		}
+		if config.MaxRequests > 0 {
+			maxRequests = config.MaxRequests
+		}
+	}
+
+	return &stationClient{
+		config:      appConfig,
+		clients:     map[string]*http.Client{},
+		timeout:     time.Duration(timeout) * time.Second,
+		cacheTime:   time.Duration(cacheTime) * time.Second,
+		staleTime:   time.Duration(staleTime) * time.Second,
+		maxRequests: maxRequests,
+		cache:       map[string]*stationCacheEntry{},
+		limits:      map[string]chan int{},
+	}
+}
+
+func (sc *stationClient) Get(station *stationConfiguration, path string) (*stationResponse, error) {
+	key := station.Name + path
+	sc.mutex.Lock()
+	entry, ok := sc.cache[key]
+	sc.mutex.Unlock()
+	if ok && time.Since(entry.retrieved) < sc.cacheTime {
+		return &stationResponse{Body: entry.body, Retrieved: entry.retrieved}, nil
+	}
+
+	body, err := sc.Fetch(station, path)
+	if err == nil {
+		now := time.Now()
+		sc.mutex.Lock()
+		sc.cache[key] = &stationCacheEntry{body: body, retrieved: now}
+		sc.mutex.Unlock()
+		return &stationResponse{Body: body, Retrieved: now}, nil
+	}
+
+	var unavailable *stationUnavailableError
+	if ok && errors.As(err, &unavailable) && time.Since(entry.retrieved) < sc.staleTime {
+		log.Printf("[Stations] Using stale data for %s from %s: %v", path, station.Name, err)
+		return &stationResponse{Body: entry.body, Retrieved: entry.retrieved, IsStale: true}, nil
+	}
+	return nil, err
+}
+
+func (sc *stationClient) Fetch(station *stationConfiguration, path string) ([]byte, error) {
+	return sc.send(station, "GET", path, "", nil)
+}
+
+func (sc *stationClient) Post(station *stationConfiguration, path, contentType string, body io.Reader) (*stationResponse, error) {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read command: %v", err)
+	}
+
+	data, err = sc.send(station, "POST", path, contentType, data)
+	if err != nil {
+		return nil, err
+	}
+	return &stationResponse{Body: data, Retrieved: time.Now()}, nil
+}
+
+func (sc *stationClient) DialWebsocket(station *stationConfiguration, path string) (*websocket.Conn, error) {
+	tlsConfig, err := stationTLSConfig(station)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: sc.timeout,
+		TLSClientConfig:  tlsConfig,
+	}
+	header := http.Header{}
+	signStationRequest(header, station, sc.config.StationName(), "GET", path, nil)
+	conn, _, err := dialer.Dial(sc.baseURL(station, "ws")+path, header)
+	return conn, err
+}
+
+func (sc *stationClient) baseURL(station *stationConfiguration, scheme string) string {
+	if station.IsSecure {
+		scheme += "s"
+	}
+	return scheme + "://" + sc.config.StationAddress(station)
+}
+
+func (sc *stationClient) send(station *stationConfiguration, method, path, contentType string, body []byte) ([]byte, error) {
+	limit := sc.limitFor(station.Name)
+	select {
+	case limit <- 1:
+		defer func() { <-limit }()
+	case <-time.After(sc.timeout):
+		return nil, &stationUnavailableError{fmt.Errorf("Too many requests waiting for station")}
+	}
+
+	client, err := sc.clientFor(station)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, sc.baseURL(station, "http")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	signStationRequest(req.Header, station, sc.config.StationName(), method, req.URL.RequestURI(), body)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, &stationUnavailableError{err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return nil, &stationUnavailableError{fmt.Errorf("Unable to retrieve from station: %s", res.Status)}
+	} else if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to retrieve from station: %s", res.Status)
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, &stationUnavailableError{err}
+	}
+	return data, nil
+}
+
+func (sc *stationClient) clientFor(station *stationConfiguration) (*http.Client, error) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	client, ok := sc.clients[station.Name]
+	if ok {
+		return client, nil
+	}
+
+	tlsConfig, err := stationTLSConfig(station)
+	if err != nil {
+		return nil, err
+	}
+	client = &http.Client{
+		Timeout:   sc.timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	sc.clients[station.Name] = client
+	return client, nil
+}
+
+func (sc *stationClient) limitFor(name string) chan int {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	limit, ok := sc.limits[name]
+	if !ok {
+		limit = make(chan int, sc.maxRequests)
+		sc.limits[name] = limit
+	}
+	return limit
+}
+
+type stationUnavailableError struct {
+	err error
+}
+
+func (err *stationUnavailableError) Error() string {
+	return fmt.Sprintf("Station not available: %v", err.err)
+}
+
+func (err *stationUnavailableError) Unwrap() error {
+	return err.err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStationClientCacheAndStaleData(t *testing.T) {
+	var requests, status int32 = 0, http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if code := int(atomic.LoadInt32(&status)); code != http.StatusOK {
+			resp.WriteHeader(code)
+			return
+		}
+		resp.Write([]byte(`{"sources":[]}`))
+	}))
+	defer server.Close()
+
+	station := &stationConfiguration{Name: "Plant Monitor", Address: strings.TrimPrefix(server.URL, "http://")}
+	client := newStationClient(&appConfiguration{Name: "Hub"})
+
+	first, err := client.Get(station, "/api/stations/local")
+	if err != nil || string(first.Body) != `{"sources":[]}` || first.IsStale {
+		t.Fatalf("expected fresh station data, got %+v, %v", first, err)
+	}
+	if _, err = client.Get(station, "/api/stations/local"); err != nil || atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("expected the second request to be served from the cache, %d requests: %v", requests, err)
+	}
+
+	// Once the cache has expired a failing station falls back to the last data
+	client.cacheTime = 0
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	stale, err := client.Get(station, "/api/stations/local")
+	if err != nil || !stale.IsStale || !stale.Retrieved.Equal(first.Retrieved) {
+		t.Fatalf("expected stale data from %v, got %+v, %v", first.Retrieved, stale, err)
+	}
+
+	// A station that answers with a client error is not unavailable, so there is no fallback
+	atomic.StoreInt32(&status, http.StatusForbidden)
+	if _, err = client.Get(station, "/api/stations/local"); err == nil {
+		t.Fatal("expected an error for a forbidden request")
+	}
+
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	client.staleTime = time.Nanosecond
+	if _, err = client.Get(station, "/api/stations/local"); err == nil {
+		t.Fatal("expected an error once the cached data is too old")
+	}
+}
+
+func TestStationClientDefaults(t *testing.T) {
+	client := newStationClient(&appConfiguration{StationClient: &stationClientConfiguration{Timeout: 2, MaxRequests: -1}})
+	if client.timeout != 2*time.Second {
+		t.Errorf("expected the configured timeout, got %v", client.timeout)
+	}
+	if client.cacheTime != defaultStationCacheTime*time.Second || client.staleTime != defaultStationStaleTime*time.Second {
+		t.Errorf("expected the default cache and stale times, got %v and %v", client.cacheTime, client.staleTime)
+	}
+	if client.maxRequests != defaultStationMaxRequests {
+		t.Errorf("expected %d concurrent requests, got %d", defaultStationMaxRequests, client.maxRequests)
+	}
+}
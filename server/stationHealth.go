@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	stationStatusUnknown     = "Unknown"
+	stationStatusActive      = "Active"
+	stationStatusUnreachable = "Unreachable"
+	stationStatusDisabled    = "Disabled"
+
+	defaultStationCheckPeriod = 30
+)
+
+type stationHealth struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Latency   int64  `json:"latency,omitempty"`
+	LastSeen  string `json:"lastSeen,omitempty"`
+	LastCheck string `json:"lastCheck,omitempty"`
+	Version   string `json:"version,omitempty"`
+	LastError string `json:"error,omitempty"`
+}
+
+type stationStatusEvent struct {
+	Event    string `json:"event"`
+	Station  string `json:"station"`
+	Status   string `json:"status"`
+	Previous string `json:"previous"`
+	Time     string `json:"time"`
+	Message  string `json:"msg,omitempty"`
+}
+
+type stationStatusListener chan<- *stationStatusEvent
+
+type stationHealthChecker struct {
+	stations    []stationConfiguration
+	health      map[string]*stationHealth
+	listeners   map[stationStatusListener]bool
+	client      *stationClient
+	mutex       sync.Mutex
+	isRunning   bool
+	stopRequest chan int
+	stopReply   chan int
+}
+
+func (checker *stationHealthChecker) AddListener(listener stationStatusListener) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	if checker.listeners == nil {
+		checker.listeners = map[stationStatusListener]bool{}
+	}
+	checker.listeners[listener] = true
+}
+
+func (checker *stationHealthChecker) Start(config *appConfiguration) error {
+	if checker.isRunning {
+		return nil
+	}
+
+	log.Printf("[Health] Starting station health checks")
+	period := config.StationCheckPeriod
+	if period <= 0 {
+		period = defaultStationCheckPeriod
+	}
+
+	checker.mutex.Lock()
+	checker.stations = config.StationList()
+	checker.health = map[string]*stationHealth{}
+	for _, station := range checker.stations {
+		status := stationStatusUnknown
+		if station.IsDisabled {
+			status = stationStatusDisabled
+		}
+		checker.health[station.Name] = &stationHealth{
+			Name:   station.Name,
+			Status: status,
+		}
+	}
+	checker.mutex.Unlock()
+
+	checker.client = newStationClient(config)
+	checker.stopRequest = make(chan int)
+	checker.stopReply = make(chan int, 1)
+	go checker.run(time.Duration(period) * time.Second)
+	checker.isRunning = true
+	return nil
+}
+
+func (checker *stationHealthChecker) AddStation(station stationConfiguration) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	if checker.health == nil || checker.health[station.Name] != nil {
+		return
+	}
+	log.Printf("[Health] Adding station %s", station.Name)
+	checker.stations = append(checker.stations, station)
+	checker.health[station.Name] = &stationHealth{
+		Name:   station.Name,
+		Status: stationStatusUnknown,
+	}
+}
+
+func (checker *stationHealthChecker) Stop(timeOut time.Duration) error {
+	if !checker.isRunning {
+		return nil
+	}
+
+	log.Printf("[Health] Stopping station health checks")
+	checker.isRunning = false
+	deadline := time.After(timeOut)
+	select {
+	case checker.stopRequest <- 1:
+	case <-deadline:
+		return errors.New("Stop station health checks timed out")
+	}
+	select {
+	case <-checker.stopReply:
+		return nil
+	case <-deadline:
+		return errors.New("Stop station health checks timed out")
+	}
+}
+
+func (checker *stationHealthChecker) Get(name string) *stationHealth {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	health, ok := checker.health[name]
+	if !ok {
+		return nil
+	}
+	clone := *health
+	return &clone
+}
+
+func (checker *stationHealthChecker) run(period time.Duration) {
+	checker.checkAll()
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-checker.stopRequest:
+			checker.stopReply <- 1
+			return
+
+		case <-ticker.C:
+			checker.checkAll()
+		}
+	}
+}
+
+func (checker *stationHealthChecker) checkAll() {
+	checker.mutex.Lock()
+	stations := checker.stations
+	checker.mutex.Unlock()
+
+	var wait sync.WaitGroup
+	for _, station := range stations {
+		if station.IsDisabled {
+			continue
+		}
+		wait.Add(1)
+		go func(station stationConfiguration) {
+			defer wait.Done()
+			checker.check(station)
+		}(station)
+	}
+	wait.Wait()
+}
+
+func (checker *stationHealthChecker) check(station stationConfiguration) {
+	start := time.Now()
+	info, err := checker.queryStation(station)
+	elapsed := time.Since(start)
+
+	checker.mutex.Lock()
+	health := checker.health[station.Name]
+	previous := health.Status
+	health.LastCheck = start.Format(time.RFC3339)
+	if err != nil {
+		health.Status = stationStatusUnreachable
+		health.LastError = err.Error()
+		health.Latency = 0
+	} else {
+		health.Status = stationStatusActive
+		health.LastError = ""
+		health.Latency = elapsed.Milliseconds()
+		health.LastSeen = health.LastCheck
+		health.Version = info.Version
+	}
+	current := health.Status
+	message := health.LastError
+	checker.mutex.Unlock()
+
+	if previous == current {
+		return
+	}
+
+	log.Printf("[Health] Station %s changed from %s to %s", station.Name, previous, current)
+	event := &stationStatusEvent{
+		Event:    "station",
+		Station:  station.Name,
+		Status:   current,
+		Previous: previous,
+		Time:     start.Format(time.RFC3339),
+		Message:  message,
+	}
+	checker.mutex.Lock()
+	listeners := make([]stationStatusListener, 0, len(checker.listeners))
+	for listener := range checker.listeners {
+		listeners = append(listeners, listener)
+	}
+	checker.mutex.Unlock()
+	for _, listener := range listeners {
+		listener <- event
+	}
+}
+
+func (checker *stationHealthChecker) queryStation(station stationConfiguration) (*serverInformation, error) {
+	data, err := checker.client.Fetch(&station, "/api/info")
+	if err != nil {
+		return nil, err
+	}
+
+	info := &serverInformation{}
+	if err = json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("Unable to decode station information: %v", err)
+	}
+	return info, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStationHealthTransitions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/info" {
+			http.NotFound(resp, req)
+			return
+		}
+		resp.Write([]byte(`{"version":"2.1","apiVersion":1}`))
+	}))
+	station := stationConfiguration{Name: "Plant Monitor", Address: strings.TrimPrefix(server.URL, "http://")}
+	config := &appConfiguration{Name: "Hub", Stations: []stationConfiguration{station}}
+
+	events := make(chan *stationStatusEvent, 4)
+	checker := &stationHealthChecker{
+		stations: config.Stations,
+		health:   map[string]*stationHealth{station.Name: {Name: station.Name, Status: stationStatusUnknown}},
+		client:   newStationClient(config),
+	}
+	checker.AddListener(events)
+
+	checker.checkAll()
+	health := checker.Get(station.Name)
+	if health.Status != stationStatusActive || health.Version != "2.1" || health.LastSeen == "" {
+		t.Fatalf("expected an active station on version 2.1, got %+v", health)
+	}
+	event := <-events
+	if event.Previous != stationStatusUnknown || event.Status != stationStatusActive {
+		t.Fatalf("expected a change from unknown to active, got %+v", event)
+	}
+
+	// A second successful check is not a transition
+	checker.checkAll()
+	if len(events) != 0 {
+		t.Fatalf("expected no event while the station stays active, got %+v", <-events)
+	}
+
+	server.Close()
+	checker.checkAll()
+	health = checker.Get(station.Name)
+	if health.Status != stationStatusUnreachable || health.LastError == "" || health.Latency != 0 {
+		t.Fatalf("expected an unreachable station with an error, got %+v", health)
+	}
+	if health.LastSeen == "" || health.Version != "2.1" {
+		t.Fatalf("expected the last sighting to be kept, got %+v", health)
+	}
+	if event = <-events; event.Status != stationStatusUnreachable || event.Message != health.LastError {
+		t.Fatalf("expected a change to unreachable with the error, got %+v", event)
+	}
+
+	if checker.Get("Shed") != nil {
+		t.Fatal("expected no health for an unknown station")
+	}
+}
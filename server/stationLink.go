@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	stationMinReconnectWait = time.Second
+	stationMaxReconnectWait = time.Minute
+)
+
+type stationFederation struct {
+	client      *stationClient
+	links       []*stationLink
+	listeners   map[monitorListener]bool
+	mutex       sync.Mutex
+	isRunning   bool
+	stopRequest chan int
+	finished    sync.WaitGroup
+}
+
+type stationLink struct {
+	station    stationConfiguration
+	federation *stationFederation
+	conn       *websocket.Conn
+	mutex      sync.Mutex
+}
+
+func (fed *stationFederation) AddListener(listener monitorListener) {
+	fed.mutex.Lock()
+	defer fed.mutex.Unlock()
+	if fed.listeners == nil {
+		fed.listeners = map[monitorListener]bool{}
+	}
+	fed.listeners[listener] = true
+}
+
+func (fed *stationFederation) RemoveListener(listener monitorListener) {
+	fed.mutex.Lock()
+	defer fed.mutex.Unlock()
+	if fed.listeners[listener] {
+		delete(fed.listeners, listener)
+	}
+}
+
+func (fed *stationFederation) Start(config *appConfiguration, client *stationClient) error {
+	if fed.isRunning {
+		return nil
+	}
+
+	log.Printf("[Stations] Starting station links")
+	fed.client = client
+	fed.stopRequest = make(chan int)
+	fed.links = []*stationLink{}
+	for _, station := range config.StationList() {
+		if station.IsDisabled {
+			log.Printf("[Stations] Skipping station %s - disabled", station.Name)
+			continue
+		}
+		link := &stationLink{
+			station:    station,
+			federation: fed,
+		}
+		fed.links = append(fed.links, link)
+		fed.finished.Add(1)
+		go link.run()
+	}
+	fed.isRunning = true
+	return nil
+}
+
+func (fed *stationFederation) AddStation(station stationConfiguration) {
+	fed.mutex.Lock()
+	defer fed.mutex.Unlock()
+	if !fed.isRunning {
+		return
+	}
+	log.Printf("[Stations] Adding station %s", station.Name)
+	link := &stationLink{
+		station:    station,
+		federation: fed,
+	}
+	fed.links = append(fed.links, link)
+	fed.finished.Add(1)
+	go link.run()
+}
+
+func (fed *stationFederation) Stop(timeOut time.Duration) error {
+	if !fed.isRunning {
+		return nil
+	}
+
+	log.Printf("[Stations] Stopping station links")
+	fed.mutex.Lock()
+	fed.isRunning = false
+	links := fed.links
+	fed.mutex.Unlock()
+	close(fed.stopRequest)
+	for _, link := range links {
+		link.disconnect()
+	}
+
+	done := make(chan int)
+	go func() {
+		fed.finished.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeOut):
+		return errors.New("Stop station links timed out")
+	}
+}
+
+func (fed *stationFederation) publish(result *monitorResult) {
+	// Sending while holding the lock would let one slow listener stall every link
+	fed.mutex.Lock()
+	listeners := make([]monitorListener, 0, len(fed.listeners))
+	for listener := range fed.listeners {
+		listeners = append(listeners, listener)
+	}
+	fed.mutex.Unlock()
+	for _, listener := range listeners {
+		listener <- result
+	}
+}
+
+func (link *stationLink) run() {
+	defer link.federation.finished.Done()
+	wait := stationMinReconnectWait
+	for {
+		err := link.connect()
+		if err == nil {
+			wait = stationMinReconnectWait
+			err = link.receive()
+			link.disconnect()
+		}
+
+		select {
+		case <-link.federation.stopRequest:
+			log.Printf("[Stations] Link to %s stopped", link.station.Name)
+			return
+		default:
+		}
+
+		log.Printf("[Stations] Lost link to %s, retrying in %v: %v", link.station.Name, wait, err)
+		select {
+		case <-link.federation.stopRequest:
+			log.Printf("[Stations] Link to %s stopped", link.station.Name)
+			return
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+		if wait > stationMaxReconnectWait {
+			wait = stationMaxReconnectWait
+		}
+	}
+}
+
+func (link *stationLink) connect() error {
+	log.Printf("[Stations] Connecting to %s", link.station.Name)
+	conn, err := link.federation.client.DialWebsocket(&link.station, "/api/ws")
+	if err != nil {
+		return err
+	}
+
+	conn.SetReadLimit(maxMessageSize * 256)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+	})
+
+	link.mutex.Lock()
+	link.conn = conn
+	link.mutex.Unlock()
+	log.Printf("[Stations] Connected to %s", link.station.Name)
+	return nil
+}
+
+func (link *stationLink) disconnect() {
+	link.mutex.Lock()
+	defer link.mutex.Unlock()
+	if link.conn != nil {
+		link.conn.Close()
+		link.conn = nil
+	}
+}
+
+func (link *stationLink) receive() error {
+	link.mutex.Lock()
+	conn := link.conn
+	link.mutex.Unlock()
+	if conn == nil {
+		return errors.New("Station is not connected")
+	}
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		// The hub batches queued results into one message separated by newlines
+		decoder := json.NewDecoder(bytes.NewReader(message))
+		for {
+			var frame json.RawMessage
+			if err = decoder.Decode(&frame); err != nil {
+				if err != io.EOF {
+					log.Printf("[Stations] Unable to decode result from %s: %v", link.station.Name, err)
+				}
+				break
+			}
+
+			// The same socket carries events such as station status and weather alerts, which are not results
+			envelope := struct {
+				Event string `json:"event"`
+			}{}
+			if err = json.Unmarshal(frame, &envelope); err != nil || envelope.Event != "" {
+				continue
+			}
+			result := &monitorResult{}
+			if err = json.Unmarshal(frame, result); err != nil || result.Source == "" {
+				continue
+			}
+
+			// Results already tagged with a station have been relayed by the remote
+			// hub, passing them on again could loop between hubs
+			if result.Station != "" {
+				continue
+			}
+			result.Station = link.station.Name
+			link.federation.publish(result)
+		}
+	}
+}
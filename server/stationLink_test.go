@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFederationPublishReleasesLock(t *testing.T) {
+	fed := &stationFederation{}
+	slow := make(chan *monitorResult)
+	fed.AddListener(slow)
+
+	published := make(chan int)
+	go func() {
+		fed.publish(&monitorResult{Source: "Plant"})
+		close(published)
+	}()
+
+	// The slow listener has not read its result yet, registering another must not wait for it
+	time.Sleep(20 * time.Millisecond)
+	added := make(chan int)
+	go func() {
+		fed.AddListener(make(monitorListener, 1))
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("adding a listener waited for a slow listener")
+	}
+
+	if result := <-slow; result.Source != "Plant" {
+		t.Fatalf("expected the Plant result, got %+v", result)
+	}
+	<-published
+}
+
+func TestStationLinkReceive(t *testing.T) {
+	frames := strings.Join([]string{
+		`{"source":"Plant","values":[{"name":"Moisture","value":41}]}`,
+		`{"event":"stationStatus","source":"Plant"}`,
+		`{"source":"Greenhouse","station":"Shed"}`,
+		`{"values":[]}`,
+		`{"source":"Pump"}`,
+	}, "\n")
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(resp, req, nil)
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(frames))
+		conn.Close()
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	out := make(chan *monitorResult, 10)
+	fed := &stationFederation{}
+	fed.AddListener(out)
+	link := &stationLink{station: stationConfiguration{Name: "Balcony"}, federation: fed, conn: conn}
+	if err = link.receive(); err == nil {
+		t.Fatal("expected receive to end with the connection")
+	}
+	close(out)
+
+	var received []string
+	for result := range out {
+		if result.Station != "Balcony" {
+			t.Errorf("expected %s to be tagged with the station, got '%s'", result.Source, result.Station)
+		}
+		received = append(received, result.Source)
+	}
+	if strings.Join(received, ",") != "Plant,Pump" {
+		t.Fatalf("expected only the local results Plant and Pump, got %v", received)
+	}
+}
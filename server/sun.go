@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	julianUnixEpoch = 2440587.5
+	julian2000      = 2451545.0
+
+	sunriseAltitude              = -0.833
+	civilTwilightAltitude        = -6
+	nauticalTwilightAltitude     = -12
+	astronomicalTwilightAltitude = -18
+)
+
+type sunEvent struct {
+	transit float64
+	sinDecl float64
+	cosDecl float64
+	lat     float64
+}
+
+// Uses the sunrise equation from the NOAA solar calculations, which is accurate to a minute or so away from the poles
+func calculateSunriseSunset(date time.Time, latitude, longitude float64) *SunriseSunset {
+	year, month, day := date.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	julianDate := float64(midnight.Unix())/86400 + julianUnixEpoch
+
+	days := math.Ceil(julianDate - julian2000 + 0.0008)
+	meanSolarTime := days - longitude/360
+	anomaly := math.Mod(357.5291+0.98560028*meanSolarTime, 360)
+	anomalyRad := degreesToRadians(anomaly)
+	centre := 1.9148*math.Sin(anomalyRad) + 0.02*math.Sin(2*anomalyRad) + 0.0003*math.Sin(3*anomalyRad)
+	eclipticLongitude := degreesToRadians(math.Mod(anomaly+centre+180+102.9372, 360))
+	sinDecl := math.Sin(eclipticLongitude) * math.Sin(degreesToRadians(23.4397))
+
+	event := &sunEvent{
+		transit: julian2000 + meanSolarTime + 0.0053*math.Sin(anomalyRad) - 0.0069*math.Sin(2*eclipticLongitude),
+		sinDecl: sinDecl,
+		cosDecl: math.Cos(math.Asin(sinDecl)),
+		lat:     degreesToRadians(latitude),
+	}
+
+	out := &SunriseSunset{
+		SolarNoon: formatSunTime(event.transit),
+	}
+	out.Sunrise, out.Sunset = event.times(sunriseAltitude)
+	out.CivilTwilightBegin, out.CivilTwilightEnd = event.times(civilTwilightAltitude)
+	out.NauticalTwilightBegin, out.NauticalTwilightEnd = event.times(nauticalTwilightAltitude)
+	out.AstronomicalTwilightBegin, out.AstronomicalTwilightEnd = event.times(astronomicalTwilightAltitude)
+
+	cosHourAngle := event.cosHourAngle(sunriseAltitude)
+	switch {
+	case cosHourAngle > 1:
+		out.DayLength = 0
+	case cosHourAngle < -1:
+		out.DayLength = 24 * 60 * 60
+	default:
+		out.DayLength = int64(math.Round(radiansToDegrees(math.Acos(cosHourAngle)) / 180 * 24 * 60 * 60))
+	}
+	return out
+}
+
+func (event *sunEvent) cosHourAngle(altitude float64) float64 {
+	return (math.Sin(degreesToRadians(altitude)) - math.Sin(event.lat)*event.sinDecl) / (math.Cos(event.lat) * event.cosDecl)
+}
+
+func (event *sunEvent) times(altitude float64) (string, string) {
+	cosHourAngle := event.cosHourAngle(altitude)
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		// The sun never reaches this altitude today
+		return "", ""
+	}
+	hourAngle := radiansToDegrees(math.Acos(cosHourAngle))
+	return formatSunTime(event.transit - hourAngle/360), formatSunTime(event.transit + hourAngle/360)
+}
+
+func formatSunTime(julianDate float64) string {
+	seconds := (julianDate - julianUnixEpoch) * 86400
+	return time.Unix(int64(math.Round(seconds)), 0).UTC().Format(time.RFC3339)
+}
+
+func degreesToRadians(value float64) float64 {
+	return value * math.Pi / 180
+}
+
+func radiansToDegrees(value float64) float64 {
+	return value * 180 / math.Pi
+}
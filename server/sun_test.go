@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalculateSunriseSunset(t *testing.T) {
+	tests := []struct {
+		name          string
+		date          string
+		latitude      float64
+		longitude     float64
+		wantSunrise   string
+		wantSunset    string
+		wantDayLength int64
+	}{
+		{name: "London midsummer", date: "2024-06-21", latitude: 51.5074, longitude: -0.1278, wantSunrise: "2024-06-21T03:43:00Z", wantSunset: "2024-06-21T20:21:00Z", wantDayLength: 59880},
+		{name: "London midwinter", date: "2024-12-21", latitude: 51.5074, longitude: -0.1278, wantSunrise: "2024-12-21T08:04:00Z", wantSunset: "2024-12-21T15:53:00Z", wantDayLength: 28140},
+		{name: "Auckland midwinter", date: "2024-06-21", latitude: -36.8485, longitude: 174.7633, wantSunrise: "2024-06-20T19:33:00Z", wantSunset: "2024-06-21T05:11:00Z", wantDayLength: 34680},
+		{name: "Tromsø midnight sun", date: "2024-06-21", latitude: 69.6492, longitude: 18.9553, wantDayLength: 24 * 60 * 60},
+		{name: "Tromsø polar night", date: "2024-12-21", latitude: 69.6492, longitude: 18.9553, wantDayLength: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			date, _ := time.Parse("2006-01-02", test.date)
+			results := calculateSunriseSunset(date, test.latitude, test.longitude)
+			assertSunTime(t, "sunrise", results.Sunrise, test.wantSunrise)
+			assertSunTime(t, "sunset", results.Sunset, test.wantSunset)
+			if diff := results.DayLength - test.wantDayLength; diff > 120 || diff < -120 {
+				t.Fatalf("expected a day length of about %d seconds, got %d", test.wantDayLength, results.DayLength)
+			}
+		})
+	}
+}
+
+func TestCalculateSunriseSunsetTwilightDuringPolarNight(t *testing.T) {
+	date, _ := time.Parse("2006-01-02", "2024-12-21")
+	results := calculateSunriseSunset(date, 69.6492, 18.9553)
+	if results.CivilTwilightBegin == "" || results.CivilTwilightEnd == "" {
+		t.Fatalf("expected civil twilight even though the sun does not rise, got %+v", results)
+	}
+	if results.CivilTwilightBegin >= results.SolarNoon || results.CivilTwilightEnd <= results.SolarNoon {
+		t.Fatalf("expected civil twilight either side of solar noon, got %+v", results)
+	}
+}
+
+func assertSunTime(t *testing.T, name, got, want string) {
+	t.Helper()
+	if want == "" {
+		if got != "" {
+			t.Fatalf("expected no %s, got %s", name, got)
+		}
+		return
+	}
+
+	gotTime, err := time.Parse(time.RFC3339, got)
+	if err != nil {
+		t.Fatalf("unable to parse %s '%s': %v", name, got, err)
+	}
+	wantTime, _ := time.Parse(time.RFC3339, want)
+	if diff := gotTime.Sub(wantTime); diff > 2*time.Minute || diff < -2*time.Minute {
+		t.Fatalf("expected %s at about %s, got %s", name, want, got)
+	}
+}
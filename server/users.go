@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	userStoreFile         = "users.json"
+	adminPasswordFile     = "admin-password.txt"
+	defaultSessionTimeout = 24 * 60
+	defaultAdminName      = "admin"
+	tokenUsageSavePeriod  = 10 * time.Minute
+)
+
+type user struct {
+	Name         string   `json:"name"`
+	PasswordHash string   `json:"password"`
+	Roles        []string `json:"roles"`
+	IsDisabled   bool     `json:"disabled"`
+}
+
+type userDetails struct {
+	Name       string   `json:"name"`
+	Roles      []string `json:"roles"`
+	IsDisabled bool     `json:"disabled"`
+}
+
+type apiToken struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	User     string `json:"user"`
+	Hash     string `json:"hash,omitempty"`
+	Created  string `json:"created"`
+	LastUsed string `json:"lastUsed,omitempty"`
+
+	usageSaved time.Time
+}
+
+type userSession struct {
+	user    string
+	expires time.Time
+}
+
+type userStore struct {
+	Users  []*user     `json:"users"`
+	Tokens []*apiToken `json:"tokens"`
+
+	path           string
+	sessionTimeout time.Duration
+	sessions       map[string]*userSession
+	mutex          sync.Mutex
+}
+
+func loadUserStore(dataPath string, config *authenticationConfiguration) (*userStore, error) {
+	timeout := config.SessionTimeout
+	if timeout <= 0 {
+		timeout = defaultSessionTimeout
+	}
+
+	store := &userStore{
+		Users:          []*user{},
+		Tokens:         []*apiToken{},
+		path:           filepath.Join(dataPath, userStoreFile),
+		sessionTimeout: time.Duration(timeout) * time.Minute,
+		sessions:       map[string]*userSession{},
+	}
+
+	data, err := ioutil.ReadFile(store.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Unable to read user store: %v", err)
+	} else if err == nil {
+		if err = json.Unmarshal(data, store); err != nil {
+			return nil, fmt.Errorf("Unable to parse user store: %v", err)
+		}
+	}
+
+	if len(store.Users) == 0 {
+		password, err := generateSecret(12)
+		if err != nil {
+			return nil, err
+		}
+		if err = store.SetPassword(defaultAdminName, password); err != nil {
+			return nil, err
+		}
+		if err = store.SetRoles(defaultAdminName, []string{defaultAdminRole}, false); err != nil {
+			return nil, err
+		}
+		// The password is kept out of the log, which is often shared when reporting problems
+		passwordPath := filepath.Join(dataPath, adminPasswordFile)
+		if err = ioutil.WriteFile(passwordPath, []byte(password+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("Unable to write admin password: %v", err)
+		}
+		log.Printf("[Users] Created user '%s' with the password in %s - please change it", defaultAdminName, passwordPath)
+	} else if !store.hasRoles() {
+		// Users created before roles existed could do everything, so keep them that way
+		log.Printf("[Users] Granting %s role to existing users", defaultAdminRole)
+		for _, item := range store.Users {
+			item.Roles = []string{defaultAdminRole}
+		}
+		if err = store.save(); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+func (store *userStore) save() error {
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Unable to generate user store: %v", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(store.path), 0700); err != nil {
+		return fmt.Errorf("Unable to create data path: %v", err)
+	}
+	temp := store.path + ".tmp"
+	if err = ioutil.WriteFile(temp, data, 0600); err != nil {
+		return fmt.Errorf("Unable to write user store: %v", err)
+	}
+	return os.Rename(temp, store.path)
+}
+
+func (store *userStore) find(name string) *user {
+	for _, item := range store.Users {
+		if item.Name == name {
+			return item
+		}
+	}
+	return nil
+}
+
+func (store *userStore) hasRoles() bool {
+	for _, item := range store.Users {
+		if len(item.Roles) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (store *userStore) ListUsers() []userDetails {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	out := make([]userDetails, len(store.Users))
+	for pos, item := range store.Users {
+		out[pos] = userDetails{
+			Name:       item.Name,
+			Roles:      item.Roles,
+			IsDisabled: item.IsDisabled,
+		}
+	}
+	return out
+}
+
+func (store *userStore) SetRoles(name string, roles []string, isDisabled bool) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	item := store.find(name)
+	if item == nil {
+		return fmt.Errorf("Unknown user '%s'", name)
+	}
+	item.Roles = roles
+	item.IsDisabled = isDisabled
+	return store.save()
+}
+
+func (store *userStore) SetPassword(name, password string) error {
+	if name == "" || len(password) < 8 {
+		return errors.New("A name and a password of at least 8 characters are required")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("Unable to hash password: %v", err)
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	item := store.find(name)
+	if item == nil {
+		item = &user{Name: name}
+		store.Users = append(store.Users, item)
+	}
+	item.PasswordHash = string(hash)
+	if err = store.save(); err != nil {
+		return err
+	}
+	if name == defaultAdminName {
+		os.Remove(filepath.Join(filepath.Dir(store.path), adminPasswordFile))
+	}
+	return nil
+}
+
+func (store *userStore) Authenticate(name, password string) (*user, error) {
+	store.mutex.Lock()
+	item := store.find(name)
+	store.mutex.Unlock()
+	if item == nil || item.IsDisabled {
+		return nil, errors.New("Unknown user")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(item.PasswordHash), []byte(password)); err != nil {
+		return nil, errors.New("Invalid password")
+	}
+	clone := *item
+	return &clone, nil
+}
+
+func (store *userStore) StartSession(name string) (string, time.Time, error) {
+	id, err := generateSecret(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	expires := time.Now().Add(store.sessionTimeout)
+	store.sessions[id] = &userSession{user: name, expires: expires}
+	return id, expires, nil
+}
+
+func (store *userStore) EndSession(id string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.sessions, id)
+}
+
+func (store *userStore) FindSession(id string) *user {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	session, ok := store.sessions[id]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(session.expires) {
+		delete(store.sessions, id)
+		return nil
+	}
+	return store.activeUser(session.user)
+}
+
+func (store *userStore) CreateToken(userName, tokenName string) (string, *apiToken, error) {
+	id, err := generateSecret(8)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := generateSecret(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := &apiToken{
+		ID:      id,
+		Name:    tokenName,
+		User:    userName,
+		Hash:    hashToken(secret),
+		Created: time.Now().Format(time.RFC3339),
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.Tokens = append(store.Tokens, token)
+	if err = store.save(); err != nil {
+		return "", nil, err
+	}
+	clone := *token
+	clone.Hash = ""
+	return secret, &clone, nil
+}
+
+func (store *userStore) FindToken(secret string) *user {
+	hash := hashToken(secret)
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, token := range store.Tokens {
+		if token.Hash == hash {
+			now := time.Now()
+			token.LastUsed = now.Format(time.RFC3339)
+			// Saving on every request would rewrite the store constantly, so usage is only saved every few minutes
+			if now.Sub(token.usageSaved) >= tokenUsageSavePeriod {
+				token.usageSaved = now
+				if err := store.save(); err != nil {
+					log.Printf("[Users] Unable to save token usage: %v", err)
+				}
+			}
+			return store.activeUser(token.User)
+		}
+	}
+	return nil
+}
+
+func (store *userStore) ListTokens(userName string) []apiToken {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	out := []apiToken{}
+	for _, token := range store.Tokens {
+		if token.User == userName {
+			clone := *token
+			clone.Hash = ""
+			out = append(out, clone)
+		}
+	}
+	return out
+}
+
+func (store *userStore) RevokeToken(userName, id string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for pos, token := range store.Tokens {
+		if token.ID == id && token.User == userName {
+			store.Tokens = append(store.Tokens[:pos], store.Tokens[pos+1:]...)
+			return true, store.save()
+		}
+	}
+	return false, nil
+}
+
+func (store *userStore) activeUser(name string) *user {
+	item := store.find(name)
+	if item == nil || item.IsDisabled {
+		return nil
+	}
+	clone := *item
+	return &clone
+}
+
+func hashToken(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func generateSecret(size int) (string, error) {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return "", fmt.Errorf("Unable to generate secret: %v", err)
+	}
+	return hex.EncodeToString(data), nil
+}
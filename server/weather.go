@@ -4,184 +4,520 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
 type weatherService struct {
-	current       *CurrentWeather
-	forecast      *WeatherForecast
-	sunriseSunset *SunriseSunset
-	downloaded    time.Time
-	mutex         sync.Mutex
-	isRunning     bool
-	stopRequest   chan int
-	stopReply     chan int
-}
-
-func (service *weatherService) Start(config *weatherConfiguration) error {
-	if service.isRunning {
+	provider    weatherProvider
+	client      *http.Client
+	history     *weatherHistory
+	source      string
+	current     *CurrentWeather
+	forecast    *WeatherForecast
+	location    *WeatherLocation
+	isLocated   bool
+	dataPath    string
+	downloaded  time.Time
+	endpoints   []*weatherEndpoint
+	alerts      weatherAlerter
+	counter     int64
+	listeners   map[monitorListener]bool
+	mutex       sync.Mutex
+	isRunning   bool
+	stopRequest chan int
+	stopReply   chan int
+}
+
+type weatherEndpoint struct {
+	name        string
+	period      time.Duration
+	fetch       func() error
+	lastAttempt time.Time
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+	failures    int
+	next        time.Time
+}
+
+type weatherEndpointStatus struct {
+	Name        string `json:"name"`
+	LastAttempt string `json:"lastAttempt,omitempty"`
+	LastSuccess string `json:"lastSuccess,omitempty"`
+	LastFailure string `json:"lastFailure,omitempty"`
+	LastError   string `json:"lastError,omitempty"`
+	Failures    int    `json:"failures"`
+	Age         *int64 `json:"age,omitempty"`
+	NextAttempt string `json:"nextAttempt,omitempty"`
+}
+
+type weatherStatus struct {
+	Provider    string                  `json:"provider"`
+	IsRunning   bool                    `json:"running"`
+	LastSuccess string                  `json:"lastSuccess,omitempty"`
+	Age         *int64                  `json:"age,omitempty"`
+	Endpoints   []weatherEndpointStatus `json:"endpoints"`
+}
+
+const (
+	sunriseSunsetTolerance = 5 * time.Minute
+
+	defaultWeatherRefresh = 60
+	defaultWeatherTimeout = 20
+	weatherRetryDelay     = 30 * time.Second
+	weatherMaxBackoff     = 16
+	sunriseSunsetPeriod   = 24 * time.Hour
+	weatherLocationFile   = "weather-location.json"
+)
+
+func (service *weatherService) AddListener(listener monitorListener) {
+	if service.listeners == nil {
+		service.listeners = map[monitorListener]bool{}
+	}
+	service.listeners[listener] = true
+}
+
+func (service *weatherService) RemoveListener(listener monitorListener) {
+	if service.listeners == nil {
+		return
+	}
+	delete(service.listeners, listener)
+}
+
+func (service *weatherService) AddAlertListener(listener weatherAlertListener) {
+	service.alerts.AddListener(listener)
+}
+
+func (service *weatherService) Alerts() *weatherAlerter {
+	return &service.alerts
+}
+
+func (service *weatherService) Start(config *weatherConfiguration, dataPath string) error {
+	if service.IsRunning() {
 		return nil
 	}
 
-	log.Printf("[Weather] Starting service")
+	timeOut := config.Timeout
+	if timeOut <= 0 {
+		timeOut = defaultWeatherTimeout
+	}
+	service.client = &http.Client{Timeout: time.Duration(timeOut) * time.Second}
+	provider, err := newWeatherProvider(config, service.client)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[Weather] Starting service using %s", provider.Name())
+	service.provider = provider
+	service.source = config.Source
+	if service.source == "" {
+		service.source = defaultWeatherSource
+	}
+	if service.history, err = newWeatherHistory(dataPath, config.History); err != nil {
+		log.Printf("[Weather] Not keeping weather history: %v", err)
+	}
+
+	service.alerts.SetRules(config.Alerts, dataPath)
+
+	refresh := config.RefreshPeriod
+	if refresh <= 0 {
+		refresh = defaultWeatherRefresh
+	}
+	period := time.Duration(refresh) * time.Minute
+	endpoints := []*weatherEndpoint{
+		{name: "current", period: period, fetch: service.downloadCurrent},
+		{name: "forecast", period: period, fetch: service.downloadForecast},
+	}
+	if config.SunriseSunsetURL != "" {
+		endpoints = append(endpoints, &weatherEndpoint{
+			name:   "sun",
+			period: sunriseSunsetPeriod,
+			fetch: func() error {
+				return service.checkSunriseSunset(config)
+			},
+		})
+	}
+
+	service.mutex.Lock()
+	service.endpoints = endpoints
+	service.dataPath = dataPath
+	service.isLocated = config.Latitude != 0 || config.Longitude != 0
+	if service.isLocated {
+		service.location = &WeatherLocation{Latitude: config.Latitude, Longitude: config.Longitude}
+	} else {
+		// Sunrise and sunset can still be calculated when the provider cannot be reached at startup
+		service.location = loadWeatherLocation(dataPath)
+	}
+	service.mutex.Unlock()
 	service.stopRequest = make(chan int)
-	service.stopReply = make(chan int)
+	service.stopReply = make(chan int, 1)
 
-	go service.process(true, config)
+	go service.process()
+	service.mutex.Lock()
 	service.isRunning = true
+	service.mutex.Unlock()
 	return nil
 }
 
 func (service *weatherService) Stop(timeOut time.Duration) error {
-	if !service.isRunning {
+	service.mutex.Lock()
+	isRunning := service.isRunning
+	service.isRunning = false
+	service.mutex.Unlock()
+	if !isRunning {
 		return nil
 	}
 
 	log.Printf("[Weather] Stopping service")
-	service.isRunning = false
-	service.stopRequest <- 1
+	deadline := time.After(timeOut)
+	select {
+	case service.stopRequest <- 1:
+	case <-deadline:
+		return errors.New("Stop weather service timed out")
+	}
 	select {
 	case <-service.stopReply:
 		return nil
-	case <-time.After(timeOut):
+	case <-deadline:
 		return errors.New("Stop weather service timed out")
 	}
 }
 
-func (service *weatherService) process(isFirst bool, config *weatherConfiguration) {
+func (service *weatherService) process() {
+	service.replayHistory()
+	for {
+		wait := service.Download()
+		select {
+		case <-service.stopRequest:
+			service.stopReply <- 1
+			return
 
-	if isFirst {
-		service.Download(config)
+		case <-time.After(wait):
+		}
 	}
+}
 
-	stop := false
-	select {
-	case <-service.stopRequest:
-		service.stopReply <- 1
-		stop = true
-
-	case <-time.After(time.Duration(int64(time.Minute) * config.RefreshPeriod)):
-		err := service.Download(config)
-		if err != nil {
-			log.Printf("[Weather] Unable to update weather information: %v", err)
-		} else {
-			log.Printf("[Weather] Weather information updated")
-		}
+// The API asks while the service is being started or stopped, so the flag is only read under the lock
+func (service *weatherService) IsRunning() bool {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	return service.isRunning
+}
+
+func (service *weatherService) SourceName() string {
+	if !service.IsRunning() {
+		return ""
 	}
-	if !stop {
-		service.process(false, config)
+	return service.source
+}
+
+func (service *weatherService) History() *weatherHistory {
+	return service.history
+}
+
+func (service *weatherService) replayHistory() {
+	if service.history == nil {
+		return
+	}
+
+	service.history.Prune()
+	observations, err := service.history.Observations(time.Time{}, time.Time{})
+	if err != nil {
+		log.Printf("[Weather] Unable to read weather history: %v", err)
+		return
+	}
+	if len(observations) > storeSize {
+		observations = observations[len(observations)-storeSize:]
+	}
+	log.Printf("[Weather] Loading %d observations from history", len(observations))
+	for _, item := range observations {
+		service.publish(&item.Conditions)
+	}
+}
+
+func (service *weatherService) publish(conditions *WeatherConditions) {
+	result := &monitorResult{
+		Source:    service.source,
+		TimeStamp: conditions.Time.Format(time.RFC3339),
+		Counter:   service.counter,
+		Values: []monitorResultValue{
+			{Name: "Temperature", Value: float32(conditions.Temperature)},
+			{Name: "Humidity", Value: float32(conditions.Humidity)},
+			{Name: "Pressure", Value: float32(conditions.Pressure)},
+		},
+	}
+	service.counter++
+	for listener := range service.listeners {
+		listener <- result
 	}
 }
 
 func (service *weatherService) GetCurrentWeather() *CurrentWeather {
 	service.mutex.Lock()
 	defer service.mutex.Unlock()
-	weather := service.current
-	clone := *weather
+	if service.current == nil {
+		return nil
+	}
+	clone := *service.current
 	return &clone
 }
 
 func (service *weatherService) GetWeatherForecast() *WeatherForecast {
 	service.mutex.Lock()
 	defer service.mutex.Unlock()
-	forecast := service.forecast
-	clone := *forecast
+	if service.forecast == nil {
+		return nil
+	}
+	clone := *service.forecast
 	return &clone
 }
 
-func (service *weatherService) GetSunriseSunset() *SunriseSunset {
+func (service *weatherService) GetSunriseSunset(date time.Time) *SunriseSunset {
+	service.mutex.Lock()
+	location := service.location
+	service.mutex.Unlock()
+	if location == nil {
+		return nil
+	}
+	return calculateSunriseSunset(date, location.Latitude, location.Longitude)
+}
+
+func (service *weatherService) Downloaded() time.Time {
 	service.mutex.Lock()
 	defer service.mutex.Unlock()
-	light := service.sunriseSunset
-	clone := *light
-	return &clone
+	return service.downloaded
 }
 
-func (service *weatherService) Download(config *weatherConfiguration) error {
-	log.Printf("[Weather] Downloading weather information")
-	weather, err := service.downloadCurrent(config.LocationCode, config)
-	if err != nil {
-		return err
+func formatOptionalTime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.Format(time.RFC3339)
+}
+
+func ageInSeconds(value, now time.Time) *int64 {
+	if value.IsZero() {
+		return nil
+	}
+	age := int64(now.Sub(value).Seconds())
+	return &age
+}
+
+func (service *weatherService) Status() *weatherStatus {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	if service.provider == nil {
+		return nil
+	}
+
+	now := time.Now()
+	out := &weatherStatus{
+		Provider:    service.provider.Name(),
+		IsRunning:   service.isRunning,
+		LastSuccess: formatOptionalTime(service.downloaded),
+		Age:         ageInSeconds(service.downloaded, now),
+		Endpoints:   make([]weatherEndpointStatus, 0, len(service.endpoints)),
+	}
+	for _, endpoint := range service.endpoints {
+		out.Endpoints = append(out.Endpoints, weatherEndpointStatus{
+			Name:        endpoint.name,
+			LastAttempt: formatOptionalTime(endpoint.lastAttempt),
+			LastSuccess: formatOptionalTime(endpoint.lastSuccess),
+			LastFailure: formatOptionalTime(endpoint.lastFailure),
+			LastError:   endpoint.lastError,
+			Failures:    endpoint.failures,
+			Age:         ageInSeconds(endpoint.lastSuccess, now),
+			NextAttempt: formatOptionalTime(endpoint.next),
+		})
+	}
+	return out
+}
+
+func (service *weatherService) Download() time.Duration {
+	service.mutex.Lock()
+	endpoints := service.endpoints
+	service.mutex.Unlock()
+
+	now := time.Now()
+	for _, endpoint := range endpoints {
+		if !endpoint.next.After(now) {
+			service.fetch(endpoint)
+		}
+	}
+
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	wait := time.Duration(0)
+	for pos, endpoint := range endpoints {
+		if until := time.Until(endpoint.next); pos == 0 || until < wait {
+			wait = until
+		}
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+func (service *weatherService) fetch(endpoint *weatherEndpoint) {
+	start := time.Now()
+	err := endpoint.fetch()
+
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	endpoint.lastAttempt = start
+	if err == nil {
+		weatherDownloads.WithLabelValues("success").Inc()
+		endpoint.lastSuccess = start
+		endpoint.failures = 0
+		endpoint.next = start.Add(endpoint.period)
+		return
 	}
 
-	forecast, err := service.downloadForecast(config.LocationCode, config)
+	weatherDownloads.WithLabelValues("failure").Inc()
+	endpoint.lastFailure = start
+	endpoint.lastError = err.Error()
+	endpoint.failures++
+	delay := endpoint.period
+	if endpoint.failures <= weatherMaxBackoff {
+		if backOff := weatherRetryDelay << uint(endpoint.failures-1); backOff < delay {
+			delay = backOff
+		}
+	}
+	endpoint.next = start.Add(delay)
+	log.Printf("[Weather] Unable to update %s weather, retrying in %v: %v", endpoint.name, delay, err)
+}
+
+func (service *weatherService) downloadCurrent() error {
+	weather, err := service.provider.Current()
 	if err != nil {
 		return err
 	}
 
-	log.Printf("[Weather] Downloading sunrise and sunset information")
-	sunriseSunset, err := service.downloadSunriseSunset(weather.Coordinates, config)
+	log.Printf("[Weather] Storing current weather")
+	service.mutex.Lock()
+	service.current = weather
+	service.downloaded = time.Now()
+	isMoved := !service.isLocated && (service.location == nil || *service.location != weather.Location)
+	if isMoved {
+		service.location = &weather.Location
+	}
+	service.mutex.Unlock()
+
+	if isMoved {
+		if err := saveWeatherLocation(service.dataPath, &weather.Location); err != nil {
+			log.Printf("[Weather] %v", err)
+		}
+	}
+
+	if service.history != nil {
+		service.history.Record(service.provider.Name(), weather, nil)
+	}
+	service.publish(&weather.Conditions)
+	return nil
+}
+
+func (service *weatherService) downloadForecast() error {
+	forecast, err := service.provider.Forecast()
 	if err != nil {
 		return err
 	}
 
-	log.Printf("[Weather] Storing weather, sunrise and sunset")
+	log.Printf("[Weather] Storing weather forecast")
 	service.mutex.Lock()
-	defer service.mutex.Unlock()
-	service.current = weather
 	service.forecast = forecast
-	service.sunriseSunset = sunriseSunset
 	service.downloaded = time.Now()
+	service.mutex.Unlock()
 
+	if service.history != nil {
+		service.history.Record(service.provider.Name(), nil, forecast)
+	}
+	service.alerts.Check(forecast, time.Now())
 	return nil
 }
 
-func (service *weatherService) downloadCurrent(cityCode string, config *weatherConfiguration) (*CurrentWeather, error) {
-	log.Printf("[Weather] Downloading current weather")
-	client := http.Client{}
-	resp, err := client.Get(config.BaseURL + "weather?id=" + cityCode + "&units=metric&APPID=" + config.APIKey)
+func loadWeatherLocation(dataPath string) *WeatherLocation {
+	data, err := ioutil.ReadFile(filepath.Join(dataPath, weatherLocationFile))
 	if err != nil {
-		log.Printf("[Weather] Unable to download current weather: %v", err)
-		return nil, err
+		if !os.IsNotExist(err) {
+			log.Printf("[Weather] Unable to read last known location: %v", err)
+		}
+		return nil
 	}
-	defer resp.Body.Close()
+	location := &WeatherLocation{}
+	if err = json.Unmarshal(data, location); err != nil {
+		log.Printf("[Weather] Unable to parse last known location: %v", err)
+		return nil
+	}
+	log.Printf("[Weather] Using last known location %.4f, %.4f", location.Latitude, location.Longitude)
+	return location
+}
 
-	decoder := json.NewDecoder(resp.Body)
-	var weather CurrentWeather
-	err = decoder.Decode(&weather)
+func saveWeatherLocation(dataPath string, location *WeatherLocation) error {
+	data, err := json.Marshal(location)
 	if err != nil {
-		log.Printf("[Weather] Unable to parse current weather: %v", err)
-		return nil, err
+		return fmt.Errorf("Unable to generate location: %v", err)
 	}
-
-	return &weather, nil
+	if err = os.MkdirAll(dataPath, 0700); err != nil {
+		return fmt.Errorf("Unable to create data path: %v", err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dataPath, weatherLocationFile), data, 0600); err != nil {
+		return fmt.Errorf("Unable to save location: %v", err)
+	}
+	return nil
 }
 
-func (service *weatherService) downloadForecast(cityCode string, config *weatherConfiguration) (*WeatherForecast, error) {
-	log.Printf("[Weather] Downloading weather forecast")
-	client := http.Client{}
-	resp, err := client.Get(config.BaseURL + "forecast?id=" + cityCode + "&units=metric&APPID=" + config.APIKey)
-	if err != nil {
-		log.Printf("[Weather] Unable to download weather forecast: %v", err)
-		return nil, err
+func (service *weatherService) checkSunriseSunset(config *weatherConfiguration) error {
+	service.mutex.Lock()
+	location := service.location
+	service.mutex.Unlock()
+	if location == nil {
+		return errors.New("The location is not known yet")
 	}
-	defer resp.Body.Close()
 
-	decoder := json.NewDecoder(resp.Body)
-	var forecast WeatherForecast
-	err = decoder.Decode(&forecast)
+	now := time.Now()
+	remote, err := service.downloadSunriseSunset(*location, now, config)
 	if err != nil {
-		log.Printf("[Weather] Unable to parse weather forecast: %v", err)
-		return nil, err
+		return fmt.Errorf("Unable to check sunrise and sunset: %v", err)
 	}
 
-	return &forecast, nil
+	local := calculateSunriseSunset(now, location.Latitude, location.Longitude)
+	compare := func(name, localTime, remoteTime string) {
+		first, errLocal := time.Parse(time.RFC3339, localTime)
+		second, errRemote := time.Parse(time.RFC3339, remoteTime)
+		if errLocal != nil || errRemote != nil {
+			return
+		}
+		if diff := first.Sub(second); diff > sunriseSunsetTolerance || diff < -sunriseSunsetTolerance {
+			log.Printf("[Weather] WARNING: Calculated %s of %s differs from %s by %v", name, localTime, remoteTime, diff)
+		}
+	}
+	compare("sunrise", local.Sunrise, remote.Sunrise)
+	compare("sunset", local.Sunset, remote.Sunset)
+	return nil
 }
 
-func (service *weatherService) downloadSunriseSunset(coordinates WeatherCoordinates, config *weatherConfiguration) (*SunriseSunset, error) {
+func (service *weatherService) downloadSunriseSunset(location WeatherLocation, date time.Time, config *weatherConfiguration) (*SunriseSunset, error) {
 	log.Printf("[Weather] Downloading sunrise and sunset")
-	client := http.Client{}
-	url := fmt.Sprintf(config.SunriseSunsetURL+"?lat=%f&lng=%f&formatted=0", coordinates.Latitude, coordinates.Longitude)
-	resp, err := client.Get(url)
+	url := fmt.Sprintf(config.SunriseSunsetURL+"?lat=%f&lng=%f&date=%s&formatted=0", location.Latitude, location.Longitude, date.Format("2006-01-02"))
+	resp, err := service.client.Get(url)
 	if err != nil {
 		log.Printf("[Weather] Unable to download sunrise and sunset: %v", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[Weather] Unable to download sunrise and sunset: status %d", resp.StatusCode)
+		return nil, fmt.Errorf("Received status %d", resp.StatusCode)
+	}
+
 	decoder := json.NewDecoder(resp.Body)
 	var results struct {
 		Results SunriseSunset `json:"results"`
@@ -195,62 +531,21 @@ func (service *weatherService) downloadSunriseSunset(coordinates WeatherCoordina
 
 	if results.Status != "OK" {
 		log.Printf("[Weather] Invalid sunrise and sunset: status=%s", results.Status)
-		return nil, err
+		return nil, fmt.Errorf("Received status %s", results.Status)
 	}
 
 	return &results.Results, nil
 }
 
-type WeatherCoordinates struct {
-	Longitude float32 `json:"lon"`
-	Latitude  float32 `json:"lat"`
-}
-
-type CurrentWeather struct {
-	Coordinates WeatherCoordinates   `json:"coord"`
-	Weather     []WeatherInformation `json:"weather"`
-	Main        MainInformation      `json:"main"`
-	Wind        WindInformation      `json:"wind"`
-	System      struct {
-		Sunrise int64 `json:"sunrise"`
-		Sunset  int64 `json:"sunset"`
-	} `json:"sys"`
-}
-
-type WeatherForecast struct {
-	List []ThreeHourForecast `json:"list"`
-}
-
-type ThreeHourForecast struct {
-	Weather     []WeatherInformation `json:"weather"`
-	Main        MainInformation      `json:"main"`
-	Wind        WindInformation      `json:"wind"`
-	DateAndTime string               `json:"dt_txt"`
-}
-
-type WeatherInformation struct {
-	Main        string `json:"main"`
-	Description string `json:"description"`
-}
-
-type MainInformation struct {
-	Temperature        float64 `json:"temp"`
-	Pressure           float64 `json:"pressure"`
-	Humidity           float64 `json:"humidity"`
-	MinimumTemperature float64 `json:"temp_min"`
-	MaximumTemperature float64 `json:"temp_max"`
-}
-
-type WindInformation struct {
-	Speed     float64 `json:"speed"`
-	Direction float64 `json:"deg"`
-}
-
 type SunriseSunset struct {
 	AstronomicalTwilightBegin string `json:"astronomical_twilight_begin"`
+	NauticalTwilightBegin     string `json:"nautical_twilight_begin"`
 	CivilTwilightBegin        string `json:"civil_twilight_begin"`
 	Sunrise                   string `json:"sunrise"`
+	SolarNoon                 string `json:"solar_noon"`
 	Sunset                    string `json:"sunset"`
 	CivilTwilightEnd          string `json:"civil_twilight_end"`
+	NauticalTwilightEnd       string `json:"nautical_twilight_end"`
 	AstronomicalTwilightEnd   string `json:"astronomical_twilight_end"`
+	DayLength                 int64  `json:"day_length"`
 }
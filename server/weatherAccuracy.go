@@ -0,0 +1,212 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const weatherMatchTolerance = 90 * time.Minute
+
+var forecastHorizons = []int{3, 6, 12, 24, 48, 72, 120}
+
+type timedValue struct {
+	Time  time.Time
+	Value float64
+}
+
+type accuracyStatistics struct {
+	Count       int     `json:"count"`
+	Bias        float64 `json:"bias"`
+	MeanError   float64 `json:"mae"`
+	RootMeanSqr float64 `json:"rmse"`
+
+	sum    float64
+	sumAbs float64
+	sumSqr float64
+}
+
+type rainStatistics struct {
+	Count    int     `json:"count"`
+	HitRate  float64 `json:"hitRate"`
+	Missed   int     `json:"missed"`
+	FalseHit int     `json:"falseAlarms"`
+
+	hits int
+}
+
+type horizonAccuracy struct {
+	From    int                            `json:"from"`
+	To      int                            `json:"to"`
+	Metrics map[string]*accuracyStatistics `json:"metrics"`
+	Rain    *rainStatistics                `json:"rain,omitempty"`
+}
+
+func (stats *accuracyStatistics) add(diff float64) {
+	stats.Count++
+	stats.sum += diff
+	stats.sumAbs += math.Abs(diff)
+	stats.sumSqr += diff * diff
+}
+
+func (stats *accuracyStatistics) finish() {
+	if stats.Count == 0 {
+		return
+	}
+	count := float64(stats.Count)
+	stats.Bias = stats.sum / count
+	stats.MeanError = stats.sumAbs / count
+	stats.RootMeanSqr = math.Sqrt(stats.sumSqr / count)
+}
+
+func (stats *rainStatistics) add(forecast, observed bool) {
+	stats.Count++
+	switch {
+	case forecast == observed:
+		stats.hits++
+	case observed:
+		stats.Missed++
+	default:
+		stats.FalseHit++
+	}
+	stats.HitRate = float64(stats.hits) / float64(stats.Count)
+}
+
+func forecastValue(item *WeatherConditions, metric string) float64 {
+	switch metric {
+	case "temperature":
+		return item.Temperature
+	case "humidity":
+		return item.Humidity
+	case "pressure":
+		return item.Pressure
+	case "windSpeed":
+		return item.WindSpeed
+	}
+	return 0
+}
+
+func observationSeries(observations []weatherObservation) map[string][]timedValue {
+	out := map[string][]timedValue{}
+	for _, item := range observations {
+		conditions := item.Conditions
+		out["temperature"] = append(out["temperature"], timedValue{conditions.Time, conditions.Temperature})
+		out["humidity"] = append(out["humidity"], timedValue{conditions.Time, conditions.Humidity})
+		out["pressure"] = append(out["pressure"], timedValue{conditions.Time, conditions.Pressure})
+		out["windSpeed"] = append(out["windSpeed"], timedValue{conditions.Time, conditions.WindSpeed})
+		out["precipitation"] = append(out["precipitation"], timedValue{conditions.Time, conditions.Precipitation})
+	}
+	return out
+}
+
+// Sensor readings are only kept in memory, so they can only grade forecasts for the period since the earliest one
+func sensorSeries(results []monitorResult, config *outdoorSensorConfiguration) (map[string][]timedValue, time.Time) {
+	out := map[string][]timedValue{}
+	names := map[string]string{
+		config.Temperature: "temperature",
+		config.Humidity:    "humidity",
+		config.Pressure:    "pressure",
+	}
+	delete(names, "")
+	var earliest time.Time
+	for _, result := range results {
+		timeStamp, err := time.Parse(time.RFC3339, result.TimeStamp)
+		if err != nil {
+			continue
+		}
+		if earliest.IsZero() || timeStamp.Before(earliest) {
+			earliest = timeStamp
+		}
+		for _, value := range result.Values {
+			if metric, ok := names[value.Name]; ok {
+				out[metric] = append(out[metric], timedValue{timeStamp, float64(value.Value)})
+			}
+		}
+	}
+	return out, earliest
+}
+
+func closestValue(series []timedValue, target time.Time) (float64, bool) {
+	pos := sort.Search(len(series), func(index int) bool {
+		return !series[index].Time.Before(target)
+	})
+	best, found := 0.0, false
+	bestDiff := weatherMatchTolerance + 1
+	for _, index := range []int{pos - 1, pos} {
+		if index < 0 || index >= len(series) {
+			continue
+		}
+		diff := series[index].Time.Sub(target)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff <= weatherMatchTolerance && diff < bestDiff {
+			best, bestDiff, found = series[index].Value, diff, true
+		}
+	}
+	return best, found
+}
+
+func calculateForecastAccuracy(forecasts []weatherForecastRecord, reference map[string][]timedValue) []*horizonAccuracy {
+	for _, series := range reference {
+		sort.Slice(series, func(first, second int) bool {
+			return series[first].Time.Before(series[second].Time)
+		})
+	}
+
+	out := make([]*horizonAccuracy, len(forecastHorizons))
+	previous := 0
+	for pos, hours := range forecastHorizons {
+		out[pos] = &horizonAccuracy{From: previous, To: hours, Metrics: map[string]*accuracyStatistics{}}
+		if _, ok := reference["precipitation"]; ok {
+			out[pos].Rain = &rainStatistics{}
+		}
+		previous = hours
+	}
+
+	for _, record := range forecasts {
+		for pos := range record.Items {
+			item := &record.Items[pos]
+			horizon := findHorizon(out, item.Time.Sub(record.Retrieved))
+			if horizon == nil {
+				continue
+			}
+			for metric, series := range reference {
+				observed, ok := closestValue(series, item.Time)
+				if !ok {
+					continue
+				}
+				if metric == "precipitation" {
+					horizon.Rain.add(item.Precipitation > 0 || item.PrecipitationChance >= 0.5, observed > 0)
+					continue
+				}
+				stats, ok := horizon.Metrics[metric]
+				if !ok {
+					stats = &accuracyStatistics{}
+					horizon.Metrics[metric] = stats
+				}
+				stats.add(forecastValue(item, metric) - observed)
+			}
+		}
+	}
+
+	for _, horizon := range out {
+		for _, stats := range horizon.Metrics {
+			stats.finish()
+		}
+	}
+	return out
+}
+
+func findHorizon(horizons []*horizonAccuracy, ahead time.Duration) *horizonAccuracy {
+	if ahead < 0 {
+		return nil
+	}
+	hours := ahead.Hours()
+	for _, horizon := range horizons {
+		if hours < float64(horizon.To) {
+			return horizon
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestClosestValue(t *testing.T) {
+	base := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	series := []timedValue{
+		{base, 10},
+		{base.Add(time.Hour), 11},
+		{base.Add(3 * time.Hour), 13},
+	}
+
+	tests := []struct {
+		name      string
+		target    time.Time
+		want      float64
+		wantFound bool
+	}{
+		{name: "exact", target: base.Add(time.Hour), want: 11, wantFound: true},
+		{name: "nearer the earlier value", target: base.Add(20 * time.Minute), want: 10, wantFound: true},
+		{name: "nearer the later value", target: base.Add(40 * time.Minute), want: 11, wantFound: true},
+		{name: "before the series", target: base.Add(-time.Hour), want: 10, wantFound: true},
+		{name: "after the series", target: base.Add(4 * time.Hour), want: 13, wantFound: true},
+		{name: "outside the tolerance", target: base.Add(-2 * time.Hour), wantFound: false},
+		{name: "gap wider than the tolerance", target: base.Add(2 * time.Hour), want: 11, wantFound: true},
+		{name: "far after the series", target: base.Add(5 * time.Hour), wantFound: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, found := closestValue(series, test.target)
+			if found != test.wantFound {
+				t.Fatalf("expected found %t, got %t", test.wantFound, found)
+			}
+			if found && value != test.want {
+				t.Fatalf("expected %g, got %g", test.want, value)
+			}
+		})
+	}
+
+	if _, found := closestValue(nil, base); found {
+		t.Fatalf("expected nothing from an empty series")
+	}
+}
+
+func TestFindHorizon(t *testing.T) {
+	horizons := []*horizonAccuracy{{From: 0, To: 3}, {From: 3, To: 6}, {From: 6, To: 12}}
+	tests := []struct {
+		ahead  time.Duration
+		wantTo int
+	}{
+		{ahead: 0, wantTo: 3},
+		{ahead: 2*time.Hour + 59*time.Minute, wantTo: 3},
+		{ahead: 3 * time.Hour, wantTo: 6},
+		{ahead: 11 * time.Hour, wantTo: 12},
+		{ahead: 12 * time.Hour, wantTo: 0},
+		{ahead: -time.Hour, wantTo: 0},
+	}
+
+	for _, test := range tests {
+		horizon := findHorizon(horizons, test.ahead)
+		switch {
+		case test.wantTo == 0 && horizon != nil:
+			t.Errorf("%v ahead: expected no horizon, got %d-%d", test.ahead, horizon.From, horizon.To)
+		case test.wantTo != 0 && (horizon == nil || horizon.To != test.wantTo):
+			t.Errorf("%v ahead: expected the horizon ending at %d, got %+v", test.ahead, test.wantTo, horizon)
+		}
+	}
+}
+
+func TestCalculateForecastAccuracy(t *testing.T) {
+	retrieved := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	forecasts := []weatherForecastRecord{{
+		Retrieved: retrieved,
+		Items: []WeatherConditions{
+			{Time: retrieved.Add(time.Hour), Temperature: 12, Precipitation: 1},
+			{Time: retrieved.Add(2 * time.Hour), Temperature: 14},
+			{Time: retrieved.Add(24 * time.Hour), Temperature: 20, PrecipitationChance: 0.8},
+			{Time: retrieved.Add(30 * 24 * time.Hour), Temperature: 5},
+		},
+	}}
+	reference := map[string][]timedValue{
+		"temperature": {
+			{retrieved.Add(24 * time.Hour), 18},
+			{retrieved.Add(2 * time.Hour), 15},
+			{retrieved.Add(time.Hour), 10},
+		},
+		"precipitation": {
+			{retrieved.Add(time.Hour), 0},
+			{retrieved.Add(2 * time.Hour), 0},
+			{retrieved.Add(24 * time.Hour), 2},
+		},
+	}
+
+	horizons := calculateForecastAccuracy(forecasts, reference)
+	if len(horizons) != len(forecastHorizons) {
+		t.Fatalf("expected %d horizons, got %d", len(forecastHorizons), len(horizons))
+	}
+
+	first := horizons[0].Metrics["temperature"]
+	if first == nil || first.Count != 2 {
+		t.Fatalf("expected two temperatures in the first horizon, got %+v", first)
+	}
+	// Errors of +2 and -1
+	assertClose(t, "bias", first.Bias, 0.5)
+	assertClose(t, "mae", first.MeanError, 1.5)
+	assertClose(t, "rmse", first.RootMeanSqr, math.Sqrt(2.5))
+	if rain := horizons[0].Rain; rain.Count != 2 || rain.FalseHit != 1 || rain.Missed != 0 {
+		t.Fatalf("expected one false alarm from two rain forecasts, got %+v", rain)
+	}
+
+	day := findHorizon(horizons, 24*time.Hour)
+	if stats := day.Metrics["temperature"]; stats == nil || stats.Count != 1 || stats.Bias != 2 {
+		t.Fatalf("expected one temperature 2° too high a day ahead, got %+v", stats)
+	}
+	if day.Rain.Count != 1 || day.Rain.HitRate != 1 {
+		t.Fatalf("expected rain a day ahead to be a hit, got %+v", day.Rain)
+	}
+}
+
+func TestSensorSeries(t *testing.T) {
+	config := &outdoorSensorConfiguration{Source: "Garden", Temperature: "Temp", Humidity: "Humidity"}
+	results := []monitorResult{
+		{TimeStamp: "2026-10-19T12:00:00Z", Values: []monitorResultValue{{Name: "Temp", Value: 15}, {Name: "Light", Value: 300}}},
+		{TimeStamp: "2026-10-19T11:00:00Z", Values: []monitorResultValue{{Name: "Temp", Value: 14}, {Name: "Humidity", Value: 70}}},
+		{TimeStamp: "not a time", Values: []monitorResultValue{{Name: "Temp", Value: 99}}},
+	}
+
+	series, earliest := sensorSeries(results, config)
+	if len(series["temperature"]) != 2 || len(series["humidity"]) != 1 {
+		t.Fatalf("expected two temperatures and one humidity, got %+v", series)
+	}
+	if _, ok := series["pressure"]; ok {
+		t.Fatalf("expected no pressure without a configured sensor")
+	}
+	if want := time.Date(2026, 10, 19, 11, 0, 0, 0, time.UTC); !earliest.Equal(want) {
+		t.Fatalf("expected the series to start at %v, got %v", want, earliest)
+	}
+}
+
+func assertClose(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Fatalf("expected %s %g, got %g", name, want, got)
+	}
+}
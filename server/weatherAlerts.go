@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	weatherAlertRaised  = "raised"
+	weatherAlertCleared = "cleared"
+
+	defaultAlertSeverity    = "warning"
+	weatherAlertHistorySize = 50
+	weatherAlertsFile       = "alerts.json"
+)
+
+type weatherAlert struct {
+	Name        string `json:"name"`
+	Severity    string `json:"severity"`
+	Message     string `json:"msg,omitempty"`
+	Explanation string `json:"explanation"`
+	Raised      string `json:"raised"`
+	Cleared     string `json:"cleared,omitempty"`
+}
+
+type weatherAlertEvent struct {
+	Event        string       `json:"event"`
+	Status       string       `json:"status"`
+	Alert        weatherAlert `json:"alert"`
+	Announcement string       `json:"announcement,omitempty"`
+	Language     string       `json:"language,omitempty"`
+}
+
+type weatherAlertListener chan<- *weatherAlertEvent
+
+type weatherAlerter struct {
+	rules     []weatherAlertConfiguration
+	active    map[string]*weatherAlert
+	recent    []weatherAlert
+	listeners map[weatherAlertListener]bool
+	path      string
+	mutex     sync.Mutex
+}
+
+func (alerter *weatherAlerter) AddListener(listener weatherAlertListener) {
+	alerter.mutex.Lock()
+	defer alerter.mutex.Unlock()
+	if alerter.listeners == nil {
+		alerter.listeners = map[weatherAlertListener]bool{}
+	}
+	alerter.listeners[listener] = true
+}
+
+func (alerter *weatherAlerter) RemoveListener(listener weatherAlertListener) {
+	alerter.mutex.Lock()
+	defer alerter.mutex.Unlock()
+	delete(alerter.listeners, listener)
+}
+
+// Raised alerts are kept with the weather history, so a restart does not announce them again
+func (alerter *weatherAlerter) SetRules(rules []weatherAlertConfiguration, dataPath string) {
+	alerter.mutex.Lock()
+	defer alerter.mutex.Unlock()
+	alerter.rules = rules
+	alerter.path = filepath.Join(dataPath, weatherHistoryPath, weatherAlertsFile)
+	alerter.active = map[string]*weatherAlert{}
+
+	saved := map[string]*weatherAlert{}
+	data, err := ioutil.ReadFile(alerter.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[Weather] Unable to read raised alerts: %v", err)
+		}
+		return
+	}
+	if err = json.Unmarshal(data, &saved); err != nil {
+		log.Printf("[Weather] Unable to parse raised alerts: %v", err)
+		return
+	}
+	for _, rule := range rules {
+		if alert, ok := saved[rule.Name]; ok {
+			alerter.active[rule.Name] = alert
+		}
+	}
+}
+
+func (alerter *weatherAlerter) saveActive() {
+	data, err := json.MarshalIndent(alerter.active, "", "  ")
+	if err != nil {
+		log.Printf("[Weather] Unable to generate raised alerts: %v", err)
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(alerter.path), 0700); err != nil {
+		log.Printf("[Weather] Unable to create weather history path: %v", err)
+		return
+	}
+	if err = ioutil.WriteFile(alerter.path, data, 0600); err != nil {
+		log.Printf("[Weather] Unable to write raised alerts: %v", err)
+	}
+}
+
+func (alerter *weatherAlerter) Check(forecast *WeatherForecast, now time.Time) {
+	alerter.mutex.Lock()
+	events := []*weatherAlertEvent{}
+	for _, rule := range alerter.rules {
+		matched, explanation, err := rule.Evaluate(forecast, now)
+		if err != nil {
+			log.Printf("[Weather] Unable to check %s alert: %v", rule.Name, err)
+			continue
+		}
+
+		alert, isActive := alerter.active[rule.Name]
+		switch {
+		case matched && !isActive:
+			severity := rule.Severity
+			if severity == "" {
+				severity = defaultAlertSeverity
+			}
+			alert = &weatherAlert{
+				Name:        rule.Name,
+				Severity:    severity,
+				Message:     rule.Message,
+				Explanation: explanation,
+				Raised:      now.Format(time.RFC3339),
+			}
+			alerter.active[rule.Name] = alert
+			log.Printf("[Weather] Raising %s alert: %s", rule.Name, explanation)
+			events = append(events, &weatherAlertEvent{Event: "weatherAlert", Status: weatherAlertRaised, Alert: *alert})
+
+		case matched:
+			alert.Explanation = explanation
+
+		case isActive:
+			alert.Cleared = now.Format(time.RFC3339)
+			delete(alerter.active, rule.Name)
+			alerter.recent = append(alerter.recent, *alert)
+			if len(alerter.recent) > weatherAlertHistorySize {
+				alerter.recent = alerter.recent[len(alerter.recent)-weatherAlertHistorySize:]
+			}
+			log.Printf("[Weather] Clearing %s alert", rule.Name)
+			events = append(events, &weatherAlertEvent{Event: "weatherAlert", Status: weatherAlertCleared, Alert: *alert})
+		}
+	}
+	if len(events) > 0 && alerter.path != "" {
+		alerter.saveActive()
+	}
+	listeners := make([]weatherAlertListener, 0, len(alerter.listeners))
+	for listener := range alerter.listeners {
+		listeners = append(listeners, listener)
+	}
+	alerter.mutex.Unlock()
+
+	for _, event := range events {
+		for _, listener := range listeners {
+			listener <- event
+		}
+	}
+}
+
+func (alerter *weatherAlerter) Active() []weatherAlert {
+	alerter.mutex.Lock()
+	defer alerter.mutex.Unlock()
+	out := make([]weatherAlert, 0, len(alerter.active))
+	for _, alert := range alerter.active {
+		out = append(out, *alert)
+	}
+	sort.Slice(out, func(first, second int) bool {
+		return out[first].Raised < out[second].Raised
+	})
+	return out
+}
+
+func (alerter *weatherAlerter) Recent() []weatherAlert {
+	alerter.mutex.Lock()
+	defer alerter.mutex.Unlock()
+	return append([]weatherAlert{}, alerter.recent...)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWeatherAlertsAreNotAnnouncedTwice(t *testing.T) {
+	dataPath := t.TempDir()
+	below := 2.0
+	rules := []weatherAlertConfiguration{{
+		weatherConditionConfiguration: weatherConditionConfiguration{Type: weatherConditionMinTemperature, Hours: 24, Below: &below},
+		Name:                          "Frost",
+		Message:                       "Bring the plants in",
+	}}
+	now := time.Now()
+	forecast := func(minimum float64, hours int) (*WeatherForecast, time.Time) {
+		at := now.Add(time.Duration(hours) * time.Hour)
+		return &WeatherForecast{Items: []WeatherConditions{{Time: at, Hours: 3, MinimumTemperature: minimum, MaximumTemperature: minimum + 5}}}, at
+	}
+
+	events := make(chan *weatherAlertEvent, 4)
+	first := &weatherAlerter{}
+	first.SetRules(rules, dataPath)
+	first.AddListener(events)
+	first.Check(forecast(-1, 0))
+	first.Check(forecast(-1, 1))
+	if len(events) != 1 {
+		t.Fatalf("expected one announcement while the frost lasts, got %d", len(events))
+	}
+	raised := <-events
+	if raised.Status != weatherAlertRaised || raised.Alert.Severity != defaultAlertSeverity {
+		t.Fatalf("expected the frost warning, got %+v", raised)
+	}
+
+	// After a restart the frost is still forecast, but it has already been announced
+	restarted := &weatherAlerter{}
+	restarted.SetRules(rules, dataPath)
+	restarted.AddListener(events)
+	restarted.Check(forecast(-1, 2))
+	if len(events) != 0 {
+		t.Fatalf("expected no new announcement after a restart, got %+v", <-events)
+	}
+	if active := restarted.Active(); len(active) != 1 || active[0].Raised != raised.Alert.Raised {
+		t.Fatalf("expected the alert to keep its original time, got %+v", active)
+	}
+
+	restarted.Check(forecast(8, 3))
+	if cleared := <-events; cleared.Status != weatherAlertCleared || len(restarted.Recent()) != 1 {
+		t.Fatalf("expected the frost to clear, got %+v", cleared)
+	}
+
+	// Once cleared the next frost is announced again, even across a restart
+	again := &weatherAlerter{}
+	again.SetRules(rules, dataPath)
+	again.AddListener(events)
+	again.Check(forecast(-1, 4))
+	if len(events) != 1 {
+		t.Fatalf("expected the new frost to be announced, got %d events", len(events))
+	}
+}
+
+func TestWeatherAlertsForRemovedRulesAreDropped(t *testing.T) {
+	dataPath := t.TempDir()
+	above := 10.0
+	wind := weatherAlertConfiguration{weatherConditionConfiguration: weatherConditionConfiguration{Type: weatherConditionWindSpeed, Hours: 6, Above: &above}, Name: "Gale"}
+	forecast := &WeatherForecast{Items: []WeatherConditions{{Time: time.Now(), Hours: 3, WindSpeed: 20}}}
+
+	alerter := &weatherAlerter{}
+	alerter.SetRules([]weatherAlertConfiguration{wind}, dataPath)
+	alerter.Check(forecast, time.Now())
+
+	alerter.SetRules(nil, dataPath)
+	if active := alerter.Active(); len(active) != 0 {
+		t.Fatalf("expected no alerts without rules, got %+v", active)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	weatherConditionRain           = "rain"
+	weatherConditionMaxTemperature = "maxTemperature"
+	weatherConditionMinTemperature = "minTemperature"
+	weatherConditionHumidity       = "humidity"
+	weatherConditionWindSpeed      = "windSpeed"
+
+	// Rain means at least this much precipitation or this chance of it, so a forecast trace is not enough
+	defaultRainAmount = 1.0
+	defaultRainChance = 0.5
+)
+
+func (condition *weatherConditionConfiguration) window(now time.Time) time.Time {
+	if condition.Hours > 0 {
+		return now.Add(time.Duration(condition.Hours) * time.Hour)
+	}
+	// Without a window the condition is about the rest of today
+	year, month, day := now.Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+}
+
+func (condition *weatherConditionConfiguration) describeWindow(now time.Time) string {
+	if condition.Hours > 0 {
+		return fmt.Sprintf("in the next %d hours", condition.Hours)
+	}
+	return "for the rest of today"
+}
+
+func (condition *weatherConditionConfiguration) Evaluate(forecast *WeatherForecast, now time.Time) (bool, string, error) {
+	end := condition.window(now)
+	items := []*WeatherConditions{}
+	for pos := range forecast.Items {
+		item := &forecast.Items[pos]
+		itemEnd := item.Time.Add(time.Duration(item.Hours) * time.Hour)
+		if itemEnd.After(now) && item.Time.Before(end) {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return false, "", fmt.Errorf("The forecast does not cover %s", condition.describeWindow(now))
+	}
+
+	switch condition.Type {
+	case weatherConditionRain:
+		amount := condition.Amount
+		if amount <= 0 {
+			amount = defaultRainAmount
+		}
+		chance := condition.Chance
+		if chance <= 0 {
+			chance = defaultRainChance
+		}
+		for _, item := range items {
+			if item.Precipitation >= amount || item.PrecipitationChance >= chance {
+				return true, fmt.Sprintf("Rain is forecast at %s (%.1fmm, %.f%% chance)",
+					item.Time.Local().Format("15:04"), item.Precipitation, item.PrecipitationChance*100), nil
+			}
+		}
+		return false, fmt.Sprintf("No rain is forecast %s", condition.describeWindow(now)), nil
+
+	case weatherConditionMaxTemperature:
+		value := math.Inf(-1)
+		for _, item := range items {
+			value = math.Max(value, item.MaximumTemperature)
+		}
+		return condition.compare("maximum temperature", value, "°C", now)
+
+	case weatherConditionMinTemperature:
+		value := math.Inf(1)
+		for _, item := range items {
+			value = math.Min(value, item.MinimumTemperature)
+		}
+		return condition.compare("minimum temperature", value, "°C", now)
+
+	case weatherConditionHumidity:
+		total := 0.0
+		for _, item := range items {
+			total += item.Humidity
+		}
+		return condition.compare("average humidity", total/float64(len(items)), "%", now)
+
+	case weatherConditionWindSpeed:
+		value := 0.0
+		for _, item := range items {
+			value = math.Max(value, item.WindSpeed)
+		}
+		return condition.compare("maximum wind speed", value, "m/s", now)
+
+	default:
+		return false, "", fmt.Errorf("Unknown weather condition '%s'", condition.Type)
+	}
+}
+
+func (condition *weatherConditionConfiguration) compare(name string, value float64, unit string, now time.Time) (bool, string, error) {
+	if condition.Above == nil && condition.Below == nil {
+		return false, "", fmt.Errorf("The %s condition needs a value to be above or below", condition.Type)
+	}
+
+	description := fmt.Sprintf("The forecast %s %s is %.f%s", name, condition.describeWindow(now), value, unit)
+	if condition.Above != nil && value > *condition.Above {
+		return true, fmt.Sprintf("%s, above %.f%s", description, *condition.Above, unit), nil
+	}
+	if condition.Below != nil && value < *condition.Below {
+		return true, fmt.Sprintf("%s, below %.f%s", description, *condition.Below, unit), nil
+	}
+	return false, description, nil
+}
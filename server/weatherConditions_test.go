@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeatherConditionEvaluate(t *testing.T) {
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.Local)
+	forecast := &WeatherForecast{Items: []WeatherConditions{
+		{Time: now.Add(-4 * time.Hour), Hours: 3, MaximumTemperature: 40, MinimumTemperature: -10},
+		{Time: now, Hours: 3, MaximumTemperature: 18, MinimumTemperature: 9, Humidity: 60, WindSpeed: 4, Precipitation: 0.2, PrecipitationChance: 0.3},
+		{Time: now.Add(3 * time.Hour), Hours: 3, MaximumTemperature: 22, MinimumTemperature: 12, Humidity: 80, WindSpeed: 9},
+		{Time: now.Add(18 * time.Hour), Hours: 3, MaximumTemperature: 8, MinimumTemperature: 1, Humidity: 95, WindSpeed: 16, Precipitation: 3, PrecipitationChance: 0.9},
+	}}
+	limit := func(value float64) *float64 { return &value }
+
+	tests := []struct {
+		name        string
+		condition   weatherConditionConfiguration
+		want        bool
+		wantMessage string
+		wantErr     string
+	}{
+		{name: "trace of rain is not rain", condition: weatherConditionConfiguration{Type: weatherConditionRain, Hours: 6}, want: false, wantMessage: "No rain is forecast in the next 6 hours"},
+		{name: "rain later today", condition: weatherConditionConfiguration{Type: weatherConditionRain}, want: false, wantMessage: "rest of today"},
+		{name: "rain tomorrow", condition: weatherConditionConfiguration{Type: weatherConditionRain, Hours: 24}, want: true, wantMessage: "3.0mm, 90% chance"},
+		{name: "explicit amount", condition: weatherConditionConfiguration{Type: weatherConditionRain, Hours: 6, Amount: 0.1}, want: true},
+		{name: "explicit chance", condition: weatherConditionConfiguration{Type: weatherConditionRain, Hours: 6, Chance: 0.25}, want: true},
+		{name: "hot", condition: weatherConditionConfiguration{Type: weatherConditionMaxTemperature, Hours: 6, Above: limit(20)}, want: true, wantMessage: "is 22°C, above 20°C"},
+		{name: "not hot", condition: weatherConditionConfiguration{Type: weatherConditionMaxTemperature, Hours: 6, Above: limit(25)}, want: false},
+		{name: "frost", condition: weatherConditionConfiguration{Type: weatherConditionMinTemperature, Hours: 24, Below: limit(2)}, want: true, wantMessage: "below 2°C"},
+		{name: "past items are ignored", condition: weatherConditionConfiguration{Type: weatherConditionMinTemperature, Hours: 6, Below: limit(0)}, want: false},
+		{name: "average humidity", condition: weatherConditionConfiguration{Type: weatherConditionHumidity, Hours: 6, Above: limit(65)}, want: true, wantMessage: "average humidity in the next 6 hours is 70%"},
+		{name: "wind", condition: weatherConditionConfiguration{Type: weatherConditionWindSpeed, Hours: 24, Above: limit(15)}, want: true},
+		{name: "no threshold", condition: weatherConditionConfiguration{Type: weatherConditionHumidity, Hours: 6}, wantErr: "needs a value"},
+		{name: "unknown type", condition: weatherConditionConfiguration{Type: "snow", Hours: 6}, wantErr: "Unknown weather condition"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matched, message, err := test.condition.Evaluate(forecast, now)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if matched != test.want {
+				t.Fatalf("expected %t, got %t (%s)", test.want, matched, message)
+			}
+			if !strings.Contains(message, test.wantMessage) {
+				t.Fatalf("expected message containing %q, got %q", test.wantMessage, message)
+			}
+		})
+	}
+}
+
+func TestWeatherConditionNeedsForecast(t *testing.T) {
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.Local)
+	forecast := &WeatherForecast{Items: []WeatherConditions{{Time: now.Add(48 * time.Hour), Hours: 3}}}
+	condition := weatherConditionConfiguration{Type: weatherConditionRain, Hours: 12}
+	if _, _, err := condition.Evaluate(forecast, now); err == nil {
+		t.Fatalf("expected an error when the forecast does not cover the window")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+const (
+	weatherFileCurrent  = "current.json"
+	weatherFileForecast = "forecast.json"
+)
+
+// Reads weather in the provider-neutral format from a directory, for working offline and in tests
+type fileWeatherProvider struct {
+	path string
+}
+
+func (provider *fileWeatherProvider) Name() string {
+	return weatherProviderFile
+}
+
+func (provider *fileWeatherProvider) read(name string, out interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(provider.path, name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (provider *fileWeatherProvider) Current() (*CurrentWeather, error) {
+	var weather CurrentWeather
+	if err := provider.read(weatherFileCurrent, &weather); err != nil {
+		return nil, fmt.Errorf("Unable to read current weather: %v", err)
+	}
+	return &weather, nil
+}
+
+func (provider *fileWeatherProvider) Forecast() (*WeatherForecast, error) {
+	var forecast WeatherForecast
+	if err := provider.read(weatherFileForecast, &forecast); err != nil {
+		return nil, fmt.Errorf("Unable to read weather forecast: %v", err)
+	}
+	return &forecast, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	weatherHistoryPath        = "weather"
+	weatherObservationsFile   = "observations.jsonl"
+	weatherForecastsFile      = "forecasts.jsonl"
+	defaultWeatherHistory     = 90
+	defaultWeatherSource      = "Outdoor"
+	weatherHistoryPrunePeriod = 24 * time.Hour
+)
+
+type weatherObservation struct {
+	Retrieved  time.Time         `json:"retrieved"`
+	Provider   string            `json:"provider"`
+	Conditions WeatherConditions `json:"conditions"`
+}
+
+type weatherForecastRecord struct {
+	Retrieved time.Time           `json:"retrieved"`
+	Provider  string              `json:"provider"`
+	Items     []WeatherConditions `json:"items"`
+}
+
+type weatherHistory struct {
+	path   string
+	days   int
+	pruned time.Time
+	mutex  sync.Mutex
+}
+
+func newWeatherHistory(dataPath string, days int) (*weatherHistory, error) {
+	if days <= 0 {
+		days = defaultWeatherHistory
+	}
+	path := filepath.Join(dataPath, weatherHistoryPath)
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, fmt.Errorf("Unable to create weather history path: %v", err)
+	}
+	return &weatherHistory{path: path, days: days}, nil
+}
+
+func (history *weatherHistory) append(name string, item interface{}) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("Unable to generate weather history: %v", err)
+	}
+
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	file, err := os.OpenFile(filepath.Join(history.path, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to open weather history: %v", err)
+	}
+	defer file.Close()
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("Unable to write weather history: %v", err)
+	}
+	return nil
+}
+
+func (history *weatherHistory) Record(provider string, current *CurrentWeather, forecast *WeatherForecast) {
+	now := time.Now()
+	if current != nil {
+		err := history.append(weatherObservationsFile, &weatherObservation{
+			Retrieved:  now,
+			Provider:   provider,
+			Conditions: current.Conditions,
+		})
+		if err != nil {
+			log.Printf("[Weather] %v", err)
+		}
+	}
+	if forecast != nil {
+		err := history.append(weatherForecastsFile, &weatherForecastRecord{
+			Retrieved: now,
+			Provider:  provider,
+			Items:     forecast.Items,
+		})
+		if err != nil {
+			log.Printf("[Weather] %v", err)
+		}
+	}
+
+	// Rewriting the files on every save would be wasteful, so old entries are removed once a day
+	history.mutex.Lock()
+	isDue := now.Sub(history.pruned) >= weatherHistoryPrunePeriod
+	history.mutex.Unlock()
+	if isDue {
+		history.Prune()
+	}
+}
+
+func (history *weatherHistory) scan(name string, handle func([]byte)) error {
+	file, err := os.Open(filepath.Join(history.path, name))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Unable to open weather history: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		handle(scanner.Bytes())
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("Unable to read weather history: %v", err)
+	}
+	return nil
+}
+
+func isBetween(timeStamp, from, to time.Time) bool {
+	return (from.IsZero() || !timeStamp.Before(from)) && (to.IsZero() || !timeStamp.After(to))
+}
+
+func (history *weatherHistory) Observations(from, to time.Time) ([]weatherObservation, error) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	out := []weatherObservation{}
+	err := history.scan(weatherObservationsFile, func(data []byte) {
+		var item weatherObservation
+		if err := json.Unmarshal(data, &item); err == nil && isBetween(item.Retrieved, from, to) {
+			out = append(out, item)
+		}
+	})
+	return out, err
+}
+
+func (history *weatherHistory) Forecasts(from, to time.Time) ([]weatherForecastRecord, error) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	out := []weatherForecastRecord{}
+	err := history.scan(weatherForecastsFile, func(data []byte) {
+		var item weatherForecastRecord
+		if err := json.Unmarshal(data, &item); err == nil && isBetween(item.Retrieved, from, to) {
+			out = append(out, item)
+		}
+	})
+	return out, err
+}
+
+func (history *weatherHistory) Prune() {
+	now := time.Now()
+	history.mutex.Lock()
+	history.pruned = now
+	history.mutex.Unlock()
+
+	cutOff := now.AddDate(0, 0, -history.days)
+	for _, name := range []string{weatherObservationsFile, weatherForecastsFile} {
+		if err := history.prune(name, cutOff); err != nil {
+			log.Printf("[Weather] Unable to prune %s: %v", name, err)
+		}
+	}
+}
+
+func (history *weatherHistory) prune(name string, cutOff time.Time) error {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	kept := []byte{}
+	removed := 0
+	err := history.scan(name, func(data []byte) {
+		var item struct {
+			Retrieved time.Time `json:"retrieved"`
+		}
+		if err := json.Unmarshal(data, &item); err != nil || item.Retrieved.Before(cutOff) {
+			removed++
+		} else {
+			kept = append(append(kept, data...), '\n')
+		}
+	})
+	if err != nil || removed == 0 {
+		return err
+	}
+
+	log.Printf("[Weather] Removing %d entries from %s", removed, name)
+	path := filepath.Join(history.path, name)
+	if err = ioutil.WriteFile(path+".tmp", kept, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWeatherHistoryRecordsAndSearches(t *testing.T) {
+	history, err := newWeatherHistory(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("unable to create history: %v", err)
+	}
+	if history.days != defaultWeatherHistory {
+		t.Fatalf("expected %d days by default, got %d", defaultWeatherHistory, history.days)
+	}
+
+	before := time.Now()
+	history.Record(weatherProviderOpenMeteo,
+		&CurrentWeather{Conditions: WeatherConditions{Summary: "Rain", Temperature: 12}},
+		&WeatherForecast{Items: []WeatherConditions{{Summary: "Clouds", Hours: 1}, {Summary: "Clear", Hours: 1}}})
+	history.Record(weatherProviderOpenMeteo, &CurrentWeather{Conditions: WeatherConditions{Summary: "Clouds"}}, nil)
+
+	observations, err := history.Observations(time.Time{}, time.Time{})
+	if err != nil || len(observations) != 2 {
+		t.Fatalf("expected two observations, got %d: %v", len(observations), err)
+	}
+	if first := observations[0]; first.Provider != weatherProviderOpenMeteo || first.Conditions.Temperature != 12 || first.Retrieved.Before(before) {
+		t.Fatalf("unexpected first observation %+v", first)
+	}
+
+	forecasts, _ := history.Forecasts(before, time.Now())
+	if len(forecasts) != 1 || len(forecasts[0].Items) != 2 {
+		t.Fatalf("expected the one forecast with two hours, got %+v", forecasts)
+	}
+	if later, _ := history.Observations(time.Now().Add(time.Minute), time.Time{}); len(later) != 0 {
+		t.Fatalf("expected nothing after now, got %+v", later)
+	}
+}
+
+func TestWeatherHistoryPrune(t *testing.T) {
+	history, _ := newWeatherHistory(t.TempDir(), 7)
+	path := filepath.Join(history.path, weatherObservationsFile)
+
+	lines := []byte{}
+	for _, age := range []int{30, 8, 6, 1} {
+		data, _ := json.Marshal(weatherObservation{Retrieved: time.Now().AddDate(0, 0, -age), Provider: "file"})
+		lines = append(append(lines, data...), '\n')
+	}
+	lines = append(lines, []byte("{broken\n")...)
+	ioutil.WriteFile(path, lines, 0600)
+
+	history.Prune()
+	kept, _ := history.Observations(time.Time{}, time.Time{})
+	if len(kept) != 2 {
+		t.Fatalf("expected the two entries within a week to be kept, got %d", len(kept))
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("expected the temporary file to be renamed into place")
+	}
+
+	// The next save does not prune again until a day has passed
+	history.pruned = time.Now()
+	stale, _ := json.Marshal(weatherObservation{Retrieved: time.Now().AddDate(0, 0, -10)})
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	file.Write(append(stale, '\n'))
+	file.Close()
+	history.Record("file", &CurrentWeather{}, nil)
+	if all, _ := history.Observations(time.Time{}, time.Time{}); len(all) != 4 {
+		t.Fatalf("expected the old entry to wait for the daily prune, got %d entries", len(all))
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const defaultOpenMeteoURL = "https://api.open-meteo.com/v1/forecast"
+
+type openMeteoProvider struct {
+	config *weatherConfiguration
+	client *http.Client
+}
+
+type openMeteoCurrent struct {
+	Time          int64   `json:"time"`
+	Temperature   float64 `json:"temperature_2m"`
+	Humidity      float64 `json:"relative_humidity_2m"`
+	Pressure      float64 `json:"pressure_msl"`
+	WindSpeed     float64 `json:"wind_speed_10m"`
+	WindDirection float64 `json:"wind_direction_10m"`
+	Precipitation float64 `json:"precipitation"`
+	WeatherCode   int     `json:"weather_code"`
+}
+
+type openMeteoHourly struct {
+	Time                []int64   `json:"time"`
+	Temperature         []float64 `json:"temperature_2m"`
+	Humidity            []float64 `json:"relative_humidity_2m"`
+	Pressure            []float64 `json:"pressure_msl"`
+	WindSpeed           []float64 `json:"wind_speed_10m"`
+	WindDirection       []float64 `json:"wind_direction_10m"`
+	Precipitation       []float64 `json:"precipitation"`
+	PrecipitationChance []float64 `json:"precipitation_probability"`
+	WeatherCode         []int     `json:"weather_code"`
+}
+
+type openMeteoResponse struct {
+	Latitude  float64           `json:"latitude"`
+	Longitude float64           `json:"longitude"`
+	Current   *openMeteoCurrent `json:"current"`
+	Hourly    *openMeteoHourly  `json:"hourly"`
+}
+
+type openMeteoCode struct {
+	Summary     string
+	Description string
+}
+
+// WMO weather interpretation codes, using the same one word summaries as OpenWeatherMap
+var openMeteoCodes = map[int]openMeteoCode{
+	0:  {"Clear", "clear sky"},
+	1:  {"Clear", "mainly clear"},
+	2:  {"Clouds", "partly cloudy"},
+	3:  {"Clouds", "overcast"},
+	45: {"Fog", "fog"},
+	48: {"Fog", "freezing fog"},
+	51: {"Drizzle", "light drizzle"},
+	53: {"Drizzle", "drizzle"},
+	55: {"Drizzle", "heavy drizzle"},
+	56: {"Drizzle", "light freezing drizzle"},
+	57: {"Drizzle", "freezing drizzle"},
+	61: {"Rain", "light rain"},
+	63: {"Rain", "moderate rain"},
+	65: {"Rain", "heavy rain"},
+	66: {"Rain", "light freezing rain"},
+	67: {"Rain", "freezing rain"},
+	71: {"Snow", "light snow"},
+	73: {"Snow", "snow"},
+	75: {"Snow", "heavy snow"},
+	77: {"Snow", "snow grains"},
+	80: {"Rain", "light showers"},
+	81: {"Rain", "showers"},
+	82: {"Rain", "heavy showers"},
+	85: {"Snow", "light snow showers"},
+	86: {"Snow", "snow showers"},
+	95: {"Thunderstorm", "thunderstorm"},
+	96: {"Thunderstorm", "thunderstorm with hail"},
+	99: {"Thunderstorm", "thunderstorm with heavy hail"},
+}
+
+func (provider *openMeteoProvider) Name() string {
+	return weatherProviderOpenMeteo
+}
+
+func (provider *openMeteoProvider) download(fields url.Values) (*openMeteoResponse, error) {
+	base := provider.config.BaseURL
+	if base == "" {
+		base = defaultOpenMeteoURL
+	}
+	fields.Set("latitude", strconv.FormatFloat(provider.config.Latitude, 'f', -1, 64))
+	fields.Set("longitude", strconv.FormatFloat(provider.config.Longitude, 'f', -1, 64))
+	fields.Set("wind_speed_unit", "ms")
+	fields.Set("timeformat", "unixtime")
+
+	var data openMeteoResponse
+	if err := downloadJSON(provider.client, base+"?"+fields.Encode(), &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (provider *openMeteoProvider) location(data *openMeteoResponse) WeatherLocation {
+	return WeatherLocation{
+		Name:      provider.config.LocationCode,
+		Latitude:  data.Latitude,
+		Longitude: data.Longitude,
+	}
+}
+
+func (provider *openMeteoProvider) Current() (*CurrentWeather, error) {
+	log.Printf("[Weather] Downloading current weather from Open-Meteo")
+	data, err := provider.download(url.Values{
+		"current": {"temperature_2m,relative_humidity_2m,pressure_msl,wind_speed_10m,wind_direction_10m,precipitation,weather_code"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to download current weather: %v", err)
+	}
+	if data.Current == nil {
+		return nil, fmt.Errorf("No current weather was returned")
+	}
+
+	code := openMeteoCodes[data.Current.WeatherCode]
+	return &CurrentWeather{
+		Location: provider.location(data),
+		Conditions: WeatherConditions{
+			Time:               time.Unix(data.Current.Time, 0),
+			Summary:            code.Summary,
+			Description:        code.Description,
+			Temperature:        data.Current.Temperature,
+			MinimumTemperature: data.Current.Temperature,
+			MaximumTemperature: data.Current.Temperature,
+			Humidity:           data.Current.Humidity,
+			Pressure:           data.Current.Pressure,
+			WindSpeed:          data.Current.WindSpeed,
+			WindDirection:      data.Current.WindDirection,
+			Precipitation:      data.Current.Precipitation,
+		},
+	}, nil
+}
+
+func (provider *openMeteoProvider) Forecast() (*WeatherForecast, error) {
+	log.Printf("[Weather] Downloading weather forecast from Open-Meteo")
+	data, err := provider.download(url.Values{
+		"hourly":        {"temperature_2m,relative_humidity_2m,pressure_msl,wind_speed_10m,wind_direction_10m,precipitation,precipitation_probability,weather_code"},
+		"forecast_days": {"5"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to download weather forecast: %v", err)
+	}
+	if data.Hourly == nil {
+		return nil, fmt.Errorf("No weather forecast was returned")
+	}
+
+	hourly := data.Hourly
+	value := func(values []float64, pos int) float64 {
+		if pos < len(values) {
+			return values[pos]
+		}
+		return 0
+	}
+	forecast := &WeatherForecast{
+		Location: provider.location(data),
+		Items:    make([]WeatherConditions, 0, len(hourly.Time)),
+	}
+	// The hours run from midnight, only the current hour and those after it are a forecast
+	now := time.Now()
+	for pos, timeStamp := range hourly.Time {
+		if time.Unix(timeStamp, 0).Add(time.Hour).Before(now) {
+			continue
+		}
+		var code openMeteoCode
+		if pos < len(hourly.WeatherCode) {
+			code = openMeteoCodes[hourly.WeatherCode[pos]]
+		}
+		temperature := value(hourly.Temperature, pos)
+		forecast.Items = append(forecast.Items, WeatherConditions{
+			Time:                time.Unix(timeStamp, 0),
+			Hours:               1,
+			Summary:             code.Summary,
+			Description:         code.Description,
+			Temperature:         temperature,
+			MinimumTemperature:  temperature,
+			MaximumTemperature:  temperature,
+			Humidity:            value(hourly.Humidity, pos),
+			Pressure:            value(hourly.Pressure, pos),
+			WindSpeed:           value(hourly.WindSpeed, pos),
+			WindDirection:       value(hourly.WindDirection, pos),
+			Precipitation:       value(hourly.Precipitation, pos),
+			PrecipitationChance: value(hourly.PrecipitationChance, pos) / 100,
+		})
+	}
+	return forecast, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOpenMeteoNeedsCoordinates(t *testing.T) {
+	if _, err := newWeatherProvider(&weatherConfiguration{Provider: weatherProviderOpenMeteo}, http.DefaultClient); err == nil {
+		t.Fatal("expected Open-Meteo without a location to be refused")
+	}
+	provider, err := newWeatherProvider(&weatherConfiguration{Provider: weatherProviderOpenMeteo, Latitude: 51.5, Longitude: 0}, http.DefaultClient)
+	if err != nil || provider.Name() != weatherProviderOpenMeteo {
+		t.Fatalf("expected a location on the meridian to be accepted, got %v", err)
+	}
+}
+
+func TestOpenMeteoForecastStartsAtTheCurrentHour(t *testing.T) {
+	hour := time.Now().Truncate(time.Hour)
+	var query map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		query = req.URL.Query()
+		hourly := openMeteoHourly{}
+		for offset := -3; offset < 3; offset++ {
+			hourly.Time = append(hourly.Time, hour.Add(time.Duration(offset)*time.Hour).Unix())
+			hourly.Temperature = append(hourly.Temperature, float64(10+offset))
+			hourly.PrecipitationChance = append(hourly.PrecipitationChance, 40)
+			hourly.WeatherCode = append(hourly.WeatherCode, 61)
+		}
+		json.NewEncoder(resp).Encode(openMeteoResponse{Latitude: 51.5, Longitude: -0.12, Hourly: &hourly})
+	}))
+	defer server.Close()
+
+	provider := &openMeteoProvider{config: &weatherConfiguration{BaseURL: server.URL, Latitude: 51.5, Longitude: -0.12}, client: server.Client()}
+	forecast, err := provider.Forecast()
+	if err != nil {
+		t.Fatalf("unable to download forecast: %v", err)
+	}
+	if query["latitude"][0] != "51.5" || query["longitude"][0] != "-0.12" || query["wind_speed_unit"][0] != "ms" {
+		t.Fatalf("expected the location and units in the request, got %v", query)
+	}
+
+	if len(forecast.Items) != 3 || !forecast.Items[0].Time.Equal(hour) {
+		t.Fatalf("expected three hours from %v, got %+v", hour, forecast.Items)
+	}
+	first := forecast.Items[0]
+	if first.Temperature != 10 || first.Summary != "Rain" || first.PrecipitationChance != 0.4 || first.Hours != 1 {
+		t.Fatalf("unexpected conditions for the current hour: %+v", first)
+	}
+}
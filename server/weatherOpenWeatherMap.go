@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type openWeatherMapProvider struct {
+	config *weatherConfiguration
+	client *http.Client
+}
+
+type openWeatherMapCoordinates struct {
+	Longitude float64 `json:"lon"`
+	Latitude  float64 `json:"lat"`
+}
+
+type openWeatherMapInformation struct {
+	Main        string `json:"main"`
+	Description string `json:"description"`
+}
+
+type openWeatherMapMain struct {
+	Temperature        float64 `json:"temp"`
+	Pressure           float64 `json:"pressure"`
+	Humidity           float64 `json:"humidity"`
+	MinimumTemperature float64 `json:"temp_min"`
+	MaximumTemperature float64 `json:"temp_max"`
+}
+
+type openWeatherMapWind struct {
+	Speed     float64 `json:"speed"`
+	Direction float64 `json:"deg"`
+}
+
+type openWeatherMapPrecipitation struct {
+	OneHour    float64 `json:"1h"`
+	ThreeHours float64 `json:"3h"`
+}
+
+type openWeatherMapCurrent struct {
+	Name        string                      `json:"name"`
+	Time        int64                       `json:"dt"`
+	Coordinates openWeatherMapCoordinates   `json:"coord"`
+	Weather     []openWeatherMapInformation `json:"weather"`
+	Main        openWeatherMapMain          `json:"main"`
+	Wind        openWeatherMapWind          `json:"wind"`
+	Rain        openWeatherMapPrecipitation `json:"rain"`
+	Snow        openWeatherMapPrecipitation `json:"snow"`
+}
+
+type openWeatherMapForecast struct {
+	City struct {
+		Name        string                    `json:"name"`
+		Coordinates openWeatherMapCoordinates `json:"coord"`
+	} `json:"city"`
+	List []struct {
+		Time    int64                       `json:"dt"`
+		Weather []openWeatherMapInformation `json:"weather"`
+		Main    openWeatherMapMain          `json:"main"`
+		Wind    openWeatherMapWind          `json:"wind"`
+		Rain    openWeatherMapPrecipitation `json:"rain"`
+		Snow    openWeatherMapPrecipitation `json:"snow"`
+		Chance  float64                     `json:"pop"`
+	} `json:"list"`
+}
+
+func (provider *openWeatherMapProvider) Name() string {
+	return weatherProviderOpenWeatherMap
+}
+
+func (provider *openWeatherMapProvider) url(endpoint string) string {
+	args := url.Values{}
+	args.Set("id", provider.config.LocationCode)
+	args.Set("units", "metric")
+	args.Set("APPID", provider.config.APIKey)
+	return provider.config.BaseURL + endpoint + "?" + args.Encode()
+}
+
+func (provider *openWeatherMapProvider) Current() (*CurrentWeather, error) {
+	log.Printf("[Weather] Downloading current weather from OpenWeatherMap")
+	var data openWeatherMapCurrent
+	if err := downloadJSON(provider.client, provider.url("weather"), &data); err != nil {
+		return nil, fmt.Errorf("Unable to download current weather: %v", err)
+	}
+
+	conditions := WeatherConditions{
+		Time:               time.Unix(data.Time, 0),
+		Temperature:        data.Main.Temperature,
+		MinimumTemperature: data.Main.MinimumTemperature,
+		MaximumTemperature: data.Main.MaximumTemperature,
+		Humidity:           data.Main.Humidity,
+		Pressure:           data.Main.Pressure,
+		WindSpeed:          data.Wind.Speed,
+		WindDirection:      data.Wind.Direction,
+		Precipitation:      data.Rain.OneHour + data.Snow.OneHour,
+	}
+	if len(data.Weather) > 0 {
+		conditions.Summary = data.Weather[0].Main
+		conditions.Description = data.Weather[0].Description
+	}
+	return &CurrentWeather{
+		Location: WeatherLocation{
+			Name:      data.Name,
+			Latitude:  data.Coordinates.Latitude,
+			Longitude: data.Coordinates.Longitude,
+		},
+		Conditions: conditions,
+	}, nil
+}
+
+func (provider *openWeatherMapProvider) Forecast() (*WeatherForecast, error) {
+	log.Printf("[Weather] Downloading weather forecast from OpenWeatherMap")
+	var data openWeatherMapForecast
+	if err := downloadJSON(provider.client, provider.url("forecast"), &data); err != nil {
+		return nil, fmt.Errorf("Unable to download weather forecast: %v", err)
+	}
+
+	forecast := &WeatherForecast{
+		Location: WeatherLocation{
+			Name:      data.City.Name,
+			Latitude:  data.City.Coordinates.Latitude,
+			Longitude: data.City.Coordinates.Longitude,
+		},
+		Items: make([]WeatherConditions, 0, len(data.List)),
+	}
+	for _, item := range data.List {
+		conditions := WeatherConditions{
+			Time:                time.Unix(item.Time, 0),
+			Hours:               3,
+			Temperature:         item.Main.Temperature,
+			MinimumTemperature:  item.Main.MinimumTemperature,
+			MaximumTemperature:  item.Main.MaximumTemperature,
+			Humidity:            item.Main.Humidity,
+			Pressure:            item.Main.Pressure,
+			WindSpeed:           item.Wind.Speed,
+			WindDirection:       item.Wind.Direction,
+			Precipitation:       item.Rain.ThreeHours + item.Snow.ThreeHours,
+			PrecipitationChance: item.Chance,
+		}
+		if len(item.Weather) > 0 {
+			conditions.Summary = item.Weather[0].Main
+			conditions.Description = item.Weather[0].Description
+		}
+		forecast.Items = append(forecast.Items, conditions)
+	}
+	return forecast, nil
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	weatherFactCurrent  = "current"
+	weatherFactForecast = "forecast"
+	weatherFactWind     = "wind"
+	weatherFactRain     = "rain"
+	weatherFactHumidity = "humidity"
+	weatherFactAlerts   = "alerts"
+
+	weatherUnitsMetric   = "metric"
+	weatherUnitsImperial = "imperial"
+
+	defaultWeatherLanguage = "en"
+	defaultWeatherPhrasing = "default"
+
+	calmWindSpeed  = 0.5
+	noRainChance   = 0.1
+	forecastWindow = 24 * time.Hour
+)
+
+var defaultWeatherFacts = []string{weatherFactCurrent, weatherFactForecast}
+
+var weatherFacts = []string{weatherFactAlerts, weatherFactCurrent, weatherFactForecast, weatherFactWind, weatherFactRain, weatherFactHumidity}
+
+type weatherPhraseSet struct {
+	SpeechLanguage string
+	Summaries      map[string]string
+	Directions     []string
+	Phrases        map[string][]string
+}
+
+// The first variant of each phrase is the one used unless variation is enabled
+var weatherPhraseSets = map[string]*weatherPhraseSet{
+	"en": {
+		SpeechLanguage: "en-GB",
+		Directions:     []string{"north", "north-east", "east", "south-east", "south", "south-west", "west", "north-west"},
+		Phrases: map[string][]string{
+			"noWeather": {"There is no weather information"},
+			"current": {
+				"The current weather is {{.Description}}, the temperature is {{.Temperature}}{{.TemperatureUnit}}",
+				"It is {{.Temperature}}{{.TemperatureUnit}} and {{.Description}} at the moment",
+			},
+			"noForecast": {"There is no forecast"},
+			"forecastRange": {
+				"The forecast is {{.ForecastDescription}}, with a temperature between {{.Minimum}} and {{.Maximum}}{{.TemperatureUnit}}",
+				"Expect {{.ForecastDescription}}, with temperatures from {{.Minimum}} to {{.Maximum}}{{.TemperatureUnit}}",
+			},
+			"forecastSingle": {
+				"The forecast is {{.ForecastDescription}}, with a temperature of {{.Maximum}}{{.TemperatureUnit}}",
+				"Expect {{.ForecastDescription}}, with a temperature of {{.Maximum}}{{.TemperatureUnit}}",
+			},
+			"wind": {
+				"The wind is from the {{.WindDirection}} at {{.WindSpeed}} {{.WindUnit}}",
+				"There is a {{.WindDirection}} wind of {{.WindSpeed}} {{.WindUnit}}",
+			},
+			"calm":     {"There is no wind"},
+			"rain":     {"There is a {{.RainChance}}% chance of rain", "The chance of rain is {{.RainChance}}%"},
+			"noRain":   {"No rain is expected"},
+			"humidity": {"The humidity is {{.Humidity}}%"},
+			"alert":    {"Weather warning for {{.AlertName}}: {{.AlertMessage}}"},
+			"noAlerts": {"There are no weather warnings"},
+		},
+	},
+	"de": {
+		SpeechLanguage: "de-DE",
+		Summaries: map[string]string{
+			"Clear":        "klar",
+			"Clouds":       "bewölkt",
+			"Rain":         "regnerisch",
+			"Drizzle":      "nieselig",
+			"Snow":         "verschneit",
+			"Thunderstorm": "gewittrig",
+			"Fog":          "neblig",
+			"Mist":         "dunstig",
+			"Haze":         "dunstig",
+		},
+		Directions: []string{"Norden", "Nordosten", "Osten", "Südosten", "Süden", "Südwesten", "Westen", "Nordwesten"},
+		Phrases: map[string][]string{
+			"noWeather": {"Es gibt keine Wetterinformationen"},
+			"current": {
+				"Das Wetter ist im Moment {{.Description}}, die Temperatur beträgt {{.Temperature}}{{.TemperatureUnit}}",
+				"Es ist {{.Description}} bei {{.Temperature}}{{.TemperatureUnit}}",
+			},
+			"noForecast": {"Es gibt keine Vorhersage"},
+			"forecastRange": {
+				"Die Vorhersage ist {{.ForecastDescription}}, mit Temperaturen zwischen {{.Minimum}} und {{.Maximum}}{{.TemperatureUnit}}",
+			},
+			"forecastSingle": {
+				"Die Vorhersage ist {{.ForecastDescription}}, mit einer Temperatur von {{.Maximum}}{{.TemperatureUnit}}",
+			},
+			"wind":     {"Der Wind kommt aus {{.WindDirection}} mit {{.WindSpeed}} {{.WindUnit}}"},
+			"calm":     {"Es ist windstill"},
+			"rain":     {"Die Regenwahrscheinlichkeit liegt bei {{.RainChance}}%"},
+			"noRain":   {"Es wird kein Regen erwartet"},
+			"humidity": {"Die Luftfeuchtigkeit beträgt {{.Humidity}}%"},
+			"alert":    {"Wetterwarnung {{.AlertName}}: {{.AlertMessage}}"},
+			"noAlerts": {"Es gibt keine Wetterwarnungen"},
+		},
+	},
+	"fr": {
+		SpeechLanguage: "fr-FR",
+		Summaries: map[string]string{
+			"Clear":        "clair",
+			"Clouds":       "nuageux",
+			"Rain":         "pluvieux",
+			"Drizzle":      "bruineux",
+			"Snow":         "neigeux",
+			"Thunderstorm": "orageux",
+			"Fog":          "brumeux",
+			"Mist":         "brumeux",
+			"Haze":         "brumeux",
+		},
+		Directions: []string{"nord", "nord-est", "est", "sud-est", "sud", "sud-ouest", "ouest", "nord-ouest"},
+		Phrases: map[string][]string{
+			"noWeather": {"Il n'y a pas d'informations météo"},
+			"current": {
+				"Le temps est actuellement {{.Description}}, la température est de {{.Temperature}}{{.TemperatureUnit}}",
+				"Il fait {{.Temperature}}{{.TemperatureUnit}} et le temps est {{.Description}}",
+			},
+			"noForecast": {"Il n'y a pas de prévisions"},
+			"forecastRange": {
+				"Les prévisions annoncent un temps {{.ForecastDescription}}, avec des températures entre {{.Minimum}} et {{.Maximum}}{{.TemperatureUnit}}",
+			},
+			"forecastSingle": {
+				"Les prévisions annoncent un temps {{.ForecastDescription}}, avec une température de {{.Maximum}}{{.TemperatureUnit}}",
+			},
+			"wind":     {"Vent de secteur {{.WindDirection}} à {{.WindSpeed}} {{.WindUnit}}"},
+			"calm":     {"Il n'y a pas de vent"},
+			"rain":     {"Le risque de pluie est de {{.RainChance}} %"},
+			"noRain":   {"Aucune pluie n'est prévue"},
+			"humidity": {"L'humidité est de {{.Humidity}} %"},
+			"alert":    {"Alerte météo {{.AlertName}} : {{.AlertMessage}}"},
+			"noAlerts": {"Il n'y a pas d'alerte météo"},
+		},
+	},
+}
+
+type weatherPhrasingRequest struct {
+	Phrasing string `json:"phrasing"`
+	Room     string `json:"room"`
+	Language string `json:"language"`
+	Units    string `json:"units"`
+	Include  string `json:"include"`
+	Variant  *int   `json:"variant"`
+}
+
+type weatherPhrasing struct {
+	language string
+	units    string
+	include  map[string]bool
+	phrases  *weatherPhraseSet
+	custom   map[string][]string
+	variant  int
+	vary     bool
+}
+
+type weatherPhraseData struct {
+	Description         string
+	ForecastDescription string
+	Temperature         int
+	Minimum             int
+	Maximum             int
+	TemperatureUnit     string
+	WindSpeed           int
+	WindDirection       string
+	WindUnit            string
+	RainChance          int
+	Humidity            int
+	AlertName           string
+	AlertMessage        string
+}
+
+type weatherDescription struct {
+	Current     string
+	Forecast    string
+	Speech      string
+	OneWord     string
+	Units       string
+	Minimum     float64
+	Temperature float64
+	Maximum     float64
+}
+
+func findWeatherPhrasing(config *appConfiguration, name string) *weatherPhrasingConfiguration {
+	for pos := range config.WeatherPhrasing {
+		if config.WeatherPhrasing[pos].Name == name {
+			return &config.WeatherPhrasing[pos]
+		}
+	}
+	return nil
+}
+
+func newWeatherPhrasing(config *appConfiguration, request *weatherPhrasingRequest) (*weatherPhrasing, error) {
+	name := request.Phrasing
+	if name == "" && request.Room != "" {
+		room := config.findRoom(request.Room)
+		if room == nil {
+			return nil, fmt.Errorf("Unknown room '%s'", request.Room)
+		}
+		name = room.WeatherPhrasing
+	}
+
+	profile := &weatherPhrasingConfiguration{}
+	if name != "" {
+		if profile = findWeatherPhrasing(config, name); profile == nil {
+			return nil, fmt.Errorf("Unknown weather phrasing '%s'", name)
+		}
+	} else if found := findWeatherPhrasing(config, defaultWeatherPhrasing); found != nil {
+		profile = found
+	}
+
+	phrasing := &weatherPhrasing{
+		language: firstNonEmpty(request.Language, profile.Language, defaultWeatherLanguage),
+		units:    firstNonEmpty(request.Units, profile.Units, weatherUnitsMetric),
+		include:  map[string]bool{},
+		custom:   profile.Phrases,
+		vary:     profile.Vary,
+	}
+	if phrasing.phrases = weatherPhraseSets[phrasing.language]; phrasing.phrases == nil {
+		return nil, fmt.Errorf("Unsupported language '%s'", phrasing.language)
+	}
+	if phrasing.units != weatherUnitsMetric && phrasing.units != weatherUnitsImperial {
+		return nil, fmt.Errorf("Unknown units '%s'", phrasing.units)
+	}
+	if request.Variant != nil {
+		phrasing.variant, phrasing.vary = *request.Variant, false
+	}
+
+	facts := profile.Include
+	if request.Include != "" {
+		facts = strings.Split(request.Include, ",")
+	}
+	if len(facts) == 0 {
+		facts = defaultWeatherFacts
+	}
+	for _, fact := range facts {
+		fact = strings.TrimSpace(fact)
+		if !containsString(weatherFacts, fact) {
+			return nil, fmt.Errorf("Unknown weather fact '%s'", fact)
+		}
+		phrasing.include[fact] = true
+	}
+
+	for key, variants := range phrasing.custom {
+		for _, text := range variants {
+			if _, err := template.New(key).Parse(text); err != nil {
+				return nil, fmt.Errorf("Invalid %s phrase: %v", key, err)
+			}
+		}
+	}
+	return phrasing, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (phrasing *weatherPhrasing) SpeechLanguage() string {
+	return phrasing.phrases.SpeechLanguage
+}
+
+func (phrasing *weatherPhrasing) describeConditions(conditions *WeatherConditions) string {
+	if phrasing.language == defaultWeatherLanguage && conditions.Description != "" {
+		return conditions.Description
+	}
+	if text, ok := phrasing.phrases.Summaries[conditions.Summary]; ok {
+		return text
+	}
+	return strings.ToLower(conditions.Summary)
+}
+
+func (phrasing *weatherPhrasing) temperature(value float64) float64 {
+	if phrasing.units == weatherUnitsImperial {
+		return value*9/5 + 32
+	}
+	return value
+}
+
+func (phrasing *weatherPhrasing) render(key string, data *weatherPhraseData) (string, error) {
+	variants := phrasing.custom[key]
+	if len(variants) == 0 {
+		variants = phrasing.phrases.Phrases[key]
+	}
+	variant := phrasing.variant
+	if phrasing.vary {
+		variant = rand.Intn(len(variants))
+	}
+	if variant < 0 {
+		variant = 0
+	}
+
+	parsed, err := template.New(key).Parse(variants[variant%len(variants)])
+	if err != nil {
+		return "", fmt.Errorf("Invalid %s phrase: %v", key, err)
+	}
+	var out bytes.Buffer
+	if err = parsed.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("Unable to generate %s phrase: %v", key, err)
+	}
+	return out.String(), nil
+}
+
+func (phrasing *weatherPhrasing) Announce(alert *weatherAlert) (string, error) {
+	data := &weatherPhraseData{
+		AlertName:    alert.Name,
+		AlertMessage: alert.Message,
+	}
+	if data.AlertMessage == "" {
+		data.AlertMessage = alert.Explanation
+	}
+	return phrasing.render("alert", data)
+}
+
+func (phrasing *weatherPhrasing) Describe(current *CurrentWeather, forecast *WeatherForecast, alerts []weatherAlert) (*weatherDescription, error) {
+	out := &weatherDescription{Units: phrasing.units}
+	data := &weatherPhraseData{
+		TemperatureUnit: "°C",
+		WindUnit:        "km/h",
+	}
+	if phrasing.units == weatherUnitsImperial {
+		data.TemperatureUnit, data.WindUnit = "°F", "mph"
+	}
+
+	hasCurrent := current != nil && current.Conditions.Summary != ""
+	if hasCurrent {
+		conditions := &current.Conditions
+		out.OneWord = conditions.Summary
+		out.Temperature = phrasing.temperature(conditions.Temperature)
+		data.Description = phrasing.describeConditions(conditions)
+		data.Temperature = int(math.Round(out.Temperature))
+		data.Humidity = int(math.Round(conditions.Humidity))
+		data.WindDirection = phrasing.phrases.Directions[int(math.Round(conditions.WindDirection/45))%8]
+		if phrasing.units == weatherUnitsImperial {
+			data.WindSpeed = int(math.Round(conditions.WindSpeed * 2.23694))
+		} else {
+			data.WindSpeed = int(math.Round(conditions.WindSpeed * 3.6))
+		}
+	}
+
+	hasForecast := forecast != nil && len(forecast.Items) > 0
+	rainChance, rainAmount := 0.0, 0.0
+	if hasForecast {
+		item := forecast.Items[0]
+		minTemp, maxTemp := item.MinimumTemperature, item.MaximumTemperature
+		endTime := item.Time.Add(forecastWindow)
+		for loop := 0; loop < len(forecast.Items) && forecast.Items[loop].Time.Before(endTime); loop++ {
+			temp := forecast.Items[loop]
+			minTemp = math.Min(minTemp, temp.MinimumTemperature)
+			maxTemp = math.Max(maxTemp, temp.MaximumTemperature)
+			rainChance = math.Max(rainChance, temp.PrecipitationChance)
+			rainAmount += temp.Precipitation
+		}
+		out.Minimum, out.Maximum = phrasing.temperature(minTemp), phrasing.temperature(maxTemp)
+		data.ForecastDescription = phrasing.describeConditions(&item)
+		data.Minimum, data.Maximum = int(math.Round(out.Minimum)), int(math.Round(out.Maximum))
+		data.RainChance = int(math.Round(rainChance * 100))
+	}
+
+	sentences := []string{}
+	add := func(key string) (string, error) {
+		text, err := phrasing.render(key, data)
+		if err == nil {
+			sentences = append(sentences, text)
+		}
+		return text, err
+	}
+
+	var err error
+	for _, fact := range weatherFacts {
+		if !phrasing.include[fact] {
+			continue
+		}
+		key := ""
+		switch fact {
+		case weatherFactAlerts:
+			if len(alerts) == 0 {
+				key = "noAlerts"
+				break
+			}
+			for pos := range alerts {
+				text, renderErr := phrasing.Announce(&alerts[pos])
+				if renderErr != nil {
+					err = renderErr
+					continue
+				}
+				sentences = append(sentences, text)
+			}
+			continue
+		case weatherFactCurrent:
+			key = "noWeather"
+			if hasCurrent {
+				key = "current"
+			}
+		case weatherFactForecast:
+			key = "noForecast"
+			if hasForecast && math.Abs(out.Minimum-out.Maximum) > 0.5 {
+				key = "forecastRange"
+			} else if hasForecast {
+				key = "forecastSingle"
+			}
+		case weatherFactWind:
+			if !hasCurrent {
+				continue
+			}
+			key = "wind"
+			if current.Conditions.WindSpeed < calmWindSpeed {
+				key = "calm"
+			}
+		case weatherFactRain:
+			if !hasForecast {
+				continue
+			}
+			key = "rain"
+			if rainChance < noRainChance && rainAmount == 0 {
+				key = "noRain"
+			}
+		case weatherFactHumidity:
+			if !hasCurrent {
+				continue
+			}
+			key = "humidity"
+		}
+
+		text, renderErr := add(key)
+		if renderErr != nil {
+			err = renderErr
+			continue
+		}
+		switch fact {
+		case weatherFactCurrent:
+			out.Current = text
+		case weatherFactForecast:
+			out.Forecast = text
+		}
+	}
+	if len(sentences) > 0 {
+		out.Speech = strings.Join(sentences, ". ") + "."
+	}
+	return out, err
+}
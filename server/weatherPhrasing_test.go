@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeatherPhrasingDescribe(t *testing.T) {
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	current := &CurrentWeather{Conditions: WeatherConditions{
+		Summary:       "Rain",
+		Description:   "light rain",
+		Temperature:   12.4,
+		Humidity:      81,
+		WindSpeed:     5,
+		WindDirection: 315,
+	}}
+	forecast := &WeatherForecast{Items: []WeatherConditions{
+		{Time: start, Summary: "Clouds", Description: "scattered clouds", MinimumTemperature: 9, MaximumTemperature: 12, PrecipitationChance: 0.2},
+		{Time: start.Add(3 * time.Hour), MinimumTemperature: 8, MaximumTemperature: 15, PrecipitationChance: 0.6, Precipitation: 1.5},
+		{Time: start.Add(30 * time.Hour), MinimumTemperature: -5, MaximumTemperature: 25, PrecipitationChance: 1},
+	}}
+	calm := &CurrentWeather{Conditions: WeatherConditions{Summary: "Clear", Description: "clear sky", Temperature: 20, WindSpeed: 0.2}}
+	dry := &WeatherForecast{Items: []WeatherConditions{{Time: start, Summary: "Clear", Description: "clear sky", MinimumTemperature: 20, MaximumTemperature: 20.2, PrecipitationChance: 0.05}}}
+	alerts := []weatherAlert{
+		{Name: "Frost", Message: "Bring the plants in", Explanation: "The forecast minimum temperature is 1°C"},
+		{Name: "Wind", Explanation: "The forecast maximum wind speed is 16m/s"},
+	}
+
+	tests := []struct {
+		name     string
+		request  weatherPhrasingRequest
+		current  *CurrentWeather
+		forecast *WeatherForecast
+		alerts   []weatherAlert
+		want     string
+	}{
+		{
+			name:     "english metric",
+			current:  current,
+			forecast: forecast,
+			want:     "The current weather is light rain, the temperature is 12°C. The forecast is scattered clouds, with a temperature between 8 and 15°C.",
+		},
+		{
+			name:     "english imperial second variant",
+			request:  weatherPhrasingRequest{Units: weatherUnitsImperial, Variant: intPointer(1)},
+			current:  current,
+			forecast: forecast,
+			want:     "It is 54°F and light rain at the moment. Expect scattered clouds, with temperatures from 46 to 59°F.",
+		},
+		{
+			name:     "variants wrap around",
+			request:  weatherPhrasingRequest{Include: "current", Variant: intPointer(3)},
+			current:  current,
+			forecast: forecast,
+			want:     "It is 12°C and light rain at the moment.",
+		},
+		{
+			name:     "german with every fact",
+			request:  weatherPhrasingRequest{Language: "de", Include: "current,wind,rain,humidity"},
+			current:  current,
+			forecast: forecast,
+			want:     "Das Wetter ist im Moment regnerisch, die Temperatur beträgt 12°C. Der Wind kommt aus Nordwesten mit 18 km/h. Die Regenwahrscheinlichkeit liegt bei 60%. Die Luftfeuchtigkeit beträgt 81%.",
+		},
+		{
+			name:    "french without weather",
+			request: weatherPhrasingRequest{Language: "fr"},
+			want:    "Il n'y a pas d'informations météo. Il n'y a pas de prévisions.",
+		},
+		{
+			name:     "calm and dry",
+			request:  weatherPhrasingRequest{Include: "forecast,wind,rain"},
+			current:  calm,
+			forecast: dry,
+			want:     "The forecast is clear sky, with a temperature of 20°C. There is no wind. No rain is expected.",
+		},
+		{
+			name:    "alerts come first",
+			request: weatherPhrasingRequest{Include: "current,alerts"},
+			current: calm,
+			alerts:  alerts,
+			want:    "Weather warning for Frost: Bring the plants in. Weather warning for Wind: The forecast maximum wind speed is 16m/s. The current weather is clear sky, the temperature is 20°C.",
+		},
+		{
+			name:    "no alerts",
+			request: weatherPhrasingRequest{Include: "alerts"},
+			want:    "There are no weather warnings.",
+		},
+	}
+
+	config := &appConfiguration{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			phrasing, err := newWeatherPhrasing(config, &test.request)
+			if err != nil {
+				t.Fatalf("unable to create phrasing: %v", err)
+			}
+			description, err := phrasing.Describe(test.current, test.forecast, test.alerts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if description.Speech != test.want {
+				t.Fatalf("expected %q, got %q", test.want, description.Speech)
+			}
+		})
+	}
+}
+
+func TestWeatherPhrasingProfiles(t *testing.T) {
+	config := &appConfiguration{
+		Rooms: []roomConfiguration{{Name: "Kitchen", WeatherPhrasing: "short"}},
+		WeatherPhrasing: []weatherPhrasingConfiguration{{
+			Name:    "short",
+			Include: []string{weatherFactCurrent},
+			Phrases: map[string][]string{"current": {"{{.Temperature}} degrees"}},
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		request weatherPhrasingRequest
+		want    string
+		wantErr string
+	}{
+		{name: "room profile", request: weatherPhrasingRequest{Room: "Kitchen"}, want: "12 degrees."},
+		{name: "named profile", request: weatherPhrasingRequest{Phrasing: "short"}, want: "12 degrees."},
+		{name: "unknown room", request: weatherPhrasingRequest{Room: "Attic"}, wantErr: "Unknown room"},
+		{name: "unknown profile", request: weatherPhrasingRequest{Phrasing: "long"}, wantErr: "Unknown weather phrasing"},
+		{name: "unknown language", request: weatherPhrasingRequest{Language: "xx"}, wantErr: "Unsupported language"},
+		{name: "unknown units", request: weatherPhrasingRequest{Units: "kelvin"}, wantErr: "Unknown units"},
+		{name: "unknown fact", request: weatherPhrasingRequest{Include: "current,pollen"}, wantErr: "Unknown weather fact"},
+	}
+
+	current := &CurrentWeather{Conditions: WeatherConditions{Summary: "Clear", Temperature: 12}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			phrasing, err := newWeatherPhrasing(config, &test.request)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unable to create phrasing: %v", err)
+			}
+			description, err := phrasing.Describe(current, nil, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if description.Speech != test.want {
+				t.Fatalf("expected %q, got %q", test.want, description.Speech)
+			}
+		})
+	}
+}
+
+func intPointer(value int) *int {
+	return &value
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	weatherProviderOpenWeatherMap = "openweathermap"
+	weatherProviderOpenMeteo      = "openmeteo"
+	weatherProviderFile           = "file"
+)
+
+type weatherProvider interface {
+	Name() string
+	Current() (*CurrentWeather, error)
+	Forecast() (*WeatherForecast, error)
+}
+
+type WeatherLocation struct {
+	Name      string  `json:"name,omitempty"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+}
+
+type WeatherConditions struct {
+	Time                time.Time `json:"time"`
+	Hours               int       `json:"hours,omitempty"`
+	Summary             string    `json:"summary"`
+	Description         string    `json:"description"`
+	Temperature         float64   `json:"temperature"`
+	MinimumTemperature  float64   `json:"min"`
+	MaximumTemperature  float64   `json:"max"`
+	Humidity            float64   `json:"humidity"`
+	Pressure            float64   `json:"pressure"`
+	WindSpeed           float64   `json:"windSpeed"`
+	WindDirection       float64   `json:"windDirection"`
+	Precipitation       float64   `json:"precipitation"`
+	PrecipitationChance float64   `json:"precipitationChance"`
+}
+
+type CurrentWeather struct {
+	Location   WeatherLocation   `json:"location"`
+	Conditions WeatherConditions `json:"conditions"`
+}
+
+type WeatherForecast struct {
+	Location WeatherLocation     `json:"location"`
+	Items    []WeatherConditions `json:"items"`
+}
+
+func newWeatherProvider(config *weatherConfiguration, client *http.Client) (weatherProvider, error) {
+	switch config.Provider {
+	case "", weatherProviderOpenWeatherMap:
+		return &openWeatherMapProvider{config: config, client: client}, nil
+
+	case weatherProviderOpenMeteo:
+		// Open-Meteo has no location search, without coordinates it would forecast for 0, 0
+		if config.Latitude == 0 && config.Longitude == 0 {
+			return nil, fmt.Errorf("Open-Meteo needs the latitude and longitude of the station")
+		}
+		return &openMeteoProvider{config: config, client: client}, nil
+
+	case weatherProviderFile:
+		return &fileWeatherProvider{path: config.Path}, nil
+
+	default:
+		return nil, fmt.Errorf("Unknown weather provider '%s'", config.Provider)
+	}
+}
+
+func downloadJSON(client *http.Client, url string, out interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Received status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWeatherLocationIsRemembered(t *testing.T) {
+	dataPath := t.TempDir()
+	if location := loadWeatherLocation(dataPath); location != nil {
+		t.Fatalf("expected no location before one is saved, got %+v", location)
+	}
+
+	saved := &WeatherLocation{Name: "Auckland", Latitude: -36.8485, Longitude: 174.7633}
+	if err := saveWeatherLocation(dataPath, saved); err != nil {
+		t.Fatalf("unable to save location: %v", err)
+	}
+	location := loadWeatherLocation(dataPath)
+	if location == nil || *location != *saved {
+		t.Fatalf("expected %+v, got %+v", saved, location)
+	}
+
+	// A restarted service can answer sunrise and sunset before the provider has been reached
+	service := &weatherService{location: location}
+	if results := service.GetSunriseSunset(time.Now()); results == nil || results.Sunrise == "" {
+		t.Fatalf("expected sunrise from the remembered location, got %+v", results)
+	}
+}
+
+func TestSunriseSunsetDownload(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(status)
+		resp.Write([]byte(`{"results":{"sunrise":"2026-10-19T18:05:00+00:00"},"status":"OK"}`))
+	}))
+	defer server.Close()
+
+	service := &weatherService{client: server.Client()}
+	config := &weatherConfiguration{SunriseSunsetURL: server.URL}
+	location := WeatherLocation{Latitude: -36.8485, Longitude: 174.7633}
+	if _, err := service.downloadSunriseSunset(location, time.Now(), config); err == nil {
+		t.Fatal("expected an error for a failed request, even with a body that looks right")
+	}
+
+	status = http.StatusOK
+	results, err := service.downloadSunriseSunset(location, time.Now(), config)
+	if err != nil || results.Sunrise != "2026-10-19T18:05:00+00:00" {
+		t.Fatalf("expected the sunrise, got %+v: %v", results, err)
+	}
+}
+
+func TestWeatherRetriesBackOff(t *testing.T) {
+	service := &weatherService{}
+	endpoint := &weatherEndpoint{name: "forecast", period: 3 * time.Minute, fetch: func() error { return errors.New("offline") }}
+
+	for _, want := range []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		service.fetch(endpoint)
+		if delay := endpoint.next.Sub(endpoint.lastAttempt); delay != want {
+			t.Fatalf("after %d failures expected a wait of %v, got %v", endpoint.failures, want, delay)
+		}
+	}
+
+	endpoint.fetch = func() error { return nil }
+	service.fetch(endpoint)
+	if endpoint.failures != 0 || endpoint.lastError != "offline" || !endpoint.lastSuccess.Equal(endpoint.lastAttempt) {
+		t.Fatalf("expected a success to reset the failures and keep the last error, got %+v", endpoint)
+	}
+}
+
+func TestWeatherServiceStateIsSafeToRead(t *testing.T) {
+	service := &weatherService{}
+	done := make(chan int)
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				service.SourceName()
+				service.Status()
+			}
+		}
+	}()
+
+	// The file provider has no files, so downloads fail quietly while the API keeps asking
+	if err := service.Start(&weatherConfiguration{Provider: weatherProviderFile, Path: t.TempDir()}, t.TempDir()); err != nil {
+		t.Fatalf("unable to start: %v", err)
+	}
+	if service.SourceName() != defaultWeatherSource || !service.Status().IsRunning {
+		t.Fatalf("expected the running service to name its source, got '%s'", service.SourceName())
+	}
+	if err := service.Stop(time.Second); err != nil {
+		t.Fatalf("unable to stop: %v", err)
+	}
+	close(done)
+	readers.Wait()
+	if service.SourceName() != "" || service.Status().IsRunning {
+		t.Fatal("expected the stopped service to have no source")
+	}
+}
@@ -1,27 +1,40 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
-	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
 type webAPI struct {
-	Router   *mux.Router
-	addr     string
-	data     *dataStore
-	monitors *monitorStore
-	config   *appConfiguration
-	upgrader websocket.Upgrader
-	hub      *websocketHub
-	weather  *weatherService
+	Router    *mux.Router
+	addr      string
+	data      *dataStore
+	monitors  *monitorStore
+	config    *appConfiguration
+	upgrader  websocket.Upgrader
+	hub       *websocketHub
+	weather   *weatherService
+	health    *stationHealthChecker
+	stations  *stationClient
+	replicas  *stationReplicator
+	discovery *discoveryService
+	users     *userStore
+	audit     *auditLog
+	metrics   *metricsCollector
+	influx    *influxExporter
+	schedules *scheduler
+	speech    speechSynthesizer
 }
 
 type itemStatus struct {
@@ -29,25 +42,53 @@ type itemStatus struct {
 	Status string `json:"status"`
 }
 
+type serverInformation struct {
+	Version    string `json:"version"`
+	APIVersion int    `json:"apiVersion"`
+}
+
 type sourceDetails struct {
 	Name      string   `json:"name,omitempty"`
 	Sensors   []string `json:"sensors"`
 	Effectors []string `json:"effectors"`
 }
 
-func newWebAPI(addr string, data *dataStore, monitors *monitorStore, weather *weatherService, config *appConfiguration) (*webAPI, error) {
+func newWebAPI(addr string, data *dataStore, monitors *monitorStore, weather *weatherService, health *stationHealthChecker, replicas *stationReplicator, discovery *discoveryService, config *appConfiguration) (*webAPI, error) {
 	api := webAPI{
-		addr:     addr,
-		data:     data,
-		monitors: monitors,
-		weather:  weather,
-		config:   config,
+		addr:      addr,
+		data:      data,
+		monitors:  monitors,
+		weather:   weather,
+		health:    health,
+		stations:  newStationClient(config),
+		replicas:  replicas,
+		discovery: discovery,
+		config:    config,
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
 		},
 		hub: newHub(),
 	}
+	audit, err := openAuditLog(config.DataPath)
+	if err != nil {
+		return nil, err
+	}
+	api.audit = audit
+
+	speech, err := newSpeechSynthesizer(config.Speech)
+	if err != nil {
+		return nil, err
+	}
+	api.speech = speech
+
+	if config.Authentication != nil && !config.Authentication.IsDisabled {
+		users, err := loadUserStore(config.DataPath, config.Authentication)
+		if err != nil {
+			return nil, err
+		}
+		api.users = users
+	}
 	api.Router = api.initialise(addr)
 	return &api, nil
 }
@@ -56,6 +97,24 @@ func (api *webAPI) initialise(addr string) *mux.Router {
 	router := mux.NewRouter().PathPrefix("/api").Subrouter()
 	router.KeepContext = true
 
+	// Methods for identifying the server
+	router.HandleFunc("/info", api.getServerInformation).Methods("GET")
+
+	// Methods for authenticating users
+	router.HandleFunc("/login", api.login).Methods("POST")
+	router.HandleFunc("/logout", api.logout).Methods("POST")
+	router.HandleFunc("/me", api.getCurrentUser).Methods("GET")
+	router.HandleFunc("/me/password", api.changePassword).Methods("POST")
+	router.HandleFunc("/users", api.listUsers).Methods("GET")
+	router.HandleFunc("/users", api.saveUser).Methods("POST")
+	router.HandleFunc("/tokens", api.listTokens).Methods("GET")
+	router.HandleFunc("/tokens", api.createToken).Methods("POST")
+	router.HandleFunc("/tokens/{token}", api.revokeToken).Methods("DELETE")
+
+	// Methods for reviewing the audit log
+	router.HandleFunc("/audit", api.searchAuditLog).Methods("GET")
+	router.HandleFunc("/influx/backfill", api.backfillInflux).Methods("POST")
+
 	// Methods for working with sources
 	router.HandleFunc("/sources", api.listSources).Methods("GET")
 	router.HandleFunc("/sources/{source}", api.getSourceDetails).Methods("GET")
@@ -70,6 +129,9 @@ func (api *webAPI) initialise(addr string) *mux.Router {
 
 	// Methods for working with stations
 	router.HandleFunc("/stations", api.getStations).Methods("GET")
+	router.HandleFunc("/stations/replication", api.getReplicationProgress).Methods("GET")
+	router.HandleFunc("/stations/discovered", api.listDiscoveredStations).Methods("GET")
+	router.HandleFunc("/stations/discovered/{station}", api.adoptDiscoveredStation).Methods("POST")
 	router.HandleFunc("/stations/{station}", api.getStationDetails).Methods("GET")
 	router.HandleFunc("/stations/{station}/sources/{source}/values", api.listStationSourceValues).Methods("GET")
 	router.HandleFunc("/stations/{station}/sources/{source}/effectors", api.processStationSourceCommand).Methods("POST")
@@ -81,7 +143,12 @@ func (api *webAPI) initialise(addr string) *mux.Router {
 	// Methods for retrieving weather information
 	router.HandleFunc("/weather", api.getWeather).Methods("GET")
 	router.HandleFunc("/weather/raw", api.getRawWeather).Methods("GET")
+	router.HandleFunc("/weather/status", api.getWeatherStatus).Methods("GET")
+	router.HandleFunc("/weather/alerts", api.getWeatherAlerts).Methods("GET")
+	router.HandleFunc("/weather/history", api.getWeatherHistory).Methods("GET")
+	router.HandleFunc("/weather/accuracy", api.getWeatherAccuracy).Methods("GET")
 	router.HandleFunc("/sun", api.getSunriseSunset).Methods("GET")
+	router.HandleFunc("/schedules", api.listSchedules).Methods("GET")
 
 	// Methods for initialising a websocket
 	router.HandleFunc("/ws", api.startWebsocket).Methods("GET")
@@ -122,30 +189,302 @@ func (api *webAPI) writeDataJSON(resp http.ResponseWriter, statusCode int, data
 	}
 }
 
+func (api *webAPI) getServerInformation(resp http.ResponseWriter, req *http.Request) {
+	out := serverInformation{
+		Version:    serverVersion,
+		APIVersion: apiVersion,
+	}
+	api.writeDataJSON(resp, http.StatusOK, out)
+}
+
+func (api *webAPI) retrieveUser(resp http.ResponseWriter, req *http.Request) *user {
+	if api.users == nil {
+		api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Authentication is not enabled")
+		return nil
+	}
+
+	item := userFromRequest(req)
+	if item == nil {
+		api.writeStatusJSON(resp, http.StatusUnauthorized, "Unauthorized", "Authentication required")
+	}
+	return item
+}
+
+func (api *webAPI) login(resp http.ResponseWriter, req *http.Request) {
+	if api.users == nil {
+		api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Authentication is not enabled")
+		return
+	}
+
+	cmd := &struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}{}
+	if err := json.NewDecoder(req.Body).Decode(cmd); err != nil {
+		log.Printf("[API] ERROR: Unable to parse incoming JSON: %v", err)
+		api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid login")
+		return
+	}
+
+	item, err := api.users.Authenticate(cmd.Name, cmd.Password)
+	if err != nil {
+		log.Printf("[API] Login failed for %s from %s: %v", cmd.Name, req.RemoteAddr, err)
+		api.writeStatusJSON(resp, http.StatusUnauthorized, "Unauthorized", "Invalid name or password")
+		return
+	}
+
+	id, expires, err := api.users.StartSession(item.Name)
+	if err != nil {
+		log.Printf("[API] ERROR: Unable to start session: %v", err)
+		api.writeStatusJSON(resp, http.StatusInternalServerError, "Error", "Unable to start session")
+		return
+	}
+
+	csrfToken, err := generateSecret(16)
+	if err != nil {
+		log.Printf("[API] ERROR: Unable to start session: %v", err)
+		api.writeStatusJSON(resp, http.StatusInternalServerError, "Error", "Unable to start session")
+		return
+	}
+
+	log.Printf("[API] User %s logged in", item.Name)
+	http.SetCookie(resp, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(resp, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	api.writeStatusJSON(resp, http.StatusOK, "Ok", "Logged in")
+}
+
+func (api *webAPI) logout(resp http.ResponseWriter, req *http.Request) {
+	if cookie, err := req.Cookie(sessionCookie); err == nil && api.users != nil {
+		api.users.EndSession(cookie.Value)
+	}
+	for _, name := range []string{sessionCookie, csrfCookie} {
+		http.SetCookie(resp, &http.Cookie{
+			Name:   name,
+			Value:  "",
+			Path:   "/",
+			MaxAge: -1,
+		})
+	}
+	api.writeStatusJSON(resp, http.StatusOK, "Ok", "Logged out")
+}
+
+func (api *webAPI) getCurrentUser(resp http.ResponseWriter, req *http.Request) {
+	item := api.retrieveUser(resp, req)
+	if item == nil {
+		return
+	}
+
+	out := struct {
+		Name  string   `json:"name"`
+		Roles []string `json:"roles"`
+	}{
+		Name:  item.Name,
+		Roles: item.Roles,
+	}
+	api.writeDataJSON(resp, http.StatusOK, out)
+}
+
+func (api *webAPI) changePassword(resp http.ResponseWriter, req *http.Request) {
+	item := api.retrieveUser(resp, req)
+	if item == nil {
+		return
+	}
+
+	cmd := &struct {
+		Current  string `json:"current"`
+		Password string `json:"password"`
+	}{}
+	if err := json.NewDecoder(req.Body).Decode(cmd); err != nil {
+		log.Printf("[API] ERROR: Unable to parse incoming JSON: %v", err)
+		api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid command")
+		return
+	}
+
+	if _, err := api.users.Authenticate(item.Name, cmd.Current); err != nil {
+		api.writeStatusJSON(resp, http.StatusUnauthorized, "Unauthorized", "Invalid password")
+		return
+	}
+	if err := api.users.SetPassword(item.Name, cmd.Password); err != nil {
+		api.writeStatusJSON(resp, http.StatusBadRequest, "Failure", err.Error())
+		return
+	}
+
+	api.audit.RecordChange(auditOriginREST, item.Name, fmt.Sprintf("Password changed for %s", item.Name))
+	api.writeStatusJSON(resp, http.StatusOK, "Ok", "Password changed")
+}
+
+func (api *webAPI) listUsers(resp http.ResponseWriter, req *http.Request) {
+	if api.retrieveUser(resp, req) == nil || !api.authorize(resp, req, permissionAdminister, "", "") {
+		return
+	}
+
+	out := struct {
+		Items []userDetails `json:"items"`
+	}{
+		Items: api.users.ListUsers(),
+	}
+	api.writeDataJSON(resp, http.StatusOK, out)
+}
+
+func (api *webAPI) saveUser(resp http.ResponseWriter, req *http.Request) {
+	item := api.retrieveUser(resp, req)
+	if item == nil || !api.authorize(resp, req, permissionAdminister, "", "") {
+		return
+	}
+
+	cmd := &struct {
+		Name       string   `json:"name"`
+		Password   string   `json:"password"`
+		Roles      []string `json:"roles"`
+		IsDisabled bool     `json:"disabled"`
+	}{}
+	if err := json.NewDecoder(req.Body).Decode(cmd); err != nil {
+		log.Printf("[API] ERROR: Unable to parse incoming JSON: %v", err)
+		api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid user")
+		return
+	}
+
+	for _, role := range cmd.Roles {
+		if api.config.FindRole(role) == nil {
+			api.writeStatusJSON(resp, http.StatusBadRequest, "Failure", fmt.Sprintf("Unknown role '%s'", role))
+			return
+		}
+	}
+
+	if cmd.Password != "" {
+		if err := api.users.SetPassword(cmd.Name, cmd.Password); err != nil {
+			api.writeStatusJSON(resp, http.StatusBadRequest, "Failure", err.Error())
+			return
+		}
+	}
+	if err := api.users.SetRoles(cmd.Name, cmd.Roles, cmd.IsDisabled); err != nil {
+		api.writeStatusJSON(resp, http.StatusBadRequest, "Failure", err.Error())
+		return
+	}
+
+	api.audit.RecordChange(auditOriginREST, item.Name, fmt.Sprintf("User %s saved with roles %v (disabled: %t)", cmd.Name, cmd.Roles, cmd.IsDisabled))
+	api.writeStatusJSON(resp, http.StatusOK, "Ok", "User saved")
+}
+
+func (api *webAPI) listTokens(resp http.ResponseWriter, req *http.Request) {
+	item := api.retrieveUser(resp, req)
+	if item == nil {
+		return
+	}
+
+	out := struct {
+		Items []apiToken `json:"items"`
+	}{
+		Items: api.users.ListTokens(item.Name),
+	}
+	api.writeDataJSON(resp, http.StatusOK, out)
+}
+
+func (api *webAPI) createToken(resp http.ResponseWriter, req *http.Request) {
+	item := api.retrieveUser(resp, req)
+	if item == nil {
+		return
+	}
+
+	cmd := &struct {
+		Name string `json:"name"`
+	}{}
+	if err := json.NewDecoder(req.Body).Decode(cmd); err != nil || cmd.Name == "" {
+		api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "A token name is required")
+		return
+	}
+
+	secret, token, err := api.users.CreateToken(item.Name, cmd.Name)
+	if err != nil {
+		log.Printf("[API] ERROR: Unable to create token: %v", err)
+		api.writeStatusJSON(resp, http.StatusInternalServerError, "Error", "Unable to create token")
+		return
+	}
+
+	api.audit.RecordChange(auditOriginREST, item.Name, fmt.Sprintf("Token %s (%s) created for %s", token.Name, token.ID, item.Name))
+	out := struct {
+		Token  string   `json:"token"`
+		Detail apiToken `json:"detail"`
+	}{
+		Token:  secret,
+		Detail: *token,
+	}
+	api.writeDataJSON(resp, http.StatusOK, out)
+}
+
+func (api *webAPI) revokeToken(resp http.ResponseWriter, req *http.Request) {
+	item := api.retrieveUser(resp, req)
+	if item == nil {
+		return
+	}
+
+	id := mux.Vars(req)["token"]
+	found, err := api.users.RevokeToken(item.Name, id)
+	if err != nil {
+		log.Printf("[API] ERROR: Unable to revoke token: %v", err)
+		api.writeStatusJSON(resp, http.StatusInternalServerError, "Error", "Unable to revoke token")
+		return
+	} else if !found {
+		api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Unknown token")
+		return
+	}
+
+	api.audit.RecordChange(auditOriginREST, item.Name, fmt.Sprintf("Token %s revoked for %s", id, item.Name))
+	api.writeStatusJSON(resp, http.StatusOK, "Ok", "Token revoked")
+}
+
 func (api *webAPI) listSources(resp http.ResponseWriter, req *http.Request) {
 	log.Printf("[API] Listing sources")
 	out := struct {
 		Items []itemStatus `json:"sources"`
 	}{
-		Items: make([]itemStatus, len(api.config.Sources)),
+		Items: []itemStatus{},
 	}
 
-	for pos, source := range api.config.Sources {
+	for _, source := range api.config.Sources {
+		if !api.isPermitted(req, permissionView, "", source.Name) {
+			continue
+		}
 		status := "Disabled"
 		if !source.IsDisabled {
 			status = "Active"
 		}
-		out.Items[pos] = itemStatus{
+		out.Items = append(out.Items, itemStatus{
 			Name:   source.Name,
 			Status: status,
-		}
+		})
+	}
+	if name := api.weather.SourceName(); name != "" && api.isPermitted(req, permissionView, "", name) {
+		out.Items = append(out.Items, itemStatus{
+			Name:   name,
+			Status: "Active",
+		})
 	}
 	api.writeDataJSON(resp, http.StatusOK, out)
 }
 
-func (api *webAPI) retrieveSource(resp http.ResponseWriter, req *http.Request) (string, *monitor) {
+// Permission is checked first so that a 404 does not reveal whether a source exists
+func (api *webAPI) retrieveSource(resp http.ResponseWriter, req *http.Request, level string) (string, *monitor) {
 	vars := mux.Vars(req)
 	name := vars["source"]
+	if !api.authorize(resp, req, level, "", name) {
+		return name, nil
+	}
 	store := api.monitors.Get(name)
 	if store == nil {
 		log.Printf("[API] Cannot find source %s", name)
@@ -155,8 +494,12 @@ func (api *webAPI) retrieveSource(resp http.ResponseWriter, req *http.Request) (
 }
 
 func (api *webAPI) listSourceValues(resp http.ResponseWriter, req *http.Request) {
-	name, store := api.retrieveSource(resp, req)
-	if store == nil {
+	name := mux.Vars(req)["source"]
+	if name == api.weather.SourceName() {
+		if !api.authorize(resp, req, permissionView, "", name) {
+			return
+		}
+	} else if _, store := api.retrieveSource(resp, req, permissionView); store == nil {
 		return
 	}
 
@@ -173,6 +516,9 @@ func (api *webAPI) listSourceValues(resp http.ResponseWriter, req *http.Request)
 
 	log.Printf("[API] Listing data for source %s", name)
 	items := api.data.GetLast(name, count)
+	if since, ok := req.URL.Query()["since"]; ok {
+		items = filterResultsSince(items, since[0])
+	}
 	out := struct {
 		Count int              `json:"count"`
 		Items *[]monitorResult `json:"items"`
@@ -183,8 +529,25 @@ func (api *webAPI) listSourceValues(resp http.ResponseWriter, req *http.Request)
 	api.writeDataJSON(resp, http.StatusOK, out)
 }
 
+func filterResultsSince(items *[]monitorResult, sinceText string) *[]monitorResult {
+	since, err := time.Parse(time.RFC3339, sinceText)
+	if err != nil {
+		log.Printf("[API] Ignoring invalid since time '%s': %v", sinceText, err)
+		return items
+	}
+
+	out := []monitorResult{}
+	for _, item := range *items {
+		timeStamp, err := time.Parse(time.RFC3339, item.TimeStamp)
+		if err == nil && !timeStamp.Before(since) {
+			out = append(out, item)
+		}
+	}
+	return &out
+}
+
 func (api *webAPI) getSourceDetails(resp http.ResponseWriter, req *http.Request) {
-	name, store := api.retrieveSource(resp, req)
+	name, store := api.retrieveSource(resp, req, permissionView)
 	if store == nil {
 		return
 	}
@@ -198,7 +561,7 @@ func (api *webAPI) getSourceDetails(resp http.ResponseWriter, req *http.Request)
 }
 
 func (api *webAPI) listSourceOutput(resp http.ResponseWriter, req *http.Request) {
-	name, store := api.retrieveSource(resp, req)
+	name, store := api.retrieveSource(resp, req, permissionView)
 	if store == nil {
 		return
 	}
@@ -213,7 +576,7 @@ func (api *webAPI) listSourceOutput(resp http.ResponseWriter, req *http.Request)
 }
 
 func (api *webAPI) listSourceInput(resp http.ResponseWriter, req *http.Request) {
-	name, store := api.retrieveSource(resp, req)
+	name, store := api.retrieveSource(resp, req, permissionView)
 	if store == nil {
 		return
 	}
@@ -228,7 +591,7 @@ func (api *webAPI) listSourceInput(resp http.ResponseWriter, req *http.Request)
 }
 
 func (api *webAPI) processSourceCommand(resp http.ResponseWriter, req *http.Request) {
-	name, store := api.retrieveSource(resp, req)
+	name, store := api.retrieveSource(resp, req, permissionCommand)
 	if store == nil {
 		return
 	}
@@ -241,14 +604,22 @@ func (api *webAPI) processSourceCommand(resp http.ResponseWriter, req *http.Requ
 		return
 	}
 
-	if err = store.SendCommand(cmd); err != nil {
+	origin, userName := auditOriginREST, userNameFromRequest(req)
+	if caller := stationFromRequest(req); caller != "" {
+		origin, userName = auditOriginStation, caller
+	}
+	if err = api.audit.SendCommand(store, cmd, origin, userName); err != nil {
 		msg := fmt.Sprintf("Unable to send command: %v", err)
 		log.Printf("[API] ERROR: " + msg)
 		api.writeStatusJSON(resp, http.StatusBadRequest, "Failure", msg)
 		return
 	}
 
-	log.Printf("[API] Sending %s action to %s in source %s", cmd.Action, cmd.Name, name)
+	if station := stationFromRequest(req); station != "" {
+		log.Printf("[API] Sending %s action to %s in source %s for station %s", cmd.Action, cmd.Name, name, station)
+	} else {
+		log.Printf("[API] Sending %s action to %s in source %s", cmd.Action, cmd.Name, name)
+	}
 	api.writeStatusJSON(resp, http.StatusOK, "Ok", "Command sent")
 }
 
@@ -257,14 +628,19 @@ func (api *webAPI) generateSpeechFromGET(resp http.ResponseWriter, req *http.Req
 	text := strings.Join(args["text"], " ")
 	voice := strings.Join(args["voice"], " ")
 	format := strings.Join(args["format"], " ")
-	api.generateSpeech(resp, req, text, voice, format)
+	if args.Get("weather") == "true" {
+		api.generateWeatherSpeech(resp, req, weatherPhrasingFromQuery(args), voice, format)
+		return
+	}
+	api.generateSpeech(resp, req, text, "", voice, format)
 }
 
 func (api *webAPI) generateSpeechFromPOST(resp http.ResponseWriter, req *http.Request) {
 	cmd := &struct {
-		Text   string `json:"text"`
-		Voice  string `json:"voice"`
-		Format string `json:"format"`
+		Text    string                  `json:"text"`
+		Voice   string                  `json:"voice"`
+		Format  string                  `json:"format"`
+		Weather *weatherPhrasingRequest `json:"weather"`
 	}{}
 	err := json.NewDecoder(req.Body).Decode(cmd)
 	if err != nil {
@@ -272,10 +648,22 @@ func (api *webAPI) generateSpeechFromPOST(resp http.ResponseWriter, req *http.Re
 		api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid command")
 		return
 	}
-	api.generateSpeech(resp, req, cmd.Text, cmd.Voice, cmd.Format)
+	if cmd.Weather != nil {
+		api.generateWeatherSpeech(resp, req, cmd.Weather, cmd.Voice, cmd.Format)
+		return
+	}
+	api.generateSpeech(resp, req, cmd.Text, "", cmd.Voice, cmd.Format)
+}
+
+func (api *webAPI) generateWeatherSpeech(resp http.ResponseWriter, req *http.Request, request *weatherPhrasingRequest, voice, format string) {
+	phrasing, description := api.describeWeather(resp, request)
+	if description == nil {
+		return
+	}
+	api.generateSpeech(resp, req, description.Speech, phrasing.SpeechLanguage(), voice, format)
 }
 
-func (api *webAPI) generateSpeech(resp http.ResponseWriter, req *http.Request, text, voice, format string) {
+func (api *webAPI) generateSpeech(resp http.ResponseWriter, req *http.Request, text, language, voice, format string) {
 	if text == "" {
 		log.Printf("[API] ERROR: No text to speak")
 		api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Missing text")
@@ -287,23 +675,31 @@ func (api *webAPI) generateSpeech(resp http.ResponseWriter, req *http.Request, t
 	} else {
 		voice = strings.ToLower(voice)
 	}
-	if format == "" {
-		format = "mp3"
-	} else {
-		format = strings.ToLower(format)
+	// Clients have always been given MP3 for anything else, so unknown formats keep getting the default
+	format = strings.ToLower(format)
+	if !isSpeechFormat(format) {
+		if format != "" {
+			log.Printf("[API] Unsupported speech format %s, using %s", format, api.speech.DefaultFormat())
+		}
+		format = api.speech.DefaultFormat()
 	}
-	log.Printf("[API] Saying speech '%s' with %s voice to %s", text, voice, format)
-	audio, err := generateSpeech(text, voice, format)
+	log.Printf("[API] Saying speech '%s' with %s voice to %s using %s", text, voice, format, api.speech.Name())
+	audio, err := api.speech.Synthesize(&speechRequest{
+		Text:     text,
+		Language: language,
+		Voice:    voice,
+		Format:   format,
+	})
 	if err != nil {
 		log.Printf("[API] ERROR: Unable to generate speech: %v", err)
 		api.writeStatusJSON(resp, http.StatusBadRequest, "Failure", "Unable to generate speech")
 		return
 	}
 
-	resp.Header().Set("Content-Disposition", "attachment; filename=speech."+format)
-	resp.Header().Set("Content-Type", "audio/mpeg")
-	resp.Header().Set("Content-Length", strconv.Itoa(len(audio.AudioContent)))
-	resp.Write(audio.AudioContent)
+	resp.Header().Set("Content-Disposition", "attachment; filename=speech."+audio.Format)
+	resp.Header().Set("Content-Type", speechContentType(audio.Format))
+	resp.Header().Set("Content-Length", strconv.Itoa(len(audio.Content)))
+	resp.Write(audio.Content)
 }
 
 func (api *webAPI) getStations(resp http.ResponseWriter, req *http.Request) {
@@ -312,83 +708,105 @@ func (api *webAPI) getStations(resp http.ResponseWriter, req *http.Request) {
 	if len(api.config.Sources) > 0 {
 		local = 1
 	}
+	stations := api.config.StationList()
 	out := struct {
-		Items []itemStatus `json:"items"`
+		Items []stationHealth `json:"items"`
 	}{
-		Items: make([]itemStatus, len(api.config.Stations)+local),
+		Items: make([]stationHealth, len(stations)+local),
 	}
 	if local == 1 {
-		out.Items[0] = itemStatus{
-			Name:   "local",
-			Status: "Active",
-		}
+		out.Items[0] = api.localStationHealth()
 	}
-	for pos, station := range api.config.Stations {
-		status := "Disabled"
-		if !station.IsDisabled {
-			status = "Active"
-		}
-		out.Items[pos+local] = itemStatus{
-			Name:   station.Name,
-			Status: status,
-		}
+	for pos, station := range stations {
+		out.Items[pos+local] = api.getStationHealth(station)
 	}
 	api.writeDataJSON(resp, http.StatusOK, out)
 }
 
+func (api *webAPI) localStationHealth() stationHealth {
+	return stationHealth{
+		Name:     "local",
+		Status:   stationStatusActive,
+		LastSeen: time.Now().Format(time.RFC3339),
+		Version:  serverVersion,
+	}
+}
+
+func (api *webAPI) getStationHealth(station stationConfiguration) stationHealth {
+	if health := api.health.Get(station.Name); health != nil {
+		return *health
+	}
+
+	status := stationStatusUnknown
+	if station.IsDisabled {
+		status = stationStatusDisabled
+	}
+	return stationHealth{
+		Name:   station.Name,
+		Status: status,
+	}
+}
+
 func (api *webAPI) getStationDetails(resp http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	name := vars["station"]
 	if name == "local" {
 		log.Printf("[API] Generating local station information")
 		out := struct {
+			Health  stationHealth   `json:"health"`
 			Sources []sourceDetails `json:"sources"`
 		}{
-			Sources: make([]sourceDetails, len(*api.monitors)),
+			Health:  api.localStationHealth(),
+			Sources: []sourceDetails{},
 		}
-		pos := 0
 		for name, store := range *api.monitors {
-			out.Sources[pos] = sourceDetails{
+			if !api.isPermitted(req, permissionView, "", name) {
+				continue
+			}
+			out.Sources = append(out.Sources, sourceDetails{
 				Name:      name,
 				Sensors:   store.InputTypes(),
 				Effectors: store.OutputTypes(),
-			}
-			pos++
+			})
 		}
 
 		api.writeDataJSON(resp, http.StatusOK, out)
 	} else {
+		if !api.authorize(resp, req, permissionView, name, "") {
+			return
+		}
 		station := api.config.FindStation(name)
-
 		if station == nil {
 			log.Printf("[API] Cannot find station %s", name)
 			api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Unknown station")
 			return
 		}
 
-		res, err := http.Get("http://" + station.Address + "/api/stations/local")
+		health := api.getStationHealth(*station)
+		res, err := api.stations.Get(station, "/api/stations/local")
 		if err != nil {
 			log.Printf("[API] Cannot query station %s: %v", name, err)
 			api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Station not available")
 			return
-		} else if res.StatusCode != http.StatusOK {
-			err = fmt.Errorf("Unable to retrieve from station: %s", res.Status)
-			log.Printf("[API] Cannot query station %s: %v", name, err)
-			api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Station not available")
-			return
 		}
 
 		log.Printf("[API] Generating station information for %s", name)
 		out := struct {
-			Station string          `json:"station"`
-			Sources []sourceDetails `json:"sources"`
+			Station   string          `json:"station"`
+			Health    stationHealth   `json:"health"`
+			Stale     bool            `json:"stale,omitempty"`
+			Retrieved string          `json:"retrieved"`
+			Sources   []sourceDetails `json:"sources"`
 		}{}
-		if err = json.NewDecoder(res.Body).Decode(&out); err != nil {
+		if err = json.Unmarshal(res.Body, &out); err != nil {
 			log.Printf("[API] Cannot decode JSON from station %s: %v", name, err)
 			api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Station not available")
 			return
 		}
 		out.Station = name
+		out.Health = health
+		out.Stale = res.IsStale
+		out.Retrieved = res.Retrieved.Format(time.RFC3339)
 		api.writeDataJSON(resp, http.StatusOK, out)
 	}
 }
@@ -400,42 +818,137 @@ func (api *webAPI) listStationSourceValues(resp http.ResponseWriter, req *http.R
 		log.Printf("[API] Generating local station values")
 		api.listSourceValues(resp, req)
 	} else {
+		sourceName := vars["source"]
+		if !api.authorize(resp, req, permissionView, name, sourceName) {
+			return
+		}
 		station := api.config.FindStation(name)
 		if station == nil {
 			log.Printf("[API] Cannot find station %s", name)
 			api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Unknown station")
 			return
 		}
-
-		sourceName := vars["source"]
-		res, err := http.Get("http://" + station.Address + "/api/sources/" + sourceName + "/values")
+		res, err := api.stations.Get(station, "/api/sources/"+url.PathEscape(sourceName)+"/values")
 		if err != nil {
 			log.Printf("[API] Cannot query station %s: %v", name, err)
-			api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Station not available")
-			return
-		} else if res.StatusCode != http.StatusOK {
-			err = fmt.Errorf("Unable to retrieve from station: %s", res.Status)
-			log.Printf("[API] Cannot query station %s: %v", name, err)
-			api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Station not available")
+			if !api.listReplicatedValues(resp, name, sourceName) {
+				api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Station not available")
+			}
 			return
 		}
 
 		log.Printf("[API] Generating station values from %s", name)
 		out := struct {
-			Station string           `json:"station"`
-			Count   int              `json:"count"`
-			Items   *[]monitorResult `json:"items"`
+			Station   string           `json:"station"`
+			Stale     bool             `json:"stale,omitempty"`
+			Retrieved string           `json:"retrieved"`
+			Count     int              `json:"count"`
+			Items     *[]monitorResult `json:"items"`
 		}{}
-		if err = json.NewDecoder(res.Body).Decode(&out); err != nil {
+		if err = json.Unmarshal(res.Body, &out); err != nil {
 			log.Printf("[API] Cannot decode JSON from station %s: %v", name, err)
 			api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Station not available")
 			return
 		}
 		out.Station = name
+		out.Stale = res.IsStale
+		out.Retrieved = res.Retrieved.Format(time.RFC3339)
 		api.writeDataJSON(resp, http.StatusOK, out)
 	}
 }
 
+func (api *webAPI) listReplicatedValues(resp http.ResponseWriter, station, source string) bool {
+	items := api.data.GetItems(storeKey(station, source))
+	if len(*items) == 0 {
+		return false
+	}
+
+	log.Printf("[API] Generating replicated station values from %s", station)
+	out := struct {
+		Station    string           `json:"station"`
+		Stale      bool             `json:"stale"`
+		Replicated bool             `json:"replicated"`
+		Count      int              `json:"count"`
+		Items      *[]monitorResult `json:"items"`
+	}{
+		Station:    station,
+		Stale:      true,
+		Replicated: true,
+		Count:      len(*items),
+		Items:      items,
+	}
+	api.writeDataJSON(resp, http.StatusOK, out)
+	return true
+}
+
+func (api *webAPI) getReplicationProgress(resp http.ResponseWriter, req *http.Request) {
+	log.Printf("[API] Listing replication progress")
+	out := struct {
+		Items []replicationProgress `json:"items"`
+	}{
+		Items: []replicationProgress{},
+	}
+	for _, progress := range api.replicas.Progress() {
+		if api.isPermitted(req, permissionView, progress.Station, "") {
+			out.Items = append(out.Items, progress)
+		}
+	}
+	api.writeDataJSON(resp, http.StatusOK, out)
+}
+
+func (api *webAPI) listDiscoveredStations(resp http.ResponseWriter, req *http.Request) {
+	if !api.authorize(resp, req, permissionAdminister, "", "") {
+		return
+	}
+
+	log.Printf("[API] Listing discovered stations")
+	out := struct {
+		Items []discoveredPeer `json:"items"`
+	}{
+		Items: []discoveredPeer{},
+	}
+	if api.discovery.isRunning {
+		out.Items = api.discovery.Pending()
+	}
+	api.writeDataJSON(resp, http.StatusOK, out)
+}
+
+func (api *webAPI) adoptDiscoveredStation(resp http.ResponseWriter, req *http.Request) {
+	if !api.authorize(resp, req, permissionAdminister, "", "") {
+		return
+	}
+
+	name := mux.Vars(req)["station"]
+	log.Printf("[API] Adopting discovered station %s", name)
+	var peer *discoveredPeer
+	if api.discovery.isRunning {
+		peer = api.discovery.Peer(name)
+	}
+	if peer == nil {
+		api.writeStatusJSON(resp, http.StatusNotFound, "Error", fmt.Sprintf("Station %s has not been discovered", name))
+		return
+	}
+
+	cmd := &struct {
+		Secret string `json:"secret"`
+	}{}
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(cmd); err != nil {
+			log.Printf("[API] ERROR: Unable to parse incoming JSON: %v", err)
+			api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid station")
+			return
+		}
+	}
+
+	station, isNew := api.discovery.Adopt(peer, cmd.Secret)
+	message := fmt.Sprintf("Station %s is now at %s", station.Name, station.Address)
+	if isNew {
+		message = fmt.Sprintf("Station %s adopted at %s", station.Name, station.Address)
+	}
+	api.audit.RecordChange(auditOriginREST, userNameFromRequest(req), message)
+	api.writeStatusJSON(resp, http.StatusOK, "Ok", message)
+}
+
 func (api *webAPI) processStationSourceCommand(resp http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	name := vars["station"]
@@ -443,27 +956,53 @@ func (api *webAPI) processStationSourceCommand(resp http.ResponseWriter, req *ht
 		log.Printf("[API] Processing local station command")
 		api.processSourceCommand(resp, req)
 	} else {
+		sourceName := vars["source"]
+		if !api.authorize(resp, req, permissionCommand, name, sourceName) {
+			return
+		}
 		station := api.config.FindStation(name)
 		if station == nil {
 			log.Printf("[API] Cannot find station %s", name)
 			api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Unknown station")
 			return
 		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			log.Printf("[API] ERROR: Unable to read command: %v", err)
+			api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid command")
+			return
+		}
+		cmd := &command{}
+		if err = json.Unmarshal(body, cmd); err != nil {
+			log.Printf("[API] ERROR: Unable to parse incoming JSON: %v", err)
+			api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid command")
+			return
+		}
 
-		sourceName := vars["source"]
-		res, err := http.Post(
-			"http://"+station.Address+"/api/sources/"+sourceName+"/effectors",
+		start := time.Now()
+		entry := auditEntry{
+			Time:     start.Format(time.RFC3339),
+			Type:     auditTypeCommand,
+			Origin:   auditOriginProxy,
+			User:     userNameFromRequest(req),
+			Station:  name,
+			Source:   sourceName,
+			Effector: cmd.Name,
+			Action:   cmd.Action,
+			Duration: cmd.Duration,
+		}
+		res, err := api.stations.Post(
+			station,
+			"/api/sources/"+url.PathEscape(sourceName)+"/effectors",
 			"application/json",
-			req.Body)
+			bytes.NewReader(body))
+		entry.Elapsed = time.Since(start).Milliseconds()
 		if err != nil {
+			entry.Result, entry.Message = "Failure", err.Error()
+			api.audit.Record(entry)
 			log.Printf("[API] Cannot post command to station %s: %v", name, err)
 			api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Station not available")
 			return
-		} else if res.StatusCode != http.StatusOK {
-			err = fmt.Errorf("Unable to post command to station: %s", res.Status)
-			log.Printf("[API] Cannot query station %s: %v", name, err)
-			api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Station not available")
-			return
 		}
 
 		log.Printf("[API] Retrieving command result from %s", name)
@@ -472,16 +1011,91 @@ func (api *webAPI) processStationSourceCommand(resp http.ResponseWriter, req *ht
 			Status  string `json:"status"`
 			Message string `json:"msg"`
 		}{}
-		if err = json.NewDecoder(res.Body).Decode(&out); err != nil {
+		if err = json.Unmarshal(res.Body, &out); err != nil {
+			entry.Result, entry.Message = "Unknown", err.Error()
+			api.audit.Record(entry)
 			log.Printf("[API] Cannot decode JSON from station %s: %v", name, err)
 			api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Station not available")
 			return
 		}
+		entry.Result, entry.Message = out.Status, out.Message
+		api.audit.Record(entry)
 		out.Station = name
 		api.writeDataJSON(resp, http.StatusOK, out)
 	}
 }
 
+func (api *webAPI) backfillInflux(resp http.ResponseWriter, req *http.Request) {
+	if !api.authorize(resp, req, permissionAdminister, "", "") {
+		return
+	}
+	if api.influx == nil || !api.influx.isRunning {
+		api.writeStatusJSON(resp, http.StatusNotFound, "Error", "InfluxDB export is not enabled")
+		return
+	}
+
+	log.Printf("[API] Backfilling InfluxDB")
+	count, err := api.influx.Backfill(api.data)
+	if err != nil {
+		log.Printf("[API] Unable to backfill InfluxDB: %v", err)
+		api.writeStatusJSON(resp, http.StatusBadGateway, "Failure", fmt.Sprintf("Sent %d results before failing: %v", count, err))
+		return
+	}
+	api.audit.RecordChange(auditOriginREST, userNameFromRequest(req), fmt.Sprintf("Backfilled %d results to InfluxDB", count))
+	api.writeStatusJSON(resp, http.StatusOK, "Ok", fmt.Sprintf("Sent %d results", count))
+}
+
+func (api *webAPI) searchAuditLog(resp http.ResponseWriter, req *http.Request) {
+	if !api.authorize(resp, req, permissionAdminister, "", "") {
+		return
+	}
+
+	args := req.URL.Query()
+	filter := auditFilter{
+		Type:     args.Get("type"),
+		Origin:   args.Get("origin"),
+		User:     args.Get("user"),
+		Station:  args.Get("station"),
+		Source:   args.Get("source"),
+		Effector: args.Get("effector"),
+	}
+	var err error
+	if text := args.Get("from"); text != "" {
+		if filter.From, err = time.Parse(time.RFC3339, text); err != nil {
+			api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid from time")
+			return
+		}
+	}
+	if text := args.Get("to"); text != "" {
+		if filter.To, err = time.Parse(time.RFC3339, text); err != nil {
+			api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid to time")
+			return
+		}
+	}
+	if text := args.Get("count"); text != "" {
+		if filter.Limit, err = strconv.Atoi(text); err != nil {
+			api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid count")
+			return
+		}
+	}
+
+	log.Printf("[API] Searching audit log")
+	items, err := api.audit.Search(filter)
+	if err != nil {
+		log.Printf("[API] ERROR: Unable to search audit log: %v", err)
+		api.writeStatusJSON(resp, http.StatusInternalServerError, "Error", "Unable to search audit log")
+		return
+	}
+	out := struct {
+		Count int          `json:"count"`
+		Items []auditEntry `json:"items"`
+	}{
+		Count: len(items),
+		Items: items,
+	}
+	api.writeDataJSON(resp, http.StatusOK, out)
+}
+
 func (api *webAPI) getRooms(resp http.ResponseWriter, req *http.Request) {
 	log.Printf("[API] Listing rooms")
 	out := struct {
@@ -503,8 +1117,14 @@ func (api *webAPI) getRooms(resp http.ResponseWriter, req *http.Request) {
 }
 
 func (api *webAPI) getRoomDetails(resp http.ResponseWriter, req *http.Request) {
-	name, store := api.retrieveSource(resp, req)
-	if store == nil {
+	name := mux.Vars(req)["room"]
+	room := api.config.findRoom(name)
+	if !api.authorizeRoom(resp, req, permissionView, name, room) {
+		return
+	}
+	if room == nil {
+		log.Printf("[API] Cannot find room %s", name)
+		api.writeStatusJSON(resp, http.StatusNotFound, "Error", "Unknown room")
 		return
 	}
 
@@ -517,71 +1137,70 @@ func (api *webAPI) getRoomDetails(resp http.ResponseWriter, req *http.Request) {
 	api.writeDataJSON(resp, http.StatusOK, out)
 }
 
-func (api *webAPI) getWeather(resp http.ResponseWriter, req *http.Request) {
-	oneWord := ""
-	current := api.weather.GetCurrentWeather()
-	var currentTemp, minTemp, maxTemp float64
+func weatherPhrasingFromQuery(args url.Values) *weatherPhrasingRequest {
+	request := &weatherPhrasingRequest{
+		Phrasing: args.Get("phrasing"),
+		Room:     args.Get("room"),
+		Language: args.Get("language"),
+		Units:    args.Get("units"),
+		Include:  args.Get("include"),
+	}
+	if variant, err := strconv.Atoi(args.Get("variant")); err == nil {
+		request.Variant = &variant
+	}
+	return request
+}
 
-	currentWeather := "There is no weather information"
-	if current != nil && len(current.Weather) > 0 {
-		oneWord = current.Weather[0].Main
-		currentWeather = fmt.Sprintf(
-			"The current weather is %s, the temperature is %.f°C",
-			current.Weather[0].Description,
-			current.Main.Temperature)
-		currentTemp = current.Main.Temperature
+func (api *webAPI) describeWeather(resp http.ResponseWriter, request *weatherPhrasingRequest) (*weatherPhrasing, *weatherDescription) {
+	phrasing, err := newWeatherPhrasing(api.config, request)
+	if err != nil {
+		log.Printf("[API] ERROR: Invalid weather phrasing: %v", err)
+		api.writeStatusJSON(resp, http.StatusBadRequest, "Error", err.Error())
+		return nil, nil
 	}
 
 	forecast := api.weather.GetWeatherForecast()
 	if forecast == nil {
 		api.writeStatusJSON(resp, http.StatusServiceUnavailable, "Not available", "Weather information has not been downloaded")
-		return
+		return nil, nil
 	}
 
-	weatherForecast, listLen := "There is no forecast", len(forecast.List)
-	if forecast != nil && listLen > 0 {
-		item := forecast.List[0]
-		minTemp, maxTemp = item.Main.MinimumTemperature, item.Main.MaximumTemperature
-		for loop := 1; loop < 8 && loop < listLen; loop++ {
-			temp := forecast.List[loop].Main
-			if minTemp > temp.MinimumTemperature {
-				minTemp = temp.MinimumTemperature
-			}
-			if maxTemp < temp.MaximumTemperature {
-				maxTemp = temp.MaximumTemperature
-			}
-		}
-		if math.Abs(minTemp-maxTemp) > 0.5 {
-			weatherForecast = fmt.Sprintf(
-				"The forecast is %s, with a temperature between %.f and %.f°C",
-				item.Weather[0].Description,
-				minTemp,
-				maxTemp)
-		} else {
-			weatherForecast = fmt.Sprintf(
-				"The forecast is %s, with a temperature of %.f°C",
-				item.Weather[0].Description,
-				maxTemp)
-		}
+	description, err := phrasing.Describe(api.weather.GetCurrentWeather(), forecast, api.weather.Alerts().Active())
+	if err != nil {
+		log.Printf("[API] ERROR: Unable to describe weather: %v", err)
+		api.writeStatusJSON(resp, http.StatusInternalServerError, "Failure", "Unable to describe weather")
+		return nil, nil
+	}
+	return phrasing, description
+}
+
+func (api *webAPI) getWeather(resp http.ResponseWriter, req *http.Request) {
+	_, description := api.describeWeather(resp, weatherPhrasingFromQuery(req.URL.Query()))
+	if description == nil {
+		return
 	}
 
 	item := struct {
 		Current     string `json:"current"`
 		OneWord     string `json:"oneWord"`
 		Forecast    string `json:"forecast"`
+		Speech      string `json:"speech"`
+		Units       string `json:"units"`
 		Temperature struct {
 			Minimum float64 `json:"min"`
 			Current float64 `json:"current"`
 			Maximum float64 `json:"max"`
 		} `json:"temperature"`
 	}{
-		Current:  currentWeather,
-		OneWord:  oneWord,
-		Forecast: weatherForecast,
+		Current:  description.Current,
+		OneWord:  description.OneWord,
+		Forecast: description.Forecast,
+		Speech:   description.Speech,
+		Units:    description.Units,
 	}
-	item.Temperature.Minimum = minTemp
-	item.Temperature.Current = currentTemp
-	item.Temperature.Maximum = maxTemp
+	item.Temperature.Minimum = description.Minimum
+	item.Temperature.Current = description.Temperature
+	item.Temperature.Maximum = description.Maximum
 	api.writeDataJSON(resp, 200, item)
 }
 
@@ -596,11 +1215,183 @@ func (api *webAPI) getRawWeather(resp http.ResponseWriter, req *http.Request) {
 	api.writeDataJSON(resp, 200, item)
 }
 
+func (api *webAPI) getWeatherStatus(resp http.ResponseWriter, req *http.Request) {
+	status := api.weather.Status()
+	if status == nil {
+		api.writeStatusJSON(resp, http.StatusServiceUnavailable, "Not available", "The weather service is not running")
+		return
+	}
+	api.writeDataJSON(resp, http.StatusOK, status)
+}
+
+func (api *webAPI) getWeatherAlerts(resp http.ResponseWriter, req *http.Request) {
+	alerts := api.weather.Alerts()
+	out := struct {
+		Active []weatherAlert `json:"active"`
+		Recent []weatherAlert `json:"recent"`
+	}{
+		Active: alerts.Active(),
+		Recent: alerts.Recent(),
+	}
+	api.writeDataJSON(resp, http.StatusOK, out)
+}
+
+func (api *webAPI) announceWeatherAlert(event *weatherAlertEvent) {
+	if event.Status != weatherAlertRaised {
+		return
+	}
+	phrasing, err := newWeatherPhrasing(api.config, &weatherPhrasingRequest{})
+	if err != nil {
+		log.Printf("[API] Unable to announce weather alert: %v", err)
+		return
+	}
+	if event.Announcement, err = phrasing.Announce(&event.Alert); err != nil {
+		log.Printf("[API] Unable to announce weather alert: %v", err)
+		return
+	}
+	event.Language = phrasing.SpeechLanguage()
+}
+
+func (api *webAPI) getWeatherHistory(resp http.ResponseWriter, req *http.Request) {
+	history := api.weather.History()
+	if history == nil {
+		api.writeStatusJSON(resp, http.StatusServiceUnavailable, "Not available", "Weather history is not being kept")
+		return
+	}
+
+	var from, to time.Time
+	var err error
+	args := req.URL.Query()
+	if text := args.Get("from"); text != "" {
+		if from, err = time.Parse(time.RFC3339, text); err != nil {
+			api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid from time")
+			return
+		}
+	}
+	if text := args.Get("to"); text != "" {
+		if to, err = time.Parse(time.RFC3339, text); err != nil {
+			api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid to time")
+			return
+		}
+	}
+
+	log.Printf("[API] Retrieving weather history")
+	observations, err := history.Observations(from, to)
+	if err != nil {
+		log.Printf("[API] Unable to read weather history: %v", err)
+		api.writeStatusJSON(resp, http.StatusInternalServerError, "Failure", "Unable to read weather history")
+		return
+	}
+	forecasts, err := history.Forecasts(from, to)
+	if err != nil {
+		log.Printf("[API] Unable to read weather history: %v", err)
+		api.writeStatusJSON(resp, http.StatusInternalServerError, "Failure", "Unable to read weather history")
+		return
+	}
+
+	out := struct {
+		Observations []weatherObservation    `json:"observations"`
+		Forecasts    []weatherForecastRecord `json:"forecasts"`
+	}{
+		Observations: observations,
+		Forecasts:    forecasts,
+	}
+	api.writeDataJSON(resp, http.StatusOK, out)
+}
+
+func (api *webAPI) getWeatherAccuracy(resp http.ResponseWriter, req *http.Request) {
+	history := api.weather.History()
+	if history == nil {
+		api.writeStatusJSON(resp, http.StatusServiceUnavailable, "Not available", "Weather history is not being kept")
+		return
+	}
+
+	var from, to time.Time
+	var err error
+	args := req.URL.Query()
+	if text := args.Get("from"); text != "" {
+		if from, err = time.Parse(time.RFC3339, text); err != nil {
+			api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid from time")
+			return
+		}
+	}
+	if text := args.Get("to"); text != "" {
+		if to, err = time.Parse(time.RFC3339, text); err != nil {
+			api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid to time")
+			return
+		}
+	}
+
+	log.Printf("[API] Calculating forecast accuracy")
+	forecasts, err := history.Forecasts(from, to)
+	if err != nil {
+		log.Printf("[API] Unable to read weather history: %v", err)
+		api.writeStatusJSON(resp, http.StatusInternalServerError, "Failure", "Unable to read weather history")
+		return
+	}
+	// Observations have to continue past the end to grade the last forecasts
+	observations, err := history.Observations(from, time.Time{})
+	if err != nil {
+		log.Printf("[API] Unable to read weather history: %v", err)
+		api.writeStatusJSON(resp, http.StatusInternalServerError, "Failure", "Unable to read weather history")
+		return
+	}
+
+	out := struct {
+		Forecasts    int                `json:"forecasts"`
+		Observations []*horizonAccuracy `json:"observations"`
+		Sensors      []*horizonAccuracy `json:"sensors,omitempty"`
+		SensorsFrom  string             `json:"sensorsFrom,omitempty"`
+	}{
+		Forecasts:    len(forecasts),
+		Observations: calculateForecastAccuracy(forecasts, observationSeries(observations)),
+	}
+	if sensors := api.config.Weather.OutdoorSensors; sensors != nil {
+		series, earliest := sensorSeries(api.data.Snapshot()[storeKey(sensors.Station, sensors.Source)], sensors)
+		out.Sensors = calculateForecastAccuracy(forecasts, series)
+		if !earliest.IsZero() {
+			out.SensorsFrom = earliest.Format(time.RFC3339)
+		}
+	}
+	api.writeDataJSON(resp, http.StatusOK, out)
+}
+
+func (api *webAPI) listSchedules(resp http.ResponseWriter, req *http.Request) {
+	log.Printf("[API] Listing schedules")
+	out := struct {
+		Items []scheduleStatus `json:"schedules"`
+	}{
+		Items: []scheduleStatus{},
+	}
+	if api.schedules != nil {
+		for _, status := range api.schedules.Status() {
+			if api.isPermitted(req, permissionView, "", status.Source) {
+				out.Items = append(out.Items, status)
+			}
+		}
+	}
+	api.writeDataJSON(resp, http.StatusOK, out)
+}
+
 func (api *webAPI) getSunriseSunset(resp http.ResponseWriter, req *http.Request) {
+	date := time.Now()
+	if text := req.URL.Query().Get("date"); text != "" {
+		var err error
+		if date, err = time.ParseInLocation("2006-01-02", text, time.Local); err != nil {
+			api.writeStatusJSON(resp, http.StatusBadRequest, "Error", "Invalid date")
+			return
+		}
+	}
+
+	results := api.weather.GetSunriseSunset(date)
+	if results == nil {
+		api.writeStatusJSON(resp, http.StatusServiceUnavailable, "Not available", "The location is not known")
+		return
+	}
 	item := struct {
 		Results interface{} `json:"results"`
 	}{
-		Results: api.weather.GetSunriseSunset(),
+		Results: results,
 	}
 	api.writeDataJSON(resp, 200, item)
 }
@@ -613,7 +1404,14 @@ func (api *webAPI) startWebsocket(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	client := &websocketClient{hub: api.hub, conn: conn, send: make(chan []byte, 256)}
+	client := &websocketClient{
+		hub:  api.hub,
+		conn: conn,
+		send: make(chan []byte, 256),
+		isPermitted: func(station, source string) bool {
+			return api.isPermitted(req, permissionView, station, source)
+		},
+	}
 	client.hub.register <- client
 
 	go client.writePump()
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -16,16 +17,23 @@ const (
 	maxMessageSize = 1024
 )
 
+type websocketMessage struct {
+	station string
+	source  string
+	data    []byte
+}
+
 type websocketHub struct {
 	clients    map[*websocketClient]bool
-	broadcast  chan []byte
+	broadcast  chan *websocketMessage
 	register   chan *websocketClient
 	unregister chan *websocketClient
+	count      int64
 }
 
 func newHub() *websocketHub {
 	return &websocketHub{
-		broadcast:  make(chan []byte),
+		broadcast:  make(chan *websocketMessage),
 		register:   make(chan *websocketClient),
 		unregister: make(chan *websocketClient),
 		clients:    make(map[*websocketClient]bool),
@@ -38,17 +46,33 @@ func (hub *websocketHub) sendResult(result *monitorResult) error {
 		return fmt.Errorf("Unable to marshal result: %v", err)
 	}
 
-	hub.broadcast <- data
+	hub.broadcast <- &websocketMessage{station: result.Station, source: result.Source, data: data}
 
 	return nil
 }
 
+func (hub *websocketHub) sendEvent(event interface{}, station string) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal event: %v", err)
+	}
+
+	hub.broadcast <- &websocketMessage{station: station, data: data}
+
+	return nil
+}
+
+func (hub *websocketHub) ClientCount() int64 {
+	return atomic.LoadInt64(&hub.count)
+}
+
 func (hub *websocketHub) run() {
 	for {
 		select {
 		case client := <-hub.register:
 			log.Printf("[WebSocket] Adding client")
 			hub.clients[client] = true
+			atomic.StoreInt64(&hub.count, int64(len(hub.clients)))
 
 		case client := <-hub.unregister:
 			log.Printf("[WebSocket] Removing client")
@@ -56,25 +80,39 @@ func (hub *websocketHub) run() {
 				delete(hub.clients, client)
 				close(client.send)
 			}
+			atomic.StoreInt64(&hub.count, int64(len(hub.clients)))
 
 		case message := <-hub.broadcast:
 			log.Printf("[WebSocket] Broadcasting message")
 			for client := range hub.clients {
+				if !client.canView(message) {
+					continue
+				}
 				select {
-				case client.send <- message:
+				case client.send <- message.data:
 				default:
 					close(client.send)
 					delete(hub.clients, client)
 				}
 			}
+			atomic.StoreInt64(&hub.count, int64(len(hub.clients)))
 		}
 	}
 }
 
 type websocketClient struct {
-	hub  *websocketHub
-	conn *websocket.Conn
-	send chan []byte
+	hub         *websocketHub
+	conn        *websocket.Conn
+	send        chan []byte
+	isPermitted func(station, source string) bool
+}
+
+// Messages that are not about a station or source, such as weather alerts, go to every client
+func (c *websocketClient) canView(message *websocketMessage) bool {
+	if c.isPermitted == nil || (message.station == "" && message.source == "") {
+		return true
+	}
+	return c.isPermitted(message.station, message.source)
 }
 
 func (c *websocketClient) close() {